@@ -0,0 +1,100 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+)
+
+// AppAllow restricts which update requests may deploy an app. Each list
+// holds path.Match patterns; an empty list allows anything.
+//
+//	apps:
+//	  - name: api
+//	    allow:
+//	      repos: [acme/api]
+//	      tags: ["v*"]
+//	      url_hosts: [api.github.com]
+//	      secret: true   # signed with DEPLOY_HMAC_SECRET/api only
+//	      signing_keys: [ci-api]
+type AppAllow struct {
+	Repos    []string `yaml:"repos"`     // UpdateRequest.Repo, owner/name
+	Tags     []string `yaml:"tags"`      // UpdateRequest.Tag
+	URLHosts []string `yaml:"url_hosts"` // host of UpdateRequest.DownloadURL
+	Secret   bool     `yaml:"secret"`    // require the app's own HMAC secret, see AppSecretKey and GitLabWebhookTokenKey
+	// SigningKeys are the IDs of the updater.signing_keys accepted for the
+	// app. Empty accepts every key, unless Secret is set: the app then
+	// accepts no ed25519 signature.
+	SigningKeys []string `yaml:"signing_keys"`
+}
+
+// AppSecretKey returns the Store key of the HMAC secret of app, used instead
+// of the shared key set when allow.secret is set.
+func AppSecretKey(app string) string {
+	return "DEPLOY_HMAC_SECRET/" + app
+}
+
+// authorize checks an authenticated request against the rules of app: its
+// allow lists and, for OIDC tokens, its oidc claims.
+func authorize(app *AppConfig, req *UpdateRequest, claims *OIDCToken) error {
+	if claims != nil {
+		if app.OIDC == nil {
+			return errors.New("app accepts no OIDC tokens")
+		}
+		if err := app.OIDC.allows(claims); err != nil {
+			return err
+		}
+		if req.Repo != "" && req.Repo != claims.Repository {
+			return fmt.Errorf("repo %q differs from the token's repository %q", req.Repo, claims.Repository)
+		}
+	}
+	a := app.Allow
+	if a == nil {
+		return nil
+	}
+	repo := req.Repo
+	if repo == "" && claims != nil {
+		repo = claims.Repository
+	}
+	if !matchAny(a.Repos, repo) {
+		return fmt.Errorf("repo %q not allowed", repo)
+	}
+	if !matchAny(a.Tags, req.Tag) {
+		return fmt.Errorf("tag %q not allowed", req.Tag)
+	}
+	if len(a.URLHosts) > 0 {
+		u, err := url.Parse(req.DownloadURL)
+		if err != nil || !matchAny(a.URLHosts, u.Hostname()) {
+			return fmt.Errorf("download URL %q not allowed", req.DownloadURL)
+		}
+	}
+	return nil
+}
+
+// allowsSigningKey reports whether ed25519 signatures of keyID may deploy
+// app, nil meaning no app.
+func allowsSigningKey(app *AppConfig, keyID string) bool {
+	if app == nil || app.Allow == nil {
+		return true
+	}
+	if len(app.Allow.SigningKeys) == 0 {
+		return !app.Allow.Secret
+	}
+	return slices.Contains(app.Allow.SigningKeys, keyID)
+}
+
+// matchAny reports whether value matches one of patterns, or patterns is
+// empty.
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+	return false
+}
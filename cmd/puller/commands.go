@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+// commands are the subcommands available besides running the agent.
+// Each returns the process exit code.
+var commands = map[string]func(args []string) int{
+	"validate": validateCmd,
+	"schema":   schemaCmd,
+	"migrate":  migrateCmd,
+	"promote":  promoteCmd,
+	"sign":     signCmd,
+	"keygen":   keygenCmd,
+	"rotate":   rotateCmd,
+	"relay":    relayCmd,
+}
+
+// validateCmd checks a deploy.yaml and prints file:line:col diagnostics.
+//
+//	puller validate [-strict] [path]
+func validateCmd(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "treat warnings as errors")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puller validate [-strict] [path]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	path := fs.Arg(0)
+	if path == "" {
+		path = defaultConfigPath()
+	}
+
+	diags, err := deploy.ValidateFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, d := range diags {
+		fmt.Println(d)
+	}
+	if diags.HasErrors() || (*strict && len(diags) > 0) {
+		return 1
+	}
+	fmt.Printf("%s: ok\n", path)
+	return 0
+}
+
+// schemaCmd prints the JSON Schema of deploy.yaml, or writes it to -o.
+//
+//	puller schema [-o deploy.schema.json]
+func schemaCmd(args []string) int {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	out := fs.String("o", "", "write the schema to this file instead of stdout")
+	fs.Parse(args)
+
+	data, err := deploy.Schema()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data = append(data, '\n')
+	if *out == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// migrateCmd upgrades a deploy.yaml to the current schema version, keeping
+// a backup of the original. The agent does the same on start.
+//
+//	puller migrate [path]
+func migrateCmd(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Parse(args)
+
+	path := fs.Arg(0)
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	log := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	migrated, err := deploy.MigrateConfig(path, log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !migrated {
+		fmt.Printf("%s: already at version %d\n", path, deploy.ConfigVersion)
+	}
+	return 0
+}
+
+// promoteCmd deploys the exact artifact app runs in one environment to
+// another, by digest.
+//
+//	puller promote -from staging -to production [-config path] app
+func promoteCmd(args []string) int {
+	fs := flag.NewFlagSet("promote", flag.ExitOnError)
+	from := fs.String("from", "", "environment running the artifact")
+	to := fs.String("to", "", "environment to deploy it to")
+	path := fs.String("config", defaultConfigPath(), "deploy.yaml declaring both environments")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puller promote -from env -to env [-config path] app")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *from == "" || *to == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	store := deploy.NewSecureStore(&envStore{})
+	cfg, err := deploy.LoadWithStore(*path, store)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	res, err := deploy.Promote(context.Background(), cfg, store, fs.Arg(0), *from, *to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if res.Unchanged {
+		fmt.Printf("%s %s (%s) already running in %s\n", res.App, res.Version, res.Digest, *to)
+		return 0
+	}
+	fmt.Printf("promoted %s %s (%s) from %s to %s, deploy %s\n", res.App, res.Version, res.Digest, *from, *to, res.DeployID)
+	return 0
+}
+
+// signCmd prints the ed25519 X-Signature value of a payload, for CI. The
+// private key is read from -key or, when unset, from $DEPLOY_SIGNING_KEY.
+//
+//	puller sign [-key key.pem] [payload.json]
+func signCmd(args []string) int {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyPath := fs.String("key", "", "PEM private key file (default: $DEPLOY_SIGNING_KEY content)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: puller sign [-key key.pem] [payload file, default stdin]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	keyPEM := []byte(os.Getenv("DEPLOY_SIGNING_KEY"))
+	if *keyPath != "" {
+		var err error
+		if keyPEM, err = os.ReadFile(*keyPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	key, err := deploy.ParseSigningKey(keyPEM)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var payload []byte
+	if fs.NArg() > 0 {
+		payload, err = os.ReadFile(fs.Arg(0))
+	} else {
+		payload, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(deploy.SignEd25519(key, payload))
+	return 0
+}
+
+// keygenCmd creates an ed25519 signing key. The private key is written to
+// -o for the CI secret store; the public key is added to -config, or
+// printed when -config is empty.
+//
+//	puller keygen -id ci-2026 [-o signing-key.pem] [-config deploy.yaml]
+func keygenCmd(args []string) int {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	id := fs.String("id", "", "key ID sent in X-Signature-Key-Id")
+	out := fs.String("o", "signing-key.pem", "private key file to create")
+	config := fs.String("config", "", "deploy.yaml to add the public key to")
+	fs.Parse(args)
+	if *id == "" {
+		fs.Usage()
+		return 2
+	}
+
+	priv, pub, err := deploy.GenerateSigningKey()
+	if err == nil {
+		err = writeNewFile(*out, priv)
+	}
+	if err == nil && *config != "" {
+		err = deploy.EditConfig(*config, func(d *deploy.ConfigDoc) error {
+			return d.Set("updater.signing_keys."+*id, pub)
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *config == "" {
+		fmt.Printf("updater:\n  signing_keys:\n    %s: %s\n", *id, pub)
+	}
+	fmt.Fprintf(os.Stderr, "private key written to %s; store it as the DEPLOY_SIGNING_KEY CI secret, then delete it\n", *out)
+	return 0
+}
+
+// writeNewFile creates path readable only by its owner, refusing to
+// overwrite an existing key.
+func writeNewFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotateCmd replaces the HMAC secret, keeping the previous ones valid for
+// -overlap, and reports for each puller in -hosts whether it has loaded the
+// new key and which old keys still sign its requests. With -import the new
+// secret is one generated elsewhere instead of a random one; with -check it
+// only reports on the current key.
+//
+//	puller [-env name] rotate [-overlap 72h] [-import id:secret] [-hosts host:port,...] [-check]
+func rotateCmd(args []string) int {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	overlap := fs.Duration("overlap", 72*time.Hour, "how long previous secrets stay valid")
+	imported := fs.String("import", "", "add this id:secret instead of generating a secret")
+	hosts := fs.String("hosts", "", "comma-separated pullers to check (default: DEPLOY_SERVER_HOST)")
+	check := fs.Bool("check", false, "report on the current key without rotating")
+	fs.Parse(args)
+
+	s := store()
+	keys, err := deploy.LoadHMACKeys(s)
+	if err == nil && !*check {
+		var key deploy.HMACKey
+		if *imported != "" {
+			id, secret, _ := strings.Cut(*imported, ":")
+			key, err = deploy.ImportHMACKey(s, id, secret, *overlap)
+		} else {
+			key, err = deploy.RotateHMACKey(s, *overlap)
+		}
+		if err == nil {
+			if *imported != "" {
+				fmt.Fprintf(os.Stderr, "imported HMAC key %s; CI sends X-Signature-Key-Id: %s\n", key.ID, key.ID)
+			} else {
+				fmt.Fprintf(os.Stderr, "new HMAC key %s; set it as the DEPLOY_HMAC_SECRET CI secret and send X-Signature-Key-Id: %s\n", key.ID, key.ID)
+				fmt.Println(key.Secret)
+			}
+			keys, err = deploy.LoadHMACKeys(s)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(os.Stderr, "no HMAC secret configured")
+		return 1
+	}
+	current := keys[len(keys)-1]
+
+	if *hosts == "" {
+		*hosts, _ = s.Get("DEPLOY_SERVER_HOST")
+	}
+	code := 0
+	for _, host := range strings.Split(*hosts, ",") {
+		if host = strings.TrimSpace(host); host == "" {
+			continue
+		}
+		r, err := deploy.CheckHMACRotation(context.Background(), deploy.PullerURL(host), keys, current.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", host, err)
+			code = 1
+			continue
+		}
+		if !r.Loaded {
+			fmt.Fprintf(os.Stderr, "%s: has not loaded key %s yet\n", host, current.ID)
+		}
+		for _, k := range r.InUse {
+			fmt.Fprintf(os.Stderr, "%s: old key %s still in use, last at %s\n", host, k.ID, k.LastUsed.Format(time.RFC3339))
+		}
+		if !r.Loaded || len(r.InUse) > 0 {
+			code = 1
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s: only key %s in use\n", host, current.ID)
+	}
+	return code
+}
+
+// relayCmd runs a relay for pullers without a public port; they connect
+// out to it with updater.relay. Each puller authenticates with its own
+// token, DEPLOY_RELAY_TOKEN/<id>; -issue creates one and prints it, to be
+// stored under the same key on that puller.
+//
+//	puller relay [-addr :8080] [-cert file -key file]
+//	puller relay -issue <id>
+func relayCmd(args []string) int {
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "listen address")
+	cert := fs.String("cert", "", "TLS certificate file")
+	key := fs.String("key", "", "TLS key file")
+	issue := fs.String("issue", "", "create the token of this puller ID and print it")
+	fs.Parse(args)
+
+	s := store()
+	if *issue != "" {
+		if strings.ContainsAny(*issue, "/?#") {
+			fmt.Fprintf(os.Stderr, "invalid puller ID %q\n", *issue)
+			return 1
+		}
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		token := hex.EncodeToString(buf)
+		if err := s.Set(deploy.RelayTokenKey(*issue), token); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "store it as %s on puller %s\n", deploy.RelayTokenKey(*issue), *issue)
+		fmt.Println(token)
+		return 0
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/r/", &deploy.Relay{Keys: s, Log: slog.New(slog.NewTextHandler(os.Stderr, nil))})
+	fmt.Fprintln(os.Stderr, "relay listening on", *addr)
+	var err error
+	if *cert != "" {
+		err = http.ListenAndServeTLS(*addr, *cert, *key, mux)
+	} else {
+		err = http.ListenAndServe(*addr, mux)
+	}
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
@@ -1,50 +1,104 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/tinywasm/deploy"
 )
 
 // envStore reads deploy config from environment variables.
 // Used by the standalone daemon where kvdb is not available.
+// Environment-scoped keys map to variable names: env/production/DEPLOY_METHOD
+// is read from ENV_PRODUCTION_DEPLOY_METHOD.
 type envStore struct{}
 
+var envVarName = strings.NewReplacer("/", "_", "-", "_", ".", "_")
+
 func (e *envStore) Get(key string) (string, error) {
-	val := os.Getenv(key)
+	name := strings.ToUpper(envVarName.Replace(key))
+	val := os.Getenv(name)
 	if val == "" {
-		return "", fmt.Errorf("%s not set", key)
+		return "", fmt.Errorf("%s not set", name)
 	}
 	return val, nil
 }
 
 func (e *envStore) Set(key, value string) error {
-	return os.Setenv(key, value)
+	return os.Setenv(strings.ToUpper(envVarName.Replace(key)), value)
+}
+
+// env selects an environment of deploy.yaml, for the agent and commands.
+var env = flag.String("env", os.Getenv("DEPLOY_ENV"), "environment from deploy.yaml to run (default $DEPLOY_ENV)")
+
+// store returns the Store of the selected environment.
+func store() deploy.Store {
+	var s deploy.Store = deploy.NewSecureStore(&envStore{})
+	if *env != "" {
+		s = deploy.NewEnvStore(s, *env)
+	}
+	return s
 }
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: puller [-env name] [command]\n\ncommands: "+strings.Join(commandNames(), ", "))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if args := flag.Args(); len(args) > 0 {
+		cmd, ok := commands[args[0]]
+		if !ok {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(cmd(args[1:]))
+	}
+
 	process := deploy.NewProcessManager()
 	downloader := deploy.NewDownloader()
 	checker := deploy.NewChecker()
 
-	exePath, err := os.Executable()
-	if err != nil {
-		log.Fatalf("failed to determine executable path: %v", err)
-	}
-	configPath := filepath.Join(filepath.Dir(exePath), "config.yaml")
-
 	p := &deploy.Puller{
 		Store:      deploy.NewSecureStore(&envStore{}),
 		Process:    process,
 		Downloader: downloader,
 		Checker:    checker,
-		ConfigPath: configPath,
+		ConfigPath: defaultConfigPath(),
+		Env:        *env,
 	}
+	// Without a TUI the SetLog hook is the console; log_file adds a second sink.
+	p.SetLog(func(msgs ...any) { fmt.Fprintln(os.Stderr, msgs...) })
 
 	if err := p.Run(); err != nil {
-		log.Fatalf("puller agent failed: %v", err)
+		fatal("puller agent failed", err)
+	}
+}
+
+// defaultConfigPath is config.yaml next to the puller executable.
+func defaultConfigPath() string {
+	exePath, err := os.Executable()
+	if err != nil {
+		fatal("failed to determine executable path", err)
+	}
+	return filepath.Join(filepath.Dir(exePath), "config.yaml")
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func commandNames() []string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
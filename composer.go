@@ -43,12 +43,16 @@ func (d *Daemon) EdgeWorker() any { return d.provider }
 // Puller returns the puller handler for TUI registration (AddHandler).
 func (d *Daemon) Puller() any { return d.puller }
 
-// SetLog injects a logger into both components.
+// SetLog injects a logger into both components. Provider output is
+// redacted with the Puller's known secrets (goflare tokens included).
 func (d *Daemon) SetLog(f func(...any)) {
-	d.provider.SetLog(f)
+	d.provider.SetLog(d.puller.Secrets().Func(f))
 	d.puller.SetLog(f)
 }
 
+// Subscribe registers an observer for the Puller's deploy lifecycle events.
+func (d *Daemon) Subscribe(o Observer) (unsubscribe func()) { return d.puller.Subscribe(o) }
+
 // IsConfigured reports whether a deploy method has been stored.
 func (d *Daemon) IsConfigured() bool { return d.puller.IsConfigured() }
 
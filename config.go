@@ -1,9 +1,12 @@
 package deploy
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -11,17 +14,35 @@ import (
 
 // Config represents the application configuration.
 type Config struct {
-	Updater ConfigUpdater `yaml:"updater"`
-	Apps    []AppConfig   `yaml:"apps"`
+	SchemaVersion int                    `yaml:"version,omitempty"` // see ConfigVersion; absent means 1
+	Updater       ConfigUpdater          `yaml:"updater"`
+	Apps          []AppConfig            `yaml:"apps"`
+	Notifications []NotifierConfig       `yaml:"notifications,omitempty"`
+	AppsDir       string                 `yaml:"apps_dir,omitempty"` // per-app fragments (default: apps.d)
+	Environments  map[string]Environment `yaml:"environments,omitempty"`
+
+	refs      map[string]configRef // interpolated values by path, see MarshalYAML
+	mainApps  int                  // Apps[:mainApps] come from deploy.yaml itself
+	fragments []configFragment
 }
 
 // ConfigUpdater holds updater-specific configuration.
 type ConfigUpdater struct {
-	Port     int           `yaml:"port"` // default: 8080
-	LogLevel string        `yaml:"log_level"`
-	LogFile  string        `yaml:"log_file"`
-	TempDir  string        `yaml:"temp_dir"`
-	Retry    RetryConfig   `yaml:"retry"`
+	Port          int          `yaml:"port"`            // default: 8080
+	LogLevel      string       `yaml:"log_level"`       // debug | info | warn | error
+	LogFile       string       `yaml:"log_file"`        // empty: only the SetLog hook receives logs
+	LogFormat     string       `yaml:"log_format"`      // text | json (default: text)
+	LogMaxSize    int          `yaml:"log_max_size"`    // MB before rotation (default: 10)
+	LogMaxBackups int          `yaml:"log_max_backups"` // rotated files kept (default: 3)
+	TempDir       string       `yaml:"temp_dir"`
+	Retry         RetryConfig  `yaml:"retry"`
+	OIDC          *OIDCConfig  `yaml:"oidc,omitempty"`  // accept OIDC tokens on /update
+	Poll          *PollConfig  `yaml:"poll,omitempty"`  // settings of the poll deploy method
+	Relay         *RelayConfig `yaml:"relay,omitempty"` // receive webhook requests through a relay instead of port
+
+	// SigningKeys are the ed25519 public keys, by key ID, accepted for
+	// X-Signature: ed25519=... (see GenerateSigningKey).
+	SigningKeys map[string]string `yaml:"signing_keys,omitempty"`
 }
 
 // RetryConfig holds retry configuration.
@@ -37,12 +58,51 @@ type AppConfig struct {
 	Executable        string         `yaml:"executable"`
 	Path              string         `yaml:"path"`
 	Port              int            `yaml:"port"`
-	HealthEndpoint    string         `yaml:"health_endpoint"`
+	HealthEndpoint    string         `yaml:"health_endpoint"` // URL, or a path on localhost:<port>
 	HealthTimeout     time.Duration  `yaml:"health_timeout"`
 	StartupDelay      time.Duration  `yaml:"startup_delay"`
 	BusyRetryInterval time.Duration  `yaml:"busy_retry_interval"` // default: 10s
 	BusyTimeout       time.Duration  `yaml:"busy_timeout"`        // default: 5m
 	Rollback          RollbackConfig `yaml:"rollback"`
+	OIDC              *OIDCClaims    `yaml:"oidc,omitempty"`    // claims an OIDC token needs to deploy this app
+	Allow             *AppAllow      `yaml:"allow,omitempty"`   // repos, tags and download hosts allowed to deploy this app
+	Repo              string         `yaml:"repo,omitempty"`    // owner/name whose releases the poll method deploys
+	Source            string         `yaml:"source,omitempty"`  // github | gitlab | gitea (default: github)
+	Asset             string         `yaml:"asset,omitempty"`   // release asset or artifact deploying this app (default: executable)
+	Channel           string         `yaml:"channel,omitempty"` // stable, or the prerelease channel followed, e.g. beta
+	VersionPolicy     *VersionPolicy `yaml:"version_policy,omitempty"`
+
+	origin string // file:line the app was loaded from, for diagnostics
+}
+
+// healthURL returns the health endpoint of app, resolving a path such as
+// /health against http://localhost:<port>.
+func (app *AppConfig) healthURL() string {
+	if strings.HasPrefix(app.HealthEndpoint, "/") && app.Port > 0 {
+		return fmt.Sprintf("http://localhost:%d%s", app.Port, app.HealthEndpoint)
+	}
+	return app.HealthEndpoint
+}
+
+// clone returns a copy of a that shares no pointers or slices with it.
+func (a AppConfig) clone() AppConfig {
+	if a.OIDC != nil {
+		oidc := *a.OIDC
+		a.OIDC = &oidc
+	}
+	if a.Allow != nil {
+		allow := *a.Allow
+		allow.Repos = slices.Clone(allow.Repos)
+		allow.Tags = slices.Clone(allow.Tags)
+		allow.URLHosts = slices.Clone(allow.URLHosts)
+		allow.SigningKeys = slices.Clone(allow.SigningKeys)
+		a.Allow = &allow
+	}
+	if a.VersionPolicy != nil {
+		policy := *a.VersionPolicy
+		a.VersionPolicy = &policy
+	}
+	return a
 }
 
 // RollbackConfig holds rollback configuration.
@@ -52,8 +112,17 @@ type RollbackConfig struct {
 	AutoRollbackOnFailure bool `yaml:"auto_rollback_on_failure"`
 }
 
-// Load loads the configuration from the specified path.
+// Load loads the configuration from the specified path. ${VAR} and
+// ${VAR:-default} are expanded from the environment; secret://KEY references
+// are an error, use LoadWithStore to resolve them. App versions recorded in
+// the state file (see StatePath) override those in the file.
 func Load(path string) (*Config, error) {
+	return LoadWithStore(path, nil)
+}
+
+// LoadWithStore is Load with secret://KEY references resolved through store.
+// Unresolved references of either kind are reported together as one error.
+func LoadWithStore(path string, store Store) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -67,10 +136,28 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	config := &Config{}
+	if len(doc.Content) > 0 {
+		if config, err = decodeConfig(doc.Content[0], store); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+	for i := range config.Apps {
+		config.Apps[i].origin = fmt.Sprintf("%s:%d", path, appLine(&doc, i))
+		applyAppDefaults(&config.Apps[i])
+	}
+	if err := config.loadFragments(path, store); err != nil {
+		return nil, fmt.Errorf("failed to load app fragments: %w", err)
+	}
+
+	// Versions recorded by deploys live in the state file.
+	if st, err := (&StateFile{Path: StatePath(path)}).Read(); err == nil {
+		st.Apply(config)
+	}
 
 	// Apply defaults
 	if config.Updater.Port == 0 {
@@ -80,14 +167,19 @@ func Load(path string) (*Config, error) {
 		config.Updater.TempDir = filepath.Join(os.TempDir(), "deploy")
 	}
 
-	for i := range config.Apps {
-		if config.Apps[i].BusyRetryInterval == 0 {
-			config.Apps[i].BusyRetryInterval = 10 * time.Second
-		}
-		if config.Apps[i].BusyTimeout == 0 {
-			config.Apps[i].BusyTimeout = 5 * time.Minute
-		}
-	}
+	return config, nil
+}
 
-	return &config, nil
+// Validate reports configuration errors that would make the puller misbehave,
+// such as apps without an executable or path, duplicate names or executables,
+// out-of-range ports and negative durations. Warnings are not reported; use
+// ValidateFile for the full, position-annotated diagnostics.
+func (c *Config) Validate() error {
+	var errs []error
+	checkConfig(c, func(sev Severity, path, msg string, _ ...string) {
+		if sev == SeverityError {
+			errs = append(errs, fmt.Errorf("%s: %s", path, msg))
+		}
+	})
+	return errors.Join(errs...)
 }
@@ -0,0 +1,191 @@
+package deploy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigDoc is deploy.yaml as a YAML node tree. Edits made through it keep
+// comments, key order and keys the Config structs do not know about.
+type ConfigDoc struct {
+	root yaml.Node
+}
+
+// ParseConfigDoc parses configuration content for editing.
+func ParseConfigDoc(data []byte) (*ConfigDoc, error) {
+	d := &ConfigDoc{}
+	if err := yaml.Unmarshal(data, &d.root); err != nil {
+		return nil, err
+	}
+	if len(d.root.Content) == 0 {
+		d.root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	return d, nil
+}
+
+// EditConfig applies edit to the configuration file at path and writes it
+// back atomically, holding the same kind of lock as StateFile.Update.
+// Nothing is written when edit returns an error.
+func EditConfig(path string, edit func(*ConfigDoc) error) error {
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return fmt.Errorf("deploy: lock config: %w", err)
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	doc, err := ParseConfigDoc(data)
+	if err != nil {
+		return fmt.Errorf("deploy: parse %s: %w", path, err)
+	}
+	if err := edit(doc); err != nil {
+		return err
+	}
+	out, err := doc.Bytes()
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return writeFileAtomic(path, out, perm)
+}
+
+// Bytes renders the document with two-space indentation.
+func (d *ConfigDoc) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&d.root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Get returns the value node at path (e.g. "apps[1].version"), or nil.
+func (d *ConfigDoc) Get(path string) *yaml.Node {
+	n, _ := d.lookup(path, false)
+	return n
+}
+
+// Set replaces the value at path with value, keeping the comments attached
+// to the old value. Missing mapping keys along the path are created; list
+// indexes must exist.
+func (d *ConfigDoc) Set(path string, value any) error {
+	n, err := d.lookup(path, true)
+	if err != nil {
+		return err
+	}
+	var v yaml.Node
+	if err := v.Encode(value); err != nil {
+		return fmt.Errorf("deploy: encode %s: %w", path, err)
+	}
+	v.HeadComment, v.LineComment, v.FootComment = n.HeadComment, n.LineComment, n.FootComment
+	*n = v
+	return nil
+}
+
+// Delete removes the key or list item at path, reporting whether it existed.
+func (d *ConfigDoc) Delete(path string) bool {
+	segs := splitConfigPath(path)
+	if len(segs) == 0 {
+		return false
+	}
+	parent, err := d.walk(path, segs[:len(segs)-1], false)
+	if err != nil {
+		return false
+	}
+	last := segs[len(segs)-1]
+	switch {
+	case last.key != "" && parent.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(parent.Content); i += 2 {
+			if parent.Content[i].Value == last.key {
+				parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+				return true
+			}
+		}
+	case last.key == "" && parent.Kind == yaml.SequenceNode && last.index < len(parent.Content):
+		parent.Content = append(parent.Content[:last.index], parent.Content[last.index+1:]...)
+		return true
+	}
+	return false
+}
+
+// lookup walks path from the document root. With create, missing mapping
+// keys are added with a null value.
+func (d *ConfigDoc) lookup(path string, create bool) (*yaml.Node, error) {
+	return d.walk(path, splitConfigPath(path), create)
+}
+
+func (d *ConfigDoc) walk(path string, segs []pathSegment, create bool) (*yaml.Node, error) {
+	n := d.root.Content[0]
+	for _, seg := range segs {
+		if seg.key != "" {
+			if n.Kind != yaml.MappingNode {
+				if !create || n.Kind != yaml.ScalarNode || n.Tag != "!!null" {
+					return nil, fmt.Errorf("deploy: %s: %q is not a mapping", path, seg.key)
+				}
+				*n = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", HeadComment: n.HeadComment, LineComment: n.LineComment}
+			}
+			var next *yaml.Node
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == seg.key {
+					next = n.Content[i+1]
+					break
+				}
+			}
+			if next == nil {
+				if !create {
+					return nil, fmt.Errorf("deploy: %s: no key %q", path, seg.key)
+				}
+				next = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+				n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: seg.key}, next)
+			}
+			n = next
+			continue
+		}
+		if n.Kind != yaml.SequenceNode || seg.index >= len(n.Content) {
+			return nil, fmt.Errorf("deploy: %s: index %d out of range", path, seg.index)
+		}
+		n = n.Content[seg.index]
+	}
+	return n, nil
+}
+
+type pathSegment struct {
+	key   string
+	index int
+}
+
+// splitConfigPath splits "apps[1].rollback.enabled" into its segments.
+func splitConfigPath(path string) []pathSegment {
+	var segs []pathSegment
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			continue
+		}
+		key, rest, _ := strings.Cut(part, "[")
+		if key != "" {
+			segs = append(segs, pathSegment{key: key})
+		}
+		for rest != "" {
+			idx, after, _ := strings.Cut(rest, "]")
+			i, _ := strconv.Atoi(idx)
+			segs = append(segs, pathSegment{index: i})
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+	return segs
+}
@@ -1,11 +1,13 @@
 package deploy
 
 import (
+	"archive/zip"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -13,6 +15,13 @@ type Downloader interface {
 	Download(url, dest, token string) error
 }
 
+// SourceDownloader is a Downloader that also authenticates to the release
+// asset APIs of GitLab and Gitea; Download uses GitHub's.
+type SourceDownloader interface {
+	Downloader
+	DownloadFrom(src Source, url, dest, token string) error
+}
+
 type HTTPDownloader struct {
 	client *http.Client
 }
@@ -22,7 +31,10 @@ func NewDownloader() *HTTPDownloader {
 }
 
 func (d *HTTPDownloader) Download(url, dest, token string) error {
-	// ... (same implementation)
+	return d.DownloadFrom(SourceGitHub, url, dest, token)
+}
+
+func (d *HTTPDownloader) DownloadFrom(src Source, url, dest, token string) error {
 	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
@@ -32,8 +44,10 @@ func (d *HTTPDownloader) Download(url, dest, token string) error {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Accept", "application/octet-stream")
+	setSourceAuth(req.Header, src, token)
+	if src == SourceGitHub {
+		req.Header.Set("Accept", "application/octet-stream")
+	}
 
 	resp, err := d.client.Do(req)
 	if err != nil {
@@ -42,7 +56,9 @@ func (d *HTTPDownloader) Download(url, dest, token string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status: %d", resp.StatusCode)
+		// Include the start of the body: GitHub explains auth failures there.
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("download failed with status: %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
 	}
 
 	out, err := os.Create(dest)
@@ -58,3 +74,70 @@ func (d *HTTPDownloader) Download(url, dest, token string) error {
 
 	return nil
 }
+
+// setSourceAuth authenticates a request to the API of src with token.
+func setSourceAuth(h http.Header, src Source, token string) {
+	if token == "" {
+		return
+	}
+	switch src {
+	case SourceGitLab:
+		h.Set("PRIVATE-TOKEN", token)
+	case SourceGitea:
+		h.Set("Authorization", "token "+token)
+	default:
+		h.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// extractArchive replaces the archive at path with the executable it holds:
+// the file named executable, or the only file of the archive.
+func extractArchive(format, path, executable string) error {
+	if format != "zip" {
+		return fmt.Errorf("unsupported archive format %q", format)
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer zr.Close()
+
+	var found *zip.File
+	var files []*zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files = append(files, f)
+		if filepath.Base(f.Name) == executable {
+			found = f
+		}
+	}
+	if found == nil && len(files) == 1 {
+		found = files[0]
+	}
+	if found == nil {
+		return fmt.Errorf("archive holds no %s", executable)
+	}
+
+	in, err := found.Open()
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", found.Name, err)
+	}
+	defer in.Close()
+	tmp := path + ".extract"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("extract %s: %w", found.Name, err)
+	}
+	zr.Close()
+	return os.Rename(tmp, path)
+}
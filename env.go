@@ -0,0 +1,147 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment overrides parts of the configuration for one deploy target,
+// selected with Puller.Env (puller --env NAME):
+//
+//	environments:
+//	  staging:
+//	    host: staging.example.com:8080
+//	    apps:
+//	      - name: api             # matched by name; only the keys given override
+//	        port: 9001
+//	  production:
+//	    method: webhook
+type Environment struct {
+	Method string      `yaml:"method"` // overrides DEPLOY_METHOD
+	Host   string      `yaml:"host"`   // overrides DEPLOY_SERVER_HOST
+	Apps   []AppConfig `yaml:"apps"`   // overrides by name; unknown names add an app
+
+	appNodes []*yaml.Node // Apps as written, so only present keys override
+}
+
+// UnmarshalYAML keeps the app override nodes next to the decoded fields.
+func (e *Environment) UnmarshalYAML(n *yaml.Node) error {
+	type plain Environment
+	if err := n.Decode((*plain)(e)); err != nil {
+		return err
+	}
+	e.appNodes = nil
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == "apps" && n.Content[i+1].Kind == yaml.SequenceNode {
+			e.appNodes = n.Content[i+1].Content
+		}
+	}
+	return nil
+}
+
+// storeOverrides returns the Store keys the environment fixes.
+func (e *Environment) storeOverrides() map[string]string {
+	o := make(map[string]string)
+	if e.Method != "" {
+		o["DEPLOY_METHOD"] = e.Method
+	}
+	if e.Host != "" {
+		o["DEPLOY_SERVER_HOST"] = e.Host
+	}
+	return o
+}
+
+// ForEnvironment returns the configuration with the overrides of environment
+// name applied. An empty name returns c unchanged.
+func (c *Config) ForEnvironment(name string) (*Config, error) {
+	if name == "" {
+		return c, nil
+	}
+	env, ok := c.Environments[name]
+	if !ok {
+		return nil, fmt.Errorf("deploy: unknown environment %q (configured: %s)", name, strings.Join(sortedKeys(c.Environments), ", "))
+	}
+	next := *c
+	next.Apps = append([]AppConfig(nil), c.Apps...)
+	for i, n := range env.appNodes {
+		var key struct {
+			Name string `yaml:"name"`
+		}
+		if err := n.Decode(&key); err != nil || key.Name == "" {
+			return nil, fmt.Errorf("deploy: environments.%s.apps[%d]: name is required", name, i)
+		}
+		idx := -1
+		for j := range next.Apps {
+			if next.Apps[j].Name == key.Name {
+				idx = j
+			}
+		}
+		if idx < 0 {
+			var app AppConfig
+			if err := n.Decode(&app); err != nil {
+				return nil, fmt.Errorf("deploy: environments.%s.apps[%d]: %w", name, i, err)
+			}
+			applyAppDefaults(&app)
+			next.Apps = append(next.Apps, app)
+			continue
+		}
+		// Decoding onto the existing app only sets the keys present. yaml
+		// decodes into the structs pointed at, so those are copied first to
+		// leave the base config and other environments untouched.
+		next.Apps[idx] = next.Apps[idx].clone()
+		if err := n.Decode(&next.Apps[idx]); err != nil {
+			return nil, fmt.Errorf("deploy: environments.%s.apps[%d]: %w", name, i, err)
+		}
+	}
+	return &next, nil
+}
+
+// EnvKey returns the Store key holding key for environment env.
+func EnvKey(env, key string) string {
+	return "env/" + env + "/" + key
+}
+
+// baseKey strips an EnvKey prefix.
+func baseKey(key string) string {
+	if rest, ok := strings.CutPrefix(key, "env/"); ok {
+		if _, k, ok := strings.Cut(rest, "/"); ok {
+			return k
+		}
+	}
+	return key
+}
+
+// EnvStore scopes a Store to one environment: keys are read and written as
+// EnvKey(Env, key), so staging and production credentials never collide.
+// Settings that are not secrets fall back to the shared, unscoped key; secrets
+// never do.
+type EnvStore struct {
+	Base      Store
+	Env       string
+	Overrides map[string]string // fixed by deploy.yaml, e.g. DEPLOY_METHOD
+}
+
+// NewEnvStore scopes base to environment env.
+func NewEnvStore(base Store, env string) *EnvStore {
+	return &EnvStore{Base: base, Env: env}
+}
+
+func (s *EnvStore) Get(key string) (string, error) {
+	if v := s.Overrides[key]; v != "" {
+		return v, nil
+	}
+	v, err := s.Base.Get(EnvKey(s.Env, key))
+	if err == nil && v != "" {
+		return v, nil
+	}
+	if isSensitive(key) {
+		return "", fmt.Errorf("deploy: %s not set for environment %s", key, s.Env)
+	}
+	return s.Base.Get(key)
+}
+
+func (s *EnvStore) Set(key, value string) error {
+	return s.Base.Set(EnvKey(s.Env, key), value)
+}
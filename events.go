@@ -0,0 +1,86 @@
+package deploy
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies a step of the deploy lifecycle.
+type EventType string
+
+const (
+	EventDeployStarted EventType = "deploy_started" // request accepted for an app
+	EventDownloaded    EventType = "downloaded"     // new binary in temp_dir
+	EventStopped       EventType = "stopped"        // old process stopped
+	EventStarted       EventType = "started"        // new process started
+	EventHealthFailed  EventType = "health_failed"  // new process failed its health check
+	EventRolledBack    EventType = "rolled_back"    // previous binary restored and restarted
+	EventCompleted     EventType = "completed"      // deploy succeeded
+	EventFailed        EventType = "failed"         // deploy ended without success
+)
+
+// Event describes one lifecycle step. Err is already redacted.
+type Event struct {
+	Type     EventType
+	DeployID string
+	App      string
+	Tag      string
+	Repo     string
+	Version  string        // set on EventCompleted
+	Outcome  string        // set on EventCompleted and EventFailed
+	Duration time.Duration // phase duration for Downloaded, Stopped, Started
+	Err      error
+	Time     time.Time
+}
+
+// Observer receives lifecycle events. OnEvent runs synchronously on the
+// deploy goroutine, so it must return quickly.
+type Observer interface {
+	OnEvent(Event)
+}
+
+// ObserverFunc adapts a function to Observer.
+type ObserverFunc func(Event)
+
+func (f ObserverFunc) OnEvent(e Event) { f(e) }
+
+// eventBus fans events out to subscribed observers. The zero value is ready to use.
+type eventBus struct {
+	mu   sync.RWMutex
+	next int
+	subs map[int]Observer
+}
+
+func (b *eventBus) subscribe(o Observer) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[int]Observer)
+	}
+	id := b.next
+	b.next++
+	b.subs[id] = o
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+func (b *eventBus) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	observers := make([]Observer, 0, len(b.subs))
+	// Deliver in subscription order.
+	for i := 0; i < b.next; i++ {
+		if o, ok := b.subs[i]; ok {
+			observers = append(observers, o)
+		}
+	}
+	b.mu.RUnlock()
+	for _, o := range observers {
+		o.OnEvent(e)
+	}
+}
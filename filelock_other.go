@@ -0,0 +1,38 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockStale is how old a lock file may get before it is assumed to belong
+// to a process that died holding it.
+const lockStale = time.Minute
+
+// lockFile takes an exclusive lock by creating path, waiting while another
+// process holds it.
+func lockFile(path string) (unlock func(), err error) {
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %s", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,278 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+)
+
+// Source is the forge hosting the releases of an app. It selects how
+// downloads authenticate and which webhook endpoint deploys the app.
+type Source string
+
+const (
+	SourceGitHub Source = "github" // /github, DEPLOY_GITHUB_PAT
+	SourceGitLab Source = "gitlab" // /gitlab, DEPLOY_GITLAB_TOKEN
+	SourceGitea  Source = "gitea"  // /gitea, DEPLOY_GITEA_TOKEN; also Forgejo
+)
+
+// TokenKey returns the Store key of the access token used to download from s.
+func (s Source) TokenKey() string {
+	switch s {
+	case SourceGitLab:
+		return "DEPLOY_GITLAB_TOKEN"
+	case SourceGitea:
+		return "DEPLOY_GITEA_TOKEN"
+	}
+	return "DEPLOY_GITHUB_PAT"
+}
+
+// source returns the forge of app, GitHub unless configured.
+func (app *AppConfig) source() Source {
+	if app.Source == "" {
+		return SourceGitHub
+	}
+	return Source(app.Source)
+}
+
+// assetMatches reports whether the release asset or workflow artifact name
+// deploys app: it matches app's asset pattern, by default its executable.
+func assetMatches(app *AppConfig, name string) bool {
+	pattern := app.Asset
+	if pattern == "" {
+		pattern = app.Executable
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// WebhookDeploy reports what a forge webhook did for one app.
+type WebhookDeploy struct {
+	App      string `json:"app"`
+	Tag      string `json:"tag"`
+	DeployID string `json:"deploy_id,omitempty"` // set when the deploy was started
+	Error    string `json:"error,omitempty"`     // why the app was not deployed
+
+	// Unchanged is set when the tag was already installed, by the deploy
+	// DeployID; Duplicate when the delivery was seen before and started
+	// deploy DeployID.
+	Unchanged bool `json:"unchanged,omitempty"`
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// forge describes the webhooks of one Source.
+type forge struct {
+	source    Source
+	event     func(r *http.Request) string
+	signature func(r *http.Request) string
+	// delivery returns the ID of a delivery, which redeliveries keep.
+	delivery func(r *http.Request) string
+	// verify checks the signature of a delivery against the keys of v.
+	verify func(v *HMACValidator, body []byte, signature string, track bool) error
+	// keys returns the keys checking deliveries for app, or for any app
+	// when nil; by default the HMAC secrets, see Handler.validatorFor.
+	keys func(h *Handler, app *AppConfig) *HMACValidator
+	// requests maps an event to an update request per app. Ignored events
+	// return the reason; payload errors wrap errInvalidPayload.
+	requests func(h *Handler, ctx context.Context, cfg *Config, event string, body []byte) (map[*AppConfig]UpdateRequest, string, error)
+}
+
+var errInvalidPayload = errors.New("invalid payload")
+
+// forgeAsset is a downloadable file of a release or build.
+type forgeAsset struct {
+	Name, URL, Digest string
+}
+
+// handleForge serves the webhook endpoint of f. Deploys run in the
+// background, since forges give up on a delivery after a few seconds; the
+// response lists the deploys started and Wait blocks until they finish.
+func (h *Handler) handleForge(w http.ResponseWriter, r *http.Request, f forge) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	signature := f.signature(r)
+	if signature == "" {
+		http.Error(w, "Missing signature", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+
+	// The shared key set authenticates the delivery for every app except
+	// those requiring their own secret.
+	cfg := h.config()
+	event := f.event(r)
+	log := h.logger().With("source", f.source, "event", event)
+	keys := f.keys
+	if keys == nil {
+		keys = (*Handler).validatorFor
+	}
+	sharedErr := f.verify(keys(h, nil), body, signature, true)
+	signedFor := func(app *AppConfig) bool {
+		if app.Allow != nil && app.Allow.Secret {
+			return f.verify(keys(h, app), body, signature, true) == nil
+		}
+		return sharedErr == nil
+	}
+	authenticated := sharedErr == nil
+	for i := 0; !authenticated && cfg != nil && i < len(cfg.Apps); i++ {
+		if app := &cfg.Apps[i]; app.Allow != nil && app.Allow.Secret && app.source() == f.source {
+			authenticated = signedFor(app)
+		}
+	}
+	if !authenticated {
+		h.Metrics.ObserveHMACFailure()
+		log.Warn("rejected webhook", "remote", r.RemoteAddr, "error", sharedErr)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if event == "ping" {
+		w.Write([]byte("pong"))
+		return
+	}
+
+	reqs, ignored, err := f.requests(h, r.Context(), cfg, event, body)
+	if errors.Is(err, errInvalidPayload) {
+		log.Warn("invalid webhook", "error", err)
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error("webhook failed", "error", err)
+		h.httpError(w, fmt.Sprintf("Webhook failed: %v", err), http.StatusBadGateway)
+		return
+	}
+	if ignored == "" && len(reqs) == 0 {
+		ignored = "no asset matches a configured app"
+	}
+	if ignored != "" {
+		log.Debug("webhook ignored", "reason", ignored)
+		w.Write([]byte("Ignored: " + ignored))
+		return
+	}
+
+	// Redeliveries are answered with the deploys of the first delivery.
+	delivery, fingerprint := f.delivery(r), requestFingerprint(body)
+	if delivery != "" {
+		delivery = string(f.source) + ":" + delivery
+	}
+	var results []WebhookDeploy
+	started, skipped := 0, 0
+	for i := range cfg.Apps {
+		app := &cfg.Apps[i]
+		req, ok := reqs[app]
+		if !ok {
+			continue
+		}
+		res := WebhookDeploy{App: app.Name, Tag: req.Tag}
+		// admit is a first look for the response; deploy checks again
+		// under the app's lock.
+		var installedBy string
+		var unchanged bool
+		if !signedFor(app) {
+			res.Error = "not signed with the app's secret"
+		} else if err := authorize(app, &req, nil); err != nil {
+			res.Error = err.Error()
+		} else if installedBy, unchanged, err = h.admit(app, &req); err != nil {
+			res.Error = err.Error()
+		}
+		if res.Error != "" {
+			log.Warn("rejected webhook", "app", app.Name, "repo", req.Repo, "tag", req.Tag, "error", res.Error)
+			results = append(results, res)
+			continue
+		}
+		if unchanged {
+			res.DeployID, res.Unchanged = installedBy, true
+			h.Metrics.ObserveDeploy(app.Name, OutcomeUnchanged)
+			log.Info("deploy skipped: version already installed", "app", app.Name, "version", req.Tag, "deploy_id", installedBy)
+			skipped++
+			results = append(results, res)
+			continue
+		}
+		res.DeployID = NewDeployID()
+		var e *idempotencyEntry
+		if delivery != "" {
+			var first bool
+			if e, first = h.idem.claim(app.Name, delivery, fingerprint, res.DeployID); !first {
+				res.DeployID, res.Duplicate = e.deployID, true
+				log.Info("duplicate delivery", "app", app.Name, "delivery", delivery, "deploy_id", e.deployID)
+				skipped++
+				results = append(results, res)
+				continue
+			}
+		}
+		started++
+		h.inflight.Add(1)
+		go func() {
+			defer h.inflight.Done()
+			rec := &recordingResponse{ResponseWriter: discardResponse{}}
+			h.deploy(rec, cfg, app, req, nil, res.DeployID)
+			if e != nil {
+				h.idem.finish(app.Name, delivery, e, rec)
+			}
+		}()
+		results = append(results, res)
+	}
+
+	code := http.StatusAccepted
+	switch {
+	case started == 0 && skipped > 0:
+		code = http.StatusOK
+	case started == 0:
+		code = http.StatusForbidden
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(results)
+}
+
+// Wait blocks until the deploys started by forge webhooks have finished.
+func (h *Handler) Wait() {
+	h.inflight.Wait()
+}
+
+// assetRequests maps assets to the apps of src they deploy, each app taking
+// the first matching asset.
+func assetRequests(cfg *Config, src Source, repo, tag string, assets []forgeAsset, archive string) map[*AppConfig]UpdateRequest {
+	reqs := make(map[*AppConfig]UpdateRequest)
+	for i := 0; cfg != nil && i < len(cfg.Apps); i++ {
+		app := &cfg.Apps[i]
+		if app.source() != src {
+			continue
+		}
+		for _, a := range assets {
+			if assetMatches(app, a.Name) {
+				reqs[app] = UpdateRequest{Repo: repo, Tag: tag, Executable: app.Executable,
+					DownloadURL: a.URL, Digest: a.Digest, Archive: archive}
+				break
+			}
+		}
+	}
+	return reqs
+}
+
+// decodeEvent unmarshals a webhook payload into v.
+func decodeEvent(body []byte, v any) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidPayload, err)
+	}
+	return nil
+}
+
+// discardResponse swallows the response of a background deploy; its outcome
+// is reported through logs, events and metrics.
+type discardResponse struct{}
+
+func (discardResponse) Header() http.Header         { return http.Header{} }
+func (discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponse) WriteHeader(int)             {}
@@ -0,0 +1,209 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultAppsDir is the directory of per-app fragments read by Load when
+// apps_dir is not set, relative to deploy.yaml.
+//
+// Each *.yaml or *.yml file holds one app, or a list of apps:
+//
+//	# apps.d/api.yaml
+//	name: api
+//	executable: api
+//	path: /srv/api
+//
+// Fragment apps follow those of deploy.yaml, ordered by file name.
+const DefaultAppsDir = "apps.d"
+
+// configFragment is one apps.d file as loaded.
+type configFragment struct {
+	file string
+	apps []AppConfig
+	refs map[string]configRef // keyed by path within the fragment, e.g. "[0].path"
+}
+
+// appsDir returns the fragment directory for the configuration at configPath.
+func (c *Config) appsDir(configPath string) string {
+	dir := c.AppsDir
+	if dir == "" {
+		dir = DefaultAppsDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(configPath), dir)
+	}
+	return dir
+}
+
+// fragmentFiles lists the fragments in dir in load order; a missing
+// directory has none.
+func fragmentFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readFragment loads the apps of one fragment file.
+func readFragment(file string, store Store) (configFragment, error) {
+	f := configFragment{file: file}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return f, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return f, fmt.Errorf("%s: %w", file, err)
+	}
+	for i, n := range fragmentNodes(&doc) {
+		refs, err := resolveRefs(n, fmt.Sprintf("[%d]", i), store)
+		if err != nil {
+			return f, fmt.Errorf("%s: %w", file, err)
+		}
+		var app AppConfig
+		if err := n.Decode(&app); err != nil {
+			return f, fmt.Errorf("%s: %w", file, err)
+		}
+		app.origin = fmt.Sprintf("%s:%d", file, n.Line)
+		applyAppDefaults(&app)
+		f.apps = append(f.apps, app)
+		for k, v := range refs {
+			if f.refs == nil {
+				f.refs = make(map[string]configRef)
+			}
+			f.refs[k] = v
+		}
+	}
+	return f, nil
+}
+
+// fragmentNodes returns the app mappings of a fragment document: the root
+// mapping itself, or each item of a root list.
+func fragmentNodes(doc *yaml.Node) []*yaml.Node {
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind == yaml.SequenceNode {
+		return root.Content
+	}
+	return []*yaml.Node{root}
+}
+
+// loadFragments reads every fragment of the configuration at configPath and
+// appends their apps.
+func (c *Config) loadFragments(configPath string, store Store) error {
+	files, err := fragmentFiles(c.appsDir(configPath))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	c.fragments = []configFragment{}
+	for _, file := range files {
+		f, err := readFragment(file, store)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.fragments = append(c.fragments, f)
+	}
+	c.mergeFragments()
+	return errors.Join(errs...)
+}
+
+// mergeFragments rebuilds Apps from the main file's apps and the fragments.
+func (c *Config) mergeFragments() {
+	apps := append([]AppConfig(nil), c.Apps[:c.mainApps]...)
+	for _, f := range c.fragments {
+		apps = append(apps, f.apps...)
+	}
+	c.Apps = apps
+}
+
+// withFragments returns a copy of c in which only the fragment files listed
+// in changed are read again (or dropped, when they no longer exist). Apps of
+// the other fragments are reused as they are.
+func (c *Config) withFragments(configPath string, changed map[string]bool, store Store) (*Config, error) {
+	files, err := fragmentFiles(c.appsDir(configPath))
+	if err != nil {
+		return nil, err
+	}
+	old := make(map[string]configFragment, len(c.fragments))
+	for _, f := range c.fragments {
+		old[f.file] = f
+	}
+
+	next := *c
+	next.fragments = []configFragment{}
+	var errs []error
+	for _, file := range files {
+		f, ok := old[file]
+		if !ok || changed[file] {
+			if f, err = readFragment(file, store); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		next.fragments = append(next.fragments, f)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	next.mergeFragments()
+	if st, err := (&StateFile{Path: StatePath(configPath)}).Read(); err == nil {
+		st.Apply(&next)
+	}
+	return &next, nil
+}
+
+func (c *Config) fragmentRefs() []map[string]configRef {
+	var out []map[string]configRef
+	for _, f := range c.fragments {
+		out = append(out, f.refs)
+	}
+	return out
+}
+
+func applyAppDefaults(app *AppConfig) {
+	if app.BusyRetryInterval == 0 {
+		app.BusyRetryInterval = 10 * time.Second
+	}
+	if app.BusyTimeout == 0 {
+		app.BusyTimeout = 5 * time.Minute
+	}
+}
+
+// appLine returns the line of apps[i] in a parsed deploy.yaml, or 0.
+func appLine(doc *yaml.Node, i int) int {
+	d := &ConfigDoc{root: *doc}
+	if len(doc.Content) == 0 {
+		return 0
+	}
+	if n := d.Get(fmt.Sprintf("apps[%d]", i)); n != nil {
+		return n.Line
+	}
+	return 0
+}
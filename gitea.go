@@ -0,0 +1,92 @@
+package deploy
+
+import (
+	"context"
+	"net/http"
+)
+
+// Gitea and Forgejo release webhooks are accepted on /gitea for apps with
+// source gitea. Both sign the body with HMAC-SHA256, sent as bare hex:
+//
+//	Target URL:   http://<host>:<port>/gitea
+//	Content type: application/json
+//	Secret:       DEPLOY_HMAC_SECRET (or DEPLOY_HMAC_SECRET/<app>)
+//	Events:       Release
+//
+// A published release deploys every app with a matching attachment.
+const (
+	GiteaEventHeader       = "X-Gitea-Event"
+	GiteaSignatureHeader   = "X-Gitea-Signature"
+	ForgejoEventHeader     = "X-Forgejo-Event"
+	ForgejoSignatureHeader = "X-Forgejo-Signature"
+	GiteaDeliveryHeader    = "X-Gitea-Delivery"
+	ForgejoDeliveryHeader  = "X-Forgejo-Delivery"
+)
+
+type giteaReleaseEvent struct {
+	Action  string `json:"action"`
+	Release struct {
+		TagName string `json:"tag_name"`
+		Draft   bool   `json:"draft"`
+		Assets  []struct {
+			Name               string `json:"name"`
+			BrowserDownloadURL string `json:"browser_download_url"`
+		} `json:"assets"`
+	} `json:"release"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+var giteaForge = forge{
+	source: SourceGitea,
+	event: func(r *http.Request) string {
+		return firstHeader(r, ForgejoEventHeader, GiteaEventHeader)
+	},
+	signature: func(r *http.Request) string {
+		return firstHeader(r, ForgejoSignatureHeader, GiteaSignatureHeader)
+	},
+	delivery: func(r *http.Request) string {
+		return firstHeader(r, ForgejoDeliveryHeader, GiteaDeliveryHeader)
+	},
+	verify: func(v *HMACValidator, body []byte, signature string, track bool) error {
+		return v.validate(body, "sha256="+signature, "", track)
+	},
+	requests: giteaRequests,
+}
+
+// HandleGitea serves /gitea, see handleForge.
+func (h *Handler) HandleGitea(w http.ResponseWriter, r *http.Request) {
+	h.handleForge(w, r, giteaForge)
+}
+
+func giteaRequests(_ *Handler, _ context.Context, cfg *Config, event string, body []byte) (map[*AppConfig]UpdateRequest, string, error) {
+	if event != "release" {
+		return nil, "event not handled", nil
+	}
+	var ev giteaReleaseEvent
+	if err := decodeEvent(body, &ev); err != nil {
+		return nil, "", err
+	}
+	if ev.Action != "published" {
+		return nil, "release " + ev.Action, nil
+	}
+	if ev.Release.Draft {
+		return nil, "draft release", nil
+	}
+	assets := make([]forgeAsset, len(ev.Release.Assets))
+	for i, a := range ev.Release.Assets {
+		assets[i] = forgeAsset{Name: a.Name, URL: a.BrowserDownloadURL}
+	}
+	return assetRequests(cfg, SourceGitea, ev.Repository.FullName, ev.Release.TagName, assets, ""), "", nil
+}
+
+// firstHeader returns the first of the named headers set on r.
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
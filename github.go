@@ -0,0 +1,172 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// GitHub repository webhooks are accepted on /github, so a repo webhook
+// alone triggers deploys, without a custom CI step:
+//
+//	Payload URL:  http://<host>:<port>/github
+//	Content type: application/json
+//	Secret:       DEPLOY_HMAC_SECRET (or DEPLOY_HMAC_SECRET/<app>, see AppAllow.Secret)
+//	Events:       Releases, Workflow runs
+//
+// A published release deploys every app with a matching asset; a
+// successful workflow run deploys the matching artifacts of the run. Assets
+// and artifacts map to apps by AppConfig.Asset.
+const (
+	GitHubEventHeader     = "X-GitHub-Event"
+	GitHubSignatureHeader = "X-Hub-Signature-256"
+	GitHubDeliveryHeader  = "X-GitHub-Delivery"
+)
+
+type githubRepository struct {
+	FullName string `json:"full_name"`
+}
+
+type githubReleaseEvent struct {
+	Action  string `json:"action"`
+	Release struct {
+		TagName string `json:"tag_name"`
+		Draft   bool   `json:"draft"`
+		Assets  []struct {
+			Name   string `json:"name"`
+			URL    string `json:"url"`    // API URL, serves the asset with Accept: application/octet-stream
+			Digest string `json:"digest"` // "sha256:<hex>"; absent on older releases
+		} `json:"assets"`
+	} `json:"release"`
+	Repository githubRepository `json:"repository"`
+}
+
+type githubWorkflowRunEvent struct {
+	Action      string `json:"action"`
+	WorkflowRun struct {
+		ID         int64  `json:"id"`
+		Name       string `json:"name"`
+		Event      string `json:"event"`
+		Conclusion string `json:"conclusion"`
+		HeadSHA    string `json:"head_sha"`
+	} `json:"workflow_run"`
+	Repository githubRepository `json:"repository"`
+}
+
+type githubArtifacts struct {
+	Artifacts []struct {
+		Name               string `json:"name"`
+		ArchiveDownloadURL string `json:"archive_download_url"`
+		Expired            bool   `json:"expired"`
+	} `json:"artifacts"`
+}
+
+var githubForge = forge{
+	source:    SourceGitHub,
+	event:     func(r *http.Request) string { return r.Header.Get(GitHubEventHeader) },
+	signature: func(r *http.Request) string { return r.Header.Get(GitHubSignatureHeader) },
+	delivery:  func(r *http.Request) string { return r.Header.Get(GitHubDeliveryHeader) },
+	verify: func(v *HMACValidator, body []byte, signature string, track bool) error {
+		return v.validate(body, signature, "", track)
+	},
+	requests: githubRequests,
+}
+
+// HandleGitHub serves /github, see handleForge.
+func (h *Handler) HandleGitHub(w http.ResponseWriter, r *http.Request) {
+	h.handleForge(w, r, githubForge)
+}
+
+func githubRequests(h *Handler, ctx context.Context, cfg *Config, event string, body []byte) (map[*AppConfig]UpdateRequest, string, error) {
+	switch event {
+	case "release":
+		var ev githubReleaseEvent
+		if err := decodeEvent(body, &ev); err != nil {
+			return nil, "", err
+		}
+		if ev.Action != "published" {
+			return nil, "release " + ev.Action, nil
+		}
+		if ev.Release.Draft {
+			return nil, "draft release", nil
+		}
+		assets := make([]forgeAsset, len(ev.Release.Assets))
+		for i, a := range ev.Release.Assets {
+			assets[i] = forgeAsset{Name: a.Name, URL: a.URL, Digest: a.Digest}
+		}
+		return assetRequests(cfg, SourceGitHub, ev.Repository.FullName, ev.Release.TagName, assets, ""), "", nil
+	case "workflow_run":
+		var ev githubWorkflowRunEvent
+		if err := decodeEvent(body, &ev); err != nil {
+			return nil, "", err
+		}
+		return h.githubWorkflowRunRequests(ctx, cfg, &ev)
+	}
+	return nil, "event not handled", nil
+}
+
+// githubWorkflowRunRequests maps the artifacts of a successful workflow run
+// to apps. Artifacts are zip archives, listed with the GitHub API; runs of
+// pull requests are ignored, as forks can trigger them. The list is fetched
+// from the API base (see apiBase) by repository and run ID: artifacts_url
+// comes from the payload and never receives the token.
+func (h *Handler) githubWorkflowRunRequests(ctx context.Context, cfg *Config, ev *githubWorkflowRunEvent) (map[*AppConfig]UpdateRequest, string, error) {
+	run := ev.WorkflowRun
+	switch {
+	case ev.Action != "completed":
+		return nil, "workflow run " + ev.Action, nil
+	case run.Conclusion != "success":
+		return nil, "workflow run " + run.Conclusion, nil
+	case strings.HasPrefix(run.Event, "pull_request"):
+		return nil, "workflow run of a pull request", nil
+	case run.ID <= 0 || !validRepo(SourceGitHub, ev.Repository.FullName):
+		return nil, "", fmt.Errorf("%w: workflow run without id or repository", errInvalidPayload)
+	}
+
+	token, err := h.Keys.Get(SourceGitHub.TokenKey())
+	if err != nil {
+		return nil, "", errors.New("missing GitHub token")
+	}
+	h.Redact.Add(token)
+	var list githubArtifacts
+	artifacts := fmt.Sprintf("%s/repos/%s/actions/runs/%d/artifacts?per_page=100", apiBase(cfg, SourceGitHub), ev.Repository.FullName, run.ID)
+	if err := githubGet(ctx, artifacts, token, &list); err != nil {
+		return nil, "", err
+	}
+
+	tag := run.HeadSHA
+	if len(tag) > 7 {
+		tag = tag[:7]
+	}
+	var assets []forgeAsset
+	for _, a := range list.Artifacts {
+		if !a.Expired {
+			assets = append(assets, forgeAsset{Name: a.Name, URL: a.ArchiveDownloadURL})
+		}
+	}
+	return assetRequests(cfg, SourceGitHub, ev.Repository.FullName, tag, assets, "zip"), "", nil
+}
+
+// githubGet decodes the JSON response of a GitHub API request into v.
+func githubGet(ctx context.Context, url, token string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	setSourceAuth(req.Header, SourceGitHub, token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("GitHub API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GitHub API: status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
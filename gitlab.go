@@ -0,0 +1,103 @@
+package deploy
+
+import (
+	"context"
+	"net/http"
+)
+
+// GitLab release hooks are accepted on /gitlab for apps with source gitlab.
+// GitLab does not sign deliveries; it sends the webhook's secret token as
+// is, so the token is a key of its own rather than an HMAC secret, and the
+// URL must use https:
+//
+//	URL:          https://<host>/gitlab
+//	Secret token: DEPLOY_GITLAB_WEBHOOK_TOKEN (or DEPLOY_GITLAB_WEBHOOK_TOKEN/<app>)
+//	Trigger:      Releases events
+//
+// A created release deploys every app with a matching release link.
+const (
+	GitLabEventHeader    = "X-Gitlab-Event"
+	GitLabTokenHeader    = "X-Gitlab-Token"
+	GitLabDeliveryHeader = "X-Gitlab-Event-UUID"
+)
+
+// GitLabWebhookTokenKey returns the Store key of the secret token of GitLab
+// webhooks for app with allow.secret, or of the shared token when app is "".
+func GitLabWebhookTokenKey(app string) string {
+	if app == "" {
+		return "DEPLOY_GITLAB_WEBHOOK_TOKEN"
+	}
+	return "DEPLOY_GITLAB_WEBHOOK_TOKEN/" + app
+}
+
+type gitlabReleaseEvent struct {
+	ObjectKind string `json:"object_kind"`
+	Action     string `json:"action"`
+	Tag        string `json:"tag"`
+	Project    struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+	Assets struct {
+		Links []struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+var gitlabForge = forge{
+	source:    SourceGitLab,
+	event:     func(r *http.Request) string { return r.Header.Get(GitLabEventHeader) },
+	signature: func(r *http.Request) string { return r.Header.Get(GitLabTokenHeader) },
+	delivery:  func(r *http.Request) string { return r.Header.Get(GitLabDeliveryHeader) },
+	verify: func(v *HMACValidator, _ []byte, token string, track bool) error {
+		return v.validateToken(token, track)
+	},
+	keys:     gitlabTokens,
+	requests: gitlabRequests,
+}
+
+// gitlabTokens returns the webhook token accepted for app, see
+// GitLabWebhookTokenKey; nil, rejecting every delivery, when unset.
+func gitlabTokens(h *Handler, app *AppConfig) *HMACValidator {
+	key := GitLabWebhookTokenKey("")
+	if app != nil && app.Allow != nil && app.Allow.Secret {
+		key = GitLabWebhookTokenKey(app.Name)
+	}
+	if h.Keys == nil {
+		return nil
+	}
+	token, err := h.Keys.Get(key)
+	if err != nil || token == "" {
+		return nil
+	}
+	h.Redact.Add(token)
+	return NewHMACValidator(token)
+}
+
+// HandleGitLab serves /gitlab, see handleForge.
+func (h *Handler) HandleGitLab(w http.ResponseWriter, r *http.Request) {
+	h.handleForge(w, r, gitlabForge)
+}
+
+func gitlabRequests(_ *Handler, _ context.Context, cfg *Config, event string, body []byte) (map[*AppConfig]UpdateRequest, string, error) {
+	if event != "Release Hook" {
+		return nil, "event not handled", nil
+	}
+	var ev gitlabReleaseEvent
+	if err := decodeEvent(body, &ev); err != nil {
+		return nil, "", err
+	}
+	if ev.Action != "create" {
+		return nil, "release " + ev.Action, nil
+	}
+	assets := make([]forgeAsset, len(ev.Assets.Links))
+	for i, l := range ev.Assets.Links {
+		assets[i] = forgeAsset{Name: l.Name, URL: l.DirectAssetURL}
+		if assets[i].URL == "" {
+			assets[i].URL = l.URL
+		}
+	}
+	return assetRequests(cfg, SourceGitLab, ev.Project.PathWithNamespace, ev.Tag, assets, ""), "", nil
+}
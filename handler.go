@@ -1,15 +1,20 @@
 package deploy
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"gopkg.in/yaml.v3"
 )
 
 type UpdateRequest struct {
@@ -17,16 +22,81 @@ type UpdateRequest struct {
 	Tag         string `json:"tag"`
 	Executable  string `json:"executable"`
 	DownloadURL string `json:"download_url"`
+
+	// Digest, when set, is the "sha256:<hex>" the downloaded binary must
+	// have; the deploy is refused otherwise. Set by Promote.
+	Digest string `json:"digest,omitempty"`
+	// PromotedFrom is the promotion chain claimed by the sender, recorded
+	// without verification; see PromotionStep.
+	PromotedFrom []PromotionStep `json:"promoted_from,omitempty"`
+
+	// Archive, when set, is the format of an archive at DownloadURL holding
+	// the executable. Only "zip" is supported, the format GitHub serves
+	// workflow artifacts in.
+	Archive string `json:"archive,omitempty"`
+
+	// Force redeploys the installed version, which is otherwise answered
+	// with "Already up to date" without touching the process, and deploys
+	// versions older than the installed one to apps with a channel or
+	// version_policy, which refuse them otherwise.
+	Force bool `json:"force,omitempty"`
 }
 
 type Handler struct {
 	Config     *Config
 	ConfigPath string
-	Validator  *HMACValidator
+	Validator  *HMACValidator // nil rejects signed requests
+	OIDC       *OIDCVerifier  // optional; accepts "Authorization: Bearer" tokens
 	Downloader Downloader
 	Process    ProcessManager
 	Checker    HealthChecker // Use interface
 	Keys       Store
+	State      *StateFile   // optional; default: StatePath(ConfigPath)
+	Log        *slog.Logger // optional; nil discards
+	Redact     *Redactor    // optional; masks secrets in error responses
+	Metrics    *Metrics     // optional; nil disables instrumentation
+	StartedAt  time.Time    // puller start, reported by /status
+
+	mu       sync.Mutex
+	runtime  map[string]*appRuntime
+	inflight sync.WaitGroup         // background deploys, see Wait
+	locks    map[string]*sync.Mutex // by app, see deployLock
+	idem     idempotencyCache
+	events   eventBus
+	current  atomic.Pointer[Config]
+}
+
+// config returns the active configuration: the last one passed to SetConfig,
+// or the Config field when SetConfig was never called.
+func (h *Handler) config() *Config {
+	if c := h.current.Load(); c != nil {
+		return c
+	}
+	return h.Config
+}
+
+// SetConfig atomically replaces the configuration used by new requests.
+// Deploys already in flight finish with the configuration they started with.
+func (h *Handler) SetConfig(cfg *Config) {
+	h.current.Store(cfg)
+}
+
+// Subscribe registers an observer for lifecycle events of every deploy
+// handled from now on. Call the returned function to unsubscribe.
+func (h *Handler) Subscribe(o Observer) (unsubscribe func()) {
+	return h.events.subscribe(o)
+}
+
+// httpError writes an error response with any known secret masked.
+func (h *Handler) httpError(w http.ResponseWriter, msg string, code int) {
+	http.Error(w, h.Redact.Redact(msg), code)
+}
+
+func (h *Handler) logger() *slog.Logger {
+	if h.Log == nil {
+		return discardLogger
+	}
+	return h.Log
 }
 
 func (h *Handler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
@@ -35,9 +105,21 @@ func (h *Handler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 1. Validate Signature
+	// 1. Read the request; nothing in it is trusted until step 2
+	bearer, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	hasToken = hasToken && h.OIDC != nil
 	signature := r.Header.Get("X-Signature")
-	if signature == "" {
+	if hasToken && relayed(r) {
+		// A token does not cover the body, so the relay could replay it
+		// with a body of its own; relayed requests must be signed.
+		if signature == "" {
+			h.logger().Warn("rejected update request", "remote", r.RemoteAddr, "error", "OIDC token through a relay")
+			http.Error(w, "OIDC tokens are not accepted through a relay; sign the request", http.StatusUnauthorized)
+			return
+		}
+		hasToken = false
+	}
+	if signature == "" && !hasToken {
 		http.Error(w, "Missing signature", http.StatusUnauthorized)
 		return
 	}
@@ -49,31 +131,141 @@ func (h *Handler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	if err := h.Validator.ValidateRequest(body, signature); err != nil {
+	var req UpdateRequest
+	parseErr := json.Unmarshal(body, &req)
+	cfg := h.config()
+	var app *AppConfig
+	for i := 0; parseErr == nil && cfg != nil && i < len(cfg.Apps); i++ {
+		if cfg.Apps[i].Executable == req.Executable {
+			app = &cfg.Apps[i]
+			break
+		}
+	}
+
+	// 2. Authenticate: OIDC token or signature
+	var claims *OIDCToken
+	if hasToken {
+		if claims, err = h.OIDC.Verify(r.Context(), bearer); err != nil {
+			h.logger().Warn("rejected update request", "remote", r.RemoteAddr, "error", err)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+	} else if err := h.verifySignature(r, app, h.validatorFor(app), body, signature, true); err != nil {
+		h.Metrics.ObserveHMACFailure()
+		h.logger().Warn("rejected update request", "remote", r.RemoteAddr, "error", err)
 		http.Error(w, "Invalid signature", http.StatusUnauthorized)
 		return
 	}
-
-	// 2. Parse Payload
-	var req UpdateRequest
-	if err := json.Unmarshal(body, &req); err != nil {
+	if parseErr != nil {
 		http.Error(w, "Invalid payload", http.StatusBadRequest)
 		return
 	}
-
-	// 3. Find App Config
-	var app *AppConfig
-	for i := range h.Config.Apps {
-		if h.Config.Apps[i].Executable == req.Executable {
-			app = &h.Config.Apps[i]
-			break
-		}
-	}
 	if app == nil {
+		h.logger().Warn("update for unconfigured app", "executable", req.Executable)
 		http.Error(w, "App not configured", http.StatusNotFound)
 		return
 	}
 
+	// 3. Authorize the request for the app
+	if err := authorize(app, &req, claims); err != nil {
+		h.logger().Warn("rejected update request", "app", app.Name, "repo", req.Repo, "tag", req.Tag, "error", err)
+		http.Error(w, "Request not allowed for this app", http.StatusForbidden)
+		return
+	}
+
+	// Replay the result of a request already seen with this key
+	deployID := NewDeployID()
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		fingerprint := requestFingerprint(body)
+		e, first := h.idem.claim(app.Name, key, fingerprint, deployID)
+		if !first {
+			if e.fingerprint != fingerprint {
+				http.Error(w, "Idempotency key reused for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+			select {
+			case <-e.done:
+			case <-r.Context().Done():
+				return
+			}
+			h.logger().Info("replayed update request", "app", app.Name, "deploy_id", e.deployID)
+			e.replay(w)
+			return
+		}
+		rec := &recordingResponse{ResponseWriter: w}
+		defer h.idem.finish(app.Name, key, e, rec)
+		w = rec
+	}
+
+	h.deploy(w, cfg, app, req, claims, deployID)
+}
+
+// admit checks req against the installed version of app: an error if the
+// version policy refuses its tag, or ok with the ID of the installing
+// deploy if that version is already installed.
+func (h *Handler) admit(app *AppConfig, req *UpdateRequest) (installedBy string, unchanged bool, err error) {
+	if err := checkVersion(app, h.installedVersion(app), req); err != nil {
+		return "", false, err
+	}
+	installedBy, unchanged = h.unchanged(app, req)
+	return installedBy, unchanged, nil
+}
+
+// deploy runs steps 4 to 11 for an authorized request, writing the outcome
+// to w and returning it. Deploys of one app run one at a time, whether
+// started by /update, a forge webhook, the poller or the relay; the
+// installed version is checked once the lock is held, so a request that
+// waited for the deploy of the same version is skipped.
+func (h *Handler) deploy(w http.ResponseWriter, cfg *Config, app *AppConfig, req UpdateRequest, claims *OIDCToken, deployID string) (outcome string) {
+	lock := h.deployLock(app)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if id, unchanged, err := h.admit(app, &req); err != nil {
+		h.Metrics.ObserveDeploy(app.Name, OutcomeRefused)
+		h.logger().Warn("rejected update request", "app", app.Name, "tag", req.Tag, "error", err)
+		http.Error(w, "Version not allowed: "+err.Error(), http.StatusConflict)
+		return OutcomeRefused
+	} else if unchanged {
+		h.Metrics.ObserveDeploy(app.Name, OutcomeUnchanged)
+		h.logger().Info("deploy skipped: version already installed", "app", app.Name, "version", req.Tag, "deploy_id", id)
+		if id != "" {
+			w.Header().Set("X-Deploy-Id", id)
+		}
+		w.Write([]byte("Already up to date"))
+		return OutcomeUnchanged
+	}
+
+	w.Header().Set("X-Deploy-Id", deployID)
+	log := h.logger().With("deploy_id", deployID, "app", app.Name)
+	if claims != nil {
+		log = log.With("oidc_subject", claims.Subject)
+	}
+	log.Info("deploy started", "tag", req.Tag, "repo", req.Repo)
+
+	emit := func(e Event) {
+		e.DeployID, e.App, e.Tag, e.Repo = deployID, app.Name, req.Tag, req.Repo
+		e.Err = h.Redact.Error(e.Err)
+		h.events.emit(e)
+	}
+	emit(Event{Type: EventDeployStarted})
+
+	outcome = OutcomeError
+	var failure error
+	rolledBack := false
+	defer func() {
+		h.Metrics.ObserveDeploy(app.Name, outcome)
+		h.recordDeploy(app.Name, DeployRecord{ID: deployID, Tag: req.Tag, Outcome: outcome, At: time.Now()})
+		if rolledBack {
+			emit(Event{Type: EventRolledBack, Err: failure})
+		}
+		if outcome == OutcomeSuccess {
+			emit(Event{Type: EventCompleted, Outcome: outcome, Version: app.Version})
+		} else {
+			emit(Event{Type: EventFailed, Outcome: outcome, Err: failure})
+		}
+	}()
+
 	// 4. Check Health (Busy Loop)
 	timeout := time.After(app.BusyTimeout)
 	ticker := time.NewTicker(app.BusyRetryInterval)
@@ -81,7 +273,7 @@ func (h *Handler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
 
 WaitLoop:
 	for {
-		status, err := h.Checker.Check(app.HealthEndpoint)
+		status, err := h.Checker.Check(app.healthURL())
 		if err != nil {
 			// If check fails (e.g. network error), assume not busy?
 			break WaitLoop
@@ -92,28 +284,79 @@ WaitLoop:
 
 		select {
 		case <-timeout:
+			outcome = OutcomeBusy
+			failure = errors.New("service busy")
+			log.Warn("deploy aborted: service busy", "timeout", app.BusyTimeout)
 			http.Error(w, "Service busy", http.StatusServiceUnavailable)
 			return
 		case <-ticker.C:
+			log.Debug("service busy, waiting", "status", status.Status)
 			continue
 		}
 	}
 
 	// 5. Download New Version
-	token, err := h.Keys.Get("DEPLOY_GITHUB_PAT")
+	src := app.source()
+	token, err := h.Keys.Get(src.TokenKey())
 	if err != nil {
-		http.Error(w, "Missing GitHub token", http.StatusInternalServerError)
+		failure = fmt.Errorf("missing %s", src.TokenKey())
+		log.Error("deploy failed: missing access token", "key", src.TokenKey())
+		http.Error(w, "Missing access token", http.StatusInternalServerError)
 		return
 	}
+	h.Redact.Add(token)
 
-	tempFile := filepath.Join(h.Config.Updater.TempDir, req.Executable+".new")
-	if err := h.Downloader.Download(req.DownloadURL, tempFile, token); err != nil {
-		http.Error(w, fmt.Sprintf("Download failed: %v", err), http.StatusInternalServerError)
+	tempFile := filepath.Join(cfg.Updater.TempDir, req.Executable+".new")
+	log.Debug("downloading", "url", req.DownloadURL, "dest", tempFile, "source", src)
+	started := time.Now()
+	if sd, ok := h.Downloader.(SourceDownloader); ok {
+		err = sd.DownloadFrom(src, req.DownloadURL, tempFile, token)
+	} else {
+		err = h.Downloader.Download(req.DownloadURL, tempFile, token)
+	}
+	h.Metrics.ObservePhase(app.Name, PhaseDownload, time.Since(started))
+	if err != nil {
+		outcome = OutcomeDownloadFailed
+		failure = err
+		log.Error("deploy failed: download", "error", err)
+		h.httpError(w, fmt.Sprintf("Download failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if req.Archive != "" {
+		err = extractArchive(req.Archive, tempFile, app.Executable)
+	}
+	if err != nil {
+		outcome = OutcomeDownloadFailed
+		failure = err
+		log.Error("deploy failed: extract", "error", err)
+		h.httpError(w, fmt.Sprintf("Download failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	digest, err := fileDigest(tempFile)
+	if err != nil {
+		outcome = OutcomeDownloadFailed
+		failure = err
+		log.Error("deploy failed: digest", "error", err)
+		h.httpError(w, fmt.Sprintf("Download failed: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if want := normalizeDigest(req.Digest); want != "" && want != digest {
+		os.Remove(tempFile)
+		outcome = OutcomeDigestMismatch
+		failure = fmt.Errorf("artifact digest %s, want %s", digest, want)
+		log.Error("deploy refused: digest mismatch", "digest", digest, "want", want)
+		http.Error(w, "Artifact digest mismatch", http.StatusConflict)
+		return
+	}
+	emit(Event{Type: EventDownloaded, Duration: time.Since(started)})
 
 	// 6. Stop Existing Process
-	_ = h.Process.Stop(app.Executable)
+	started = time.Now()
+	if err := h.Process.Stop(app.Executable); err != nil {
+		log.Debug("stop returned error", "error", err)
+	}
+	h.Metrics.ObservePhase(app.Name, PhaseStop, time.Since(started))
+	emit(Event{Type: EventStopped, Duration: time.Since(started)})
 
 	// 7. Backup Existing Binary
 	appPath := filepath.Join(app.Path, app.Executable)
@@ -121,7 +364,9 @@ WaitLoop:
 
 	if _, err := os.Stat(appPath); err == nil {
 		if err := os.Rename(appPath, backupPath); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to backup: %v", err), http.StatusInternalServerError)
+			failure = err
+			log.Error("deploy failed: backup", "error", err)
+			h.httpError(w, fmt.Sprintf("Failed to backup: %v", err), http.StatusInternalServerError)
 			return
 		}
 	}
@@ -131,13 +376,19 @@ WaitLoop:
 		// Try to restore backup
 		_ = os.Rename(backupPath, appPath)
 		// Restart old process if move failed
-		_ = h.Process.Start(appPath)
-		http.Error(w, fmt.Sprintf("Failed to install: %v", err), http.StatusInternalServerError)
+		_ = h.startProcess(app.Name, appPath)
+		outcome = OutcomeInstallFailed
+		failure = err
+		log.Error("deploy failed: install", "error", err)
+		h.httpError(w, fmt.Sprintf("Failed to install: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	// 9. Start New Process
-	if err := h.Process.Start(appPath); err != nil {
+	started = time.Now()
+	err = h.startProcess(app.Name, appPath)
+	h.Metrics.ObservePhase(app.Name, PhaseStart, time.Since(started))
+	if err != nil {
 		// Rollback
 		// Rename failed binary to app-failed.exe
 		failedPath := filepath.Join(app.Path, "app-failed.exe")
@@ -145,18 +396,30 @@ WaitLoop:
 		_ = os.Rename(appPath, failedPath)
 
 		_ = os.Rename(backupPath, appPath)
-		_ = h.Process.Start(appPath) // Try to restart old version
-		http.Error(w, fmt.Sprintf("Failed to start: %v", err), http.StatusInternalServerError)
+		_ = h.startProcess(app.Name, appPath) // Try to restart old version
+		outcome = OutcomeStartFailed
+		failure, rolledBack = err, true
+		h.Metrics.ObserveRollback(app.Name)
+		log.Error("deploy failed: start, rolled back", "error", err)
+		h.httpError(w, fmt.Sprintf("Failed to start: %v", err), http.StatusInternalServerError)
 		return
 	}
+	emit(Event{Type: EventStarted, Duration: time.Since(started)})
 
 	// 10. Health Check New Process
+	started = time.Now()
 	if app.StartupDelay > 0 {
 		time.Sleep(app.StartupDelay)
 	}
 
-	newStatus, err := h.Checker.Check(app.HealthEndpoint)
-	if err != nil || newStatus.Status != "ok" { // Assuming "ok" is success criteria
+	newStatus, err := h.Checker.Check(app.healthURL())
+	h.Metrics.ObservePhase(app.Name, PhaseHealth, time.Since(started))
+	if err == nil && newStatus.Status != "ok" {
+		err = fmt.Errorf("status %q", newStatus.Status)
+	}
+	if err != nil { // Assuming "ok" is success criteria
+		emit(Event{Type: EventHealthFailed, Err: err})
+
 		// Rollback
 		_ = h.Process.Stop(app.Executable)
 
@@ -166,21 +429,143 @@ WaitLoop:
 		_ = os.Rename(appPath, failedPath)
 
 		_ = os.Rename(backupPath, appPath)
-		_ = h.Process.Start(appPath)
+		_ = h.startProcess(app.Name, appPath)
+		outcome = OutcomeHealthFailed
+		failure, rolledBack = err, true
+		h.Metrics.ObserveRollback(app.Name)
+		log.Error("deploy failed: health check, rolled back", "error", err)
 		http.Error(w, "New version failed health check", http.StatusInternalServerError)
 		return
 	}
 
-	// 11. Update Config (Version)
-	if req.Tag != "" {
-		app.Version = req.Tag
-		if h.ConfigPath != "" {
-			if data, err := yaml.Marshal(h.Config); err == nil {
-				_ = os.WriteFile(h.ConfigPath, data, 0644)
-			}
-		}
+	// 11. Record the deployed version and artifact
+	d := Deployment{Version: req.Tag, DeployID: deployID, DeployedAt: time.Now().UTC(), Digest: digest, Repo: req.Repo, PromotedFrom: req.PromotedFrom}
+	if err := h.saveDeployment(app, d, req.DownloadURL); err != nil {
+		log.Warn("failed to persist version", "error", err)
 	}
 
+	outcome = OutcomeSuccess
+	h.Metrics.SetVersion(app.Name, app.Version)
+	log.Info("deploy completed", "version", app.Version)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Update successful"))
+	return
+}
+
+// deployLock returns the mutex serializing the deploys of app, which share
+// its temp file, backup and install paths whatever started them.
+func (h *Handler) deployLock(app *AppConfig) *sync.Mutex {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.locks == nil {
+		h.locks = make(map[string]*sync.Mutex)
+	}
+	l := h.locks[stateKey(app)]
+	if l == nil {
+		l = &sync.Mutex{}
+		h.locks[stateKey(app)] = l
+	}
+	return l
+}
+
+// installedVersion returns the version of app, which deploys update under
+// h.mu.
+func (h *Handler) installedVersion(app *AppConfig) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return app.Version
+}
+
+// saveDeployment records the version of d for app in both the deploy's
+// configuration snapshot and the active configuration (which a reload may
+// have replaced), then appends d to the app's history in the state file.
+// deploy.yaml itself is never rewritten.
+func (h *Handler) saveDeployment(app *AppConfig, d Deployment, downloadURL string) error {
+	if d.Version != "" {
+		h.mu.Lock()
+		app.Version = d.Version
+		cfg := h.config()
+		for i := range cfg.Apps {
+			if cfg.Apps[i].Name == app.Name {
+				cfg.Apps[i].Version = d.Version
+			}
+		}
+		h.mu.Unlock()
+	}
+
+	state := h.stateFile()
+	if state == nil {
+		return nil
+	}
+	return state.Update(func(s *State) error {
+		st := s.Apps[stateKey(app)]
+		st.record(d, downloadURL)
+		s.Apps[stateKey(app)] = st
+		return nil
+	})
+}
+
+// verifySignature checks the X-Signature of r over payload for app (nil
+// when the request targets none): ed25519 signatures against the
+// updater.signing_keys allowed for app, anything else against the HMAC keys
+// of v, recording the HMAC key's use when track is set.
+func (h *Handler) verifySignature(r *http.Request, app *AppConfig, v *HMACValidator, payload []byte, signature string, track bool) error {
+	if isEd25519Signature(signature) {
+		keyID := r.Header.Get(SignatureKeyIDHeader)
+		if !allowsSigningKey(app, keyID) {
+			return fmt.Errorf("signing key %q not allowed for app %s", keyID, app.Name)
+		}
+		return verifyEd25519(h.config().Updater.SigningKeys, keyID, payload, signature)
+	}
+	return v.validate(payload, signature, r.Header.Get(SignatureKeyIDHeader), track)
+}
+
+// validatorFor returns the HMAC keys accepted for app: its own secret when
+// allow.secret is set, otherwise the shared key set. A missing app secret
+// yields nil, which rejects every signature.
+func (h *Handler) validatorFor(app *AppConfig) *HMACValidator {
+	if app == nil || app.Allow == nil || !app.Allow.Secret {
+		return h.Validator
+	}
+	secret, err := h.Keys.Get(AppSecretKey(app.Name))
+	if err != nil || secret == "" {
+		h.logger().Warn("app secret not configured", "app", app.Name, "key", AppSecretKey(app.Name))
+		return nil
+	}
+	h.Redact.Add(secret)
+	return NewHMACValidator(secret)
+}
+
+// stateFile returns the state file of the handler, or nil when it has none.
+func (h *Handler) stateFile() *StateFile {
+	if h.State != nil {
+		return h.State
+	}
+	if h.ConfigPath == "" {
+		return nil
+	}
+	return &StateFile{Path: StatePath(h.ConfigPath)}
+}
+
+// fileDigest returns the "sha256:<hex>" digest of the file at path.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// normalizeDigest accepts "sha256:<hex>" or a bare hex digest.
+func normalizeDigest(d string) string {
+	d = strings.ToLower(strings.TrimSpace(d))
+	if d != "" && !strings.HasPrefix(d, "sha256:") {
+		d = "sha256:" + d
+	}
+	return d
 }
@@ -7,17 +7,87 @@ import (
 	"encoding/hex"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 )
 
+// DefaultHMACKeyID names a secret set before key rotation existed.
+const DefaultHMACKeyID = "default"
+
+// HMACKey is one secret of a key set. Requests name the key that signed
+// them with SignatureKeyIDHeader; without it every active key is tried.
+type HMACKey struct {
+	ID       string    `json:"id"`
+	Secret   string    `json:"secret"`
+	Created  time.Time `json:"created"`
+	NotAfter time.Time `json:"not_after,omitempty"` // end of the overlap window; zero never expires
+}
+
+// active reports whether k still validates at now.
+func (k HMACKey) active(now time.Time) bool {
+	return k.NotAfter.IsZero() || now.Before(k.NotAfter)
+}
+
+// HMACKeyStatus is what a puller reports about one of its keys in /status.
+type HMACKeyStatus struct {
+	ID       string     `json:"id"`
+	Created  *time.Time `json:"created,omitempty"`
+	NotAfter *time.Time `json:"not_after,omitempty"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
 type HMACValidator struct {
-	secret []byte
+	mu       sync.Mutex
+	keys     []HMACKey // oldest first; the last one signs
+	lastUsed map[string]time.Time
 }
 
 func NewHMACValidator(secret string) *HMACValidator {
-	return &HMACValidator{secret: []byte(secret)}
+	return NewHMACKeySet([]HMACKey{{ID: DefaultHMACKeyID, Secret: secret}})
+}
+
+// NewHMACKeySet returns a validator accepting signatures of any active key.
+func NewHMACKeySet(keys []HMACKey) *HMACValidator {
+	v := &HMACValidator{lastUsed: make(map[string]time.Time)}
+	v.SetKeys(keys)
+	return v
+}
+
+// SetKeys replaces the key set, keeping the usage recorded for known IDs.
+func (v *HMACValidator) SetKeys(keys []HMACKey) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys = append([]HMACKey(nil), keys...)
+}
+
+// Sign returns the X-Signature header value for payload, made with the
+// newest key.
+func (v *HMACValidator) Sign(payload []byte) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.keys) == 0 {
+		return ""
+	}
+	return "sha256=" + hex.EncodeToString(hmacSum(v.keys[len(v.keys)-1].Secret, payload))
 }
 
+// ValidateRequest checks signature over payload. A nil validator, used when
+// no HMAC secret is configured, rejects every request.
 func (v *HMACValidator) ValidateRequest(payload []byte, signature string) error {
+	return v.ValidateKey(payload, signature, "")
+}
+
+// ValidateKey is ValidateRequest for a signature made with key keyID; an
+// empty keyID accepts any active key. The key is reported as used in Keys.
+func (v *HMACValidator) ValidateKey(payload []byte, signature, keyID string) error {
+	return v.validate(payload, signature, keyID, true)
+}
+
+// validate checks signature, recording the key's use when track is set.
+func (v *HMACValidator) validate(payload []byte, signature, keyID string, track bool) error {
+	if v == nil {
+		return fmt.Errorf("HMAC authentication not configured")
+	}
 	if !strings.HasPrefix(signature, "sha256=") {
 		return fmt.Errorf("invalid signature format")
 	}
@@ -27,12 +97,77 @@ func (v *HMACValidator) ValidateRequest(payload []byte, signature string) error
 		return fmt.Errorf("invalid hex signature: %w", err)
 	}
 
-	mac := hmac.New(sha256.New, v.secret)
-	mac.Write(payload)
-	expectedMAC := mac.Sum(nil)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	known := false
+	for _, k := range v.keys {
+		if keyID != "" && k.ID != keyID {
+			continue
+		}
+		known = true
+		if !k.active(now) {
+			continue
+		}
+		if subtle.ConstantTimeCompare(providedBytes, hmacSum(k.Secret, payload)) == 1 {
+			if track {
+				v.lastUsed[k.ID] = now
+			}
+			return nil
+		}
+	}
+	if keyID != "" && !known {
+		return fmt.Errorf("unknown key %q", keyID)
+	}
+	return fmt.Errorf("signature mismatch")
+}
 
-	if subtle.ConstantTimeCompare(providedBytes, expectedMAC) != 1 {
-		return fmt.Errorf("signature mismatch")
+// validateToken checks a secret sent as is, as GitLab webhooks do, against
+// the active keys.
+func (v *HMACValidator) validateToken(token string, track bool) error {
+	if v == nil {
+		return fmt.Errorf("HMAC authentication not configured")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for _, k := range v.keys {
+		if k.active(now) && subtle.ConstantTimeCompare([]byte(token), []byte(k.Secret)) == 1 {
+			if track {
+				v.lastUsed[k.ID] = now
+			}
+			return nil
+		}
 	}
-	return nil
+	return fmt.Errorf("token mismatch")
+}
+
+// Keys reports the key set and when each key last validated a deploy.
+func (v *HMACValidator) Keys() []HMACKeyStatus {
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	out := make([]HMACKeyStatus, 0, len(v.keys))
+	for _, k := range v.keys {
+		st := HMACKeyStatus{ID: k.ID}
+		if !k.Created.IsZero() {
+			st.Created = &k.Created
+		}
+		if !k.NotAfter.IsZero() {
+			st.NotAfter = &k.NotAfter
+		}
+		if t, ok := v.lastUsed[k.ID]; ok {
+			st.LastUsed = &t
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+func hmacSum(secret string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
 }
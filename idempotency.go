@@ -0,0 +1,167 @@
+package deploy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader carries a key identifying an /update request, so a
+// CI retry returns the result of the first request instead of deploying
+// again. Keys are scoped to the app and remembered for IdempotencyTTL;
+// results of server errors (5xx) are forgotten so retries run again.
+//
+//	curl -H "Idempotency-Key: $GITHUB_RUN_ID-$GITHUB_RUN_ATTEMPT" ...
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayHeader is set on responses replayed for a known key.
+const IdempotentReplayHeader = "Idempotent-Replayed"
+
+// IdempotencyTTL is how long request keys are remembered.
+const IdempotencyTTL = 24 * time.Hour
+
+// idempotencyLimit bounds the keys remembered; the oldest go first.
+const idempotencyLimit = 1000
+
+// idempotencyEntry is the first request with a key and, once done is
+// closed, its response.
+type idempotencyEntry struct {
+	fingerprint string // sha256 of the request body
+	deployID    string // of the deploy the request started
+	at          time.Time
+	done        chan struct{}
+	code        int
+	contentType string
+	resultID    string // X-Deploy-Id of the response
+	body        []byte
+}
+
+// idempotencyCache remembers requests by app and key.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+// requestFingerprint identifies a request body.
+func requestFingerprint(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// claim returns the entry of key for app, creating it when unknown. The
+// caller that created it (first is true) must finish it.
+func (c *idempotencyCache) claim(app, key, fingerprint, deployID string) (e *idempotencyEntry, first bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*idempotencyEntry)
+	}
+	k := app + "\x00" + key
+	now := time.Now()
+	if e, ok := c.entries[k]; ok && now.Sub(e.at) < IdempotencyTTL {
+		return e, false
+	}
+
+	var oldest string
+	for ek, ee := range c.entries {
+		if now.Sub(ee.at) >= IdempotencyTTL {
+			delete(c.entries, ek)
+		} else if oldest == "" || ee.at.Before(c.entries[oldest].at) {
+			oldest = ek
+		}
+	}
+	if len(c.entries) >= idempotencyLimit {
+		delete(c.entries, oldest)
+	}
+	e = &idempotencyEntry{fingerprint: fingerprint, deployID: deployID, at: now, done: make(chan struct{})}
+	c.entries[k] = e
+	return e, true
+}
+
+// finish records the response rec of the request that claimed e, and
+// forgets e after a server error.
+func (c *idempotencyCache) finish(app, key string, e *idempotencyEntry, rec *recordingResponse) {
+	e.code, e.body = rec.status(), rec.body.Bytes()
+	e.contentType, e.resultID = rec.Header().Get("Content-Type"), rec.Header().Get("X-Deploy-Id")
+	if e.code >= 500 {
+		c.mu.Lock()
+		if c.entries[app+"\x00"+key] == e {
+			delete(c.entries, app+"\x00"+key)
+		}
+		c.mu.Unlock()
+	}
+	close(e.done)
+}
+
+// replay writes the recorded response of e to w.
+func (e *idempotencyEntry) replay(w http.ResponseWriter) {
+	w.Header().Set(IdempotentReplayHeader, "true")
+	if e.resultID != "" {
+		w.Header().Set("X-Deploy-Id", e.resultID)
+	}
+	if e.contentType != "" {
+		w.Header().Set("Content-Type", e.contentType)
+	}
+	w.WriteHeader(e.code)
+	w.Write(e.body)
+}
+
+// recordingResponse passes a response through, keeping its status and body
+// for replays.
+type recordingResponse struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *recordingResponse) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recordingResponse) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recordingResponse) status() int {
+	if r.code == 0 {
+		return http.StatusOK
+	}
+	return r.code
+}
+
+// unchanged reports whether req asks for the version of app already
+// installed, with the same digest when it names one, returning the ID of
+// the deploy that installed it. Forced requests are never unchanged.
+func (h *Handler) unchanged(app *AppConfig, req *UpdateRequest) (deployID string, ok bool) {
+	if req.Force || req.Tag == "" || req.Tag != h.installedVersion(app) {
+		return "", false
+	}
+	var st AppState
+	if f := h.stateFile(); f != nil {
+		if s, err := f.Read(); err == nil {
+			st = s.Apps[stateKey(app)]
+		}
+	}
+	if want := normalizeDigest(req.Digest); want != "" && want != st.Digest {
+		return "", false
+	}
+	if st.DeployID != "" {
+		return st.DeployID, true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if rt := h.runtime[app.Name]; rt != nil && rt.lastDeploy != nil && rt.lastDeploy.Tag == req.Tag && rt.lastDeploy.Outcome == OutcomeSuccess {
+		deployID = rt.lastDeploy.ID
+	}
+	return deployID, true
+}
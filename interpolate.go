@@ -0,0 +1,233 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SecretScheme prefixes values resolved through the Store, e.g.
+// `url: secret://SLACK_WEBHOOK_URL`.
+const SecretScheme = "secret://"
+
+// configRef remembers a scalar that was interpolated at Load time, so the
+// reference instead of the resolved value is written back.
+type configRef struct {
+	raw       string // as written in the file
+	canonical string // resolved value as Marshal renders it
+	secret    string // resolved secret, empty for env-only references
+}
+
+// interpolator expands ${VAR}, ${VAR:-default} and secret://KEY in scalar
+// values. $${ is a literal ${.
+type interpolator struct {
+	lookupEnv func(string) (string, bool)
+	secret    func(key string) (string, error)
+	report    func(path string, n *yaml.Node, err error)
+	refs      map[string]configRef
+}
+
+// scalar interpolates one scalar value; it is called through eachScalar, so
+// mapping keys are left alone.
+func (ip *interpolator) scalar(path string, n *yaml.Node) {
+	raw := n.Value
+	ref := configRef{raw: raw}
+	switch {
+	case strings.HasPrefix(raw, SecretScheme):
+		key := strings.TrimPrefix(raw, SecretScheme)
+		if ip.secret == nil {
+			ip.report(path, n, fmt.Errorf("%s%s needs a Store to resolve", SecretScheme, key))
+			return
+		}
+		v, err := ip.secret(key)
+		if err != nil {
+			ip.report(path, n, fmt.Errorf("secret %q: %w", key, err))
+			return
+		}
+		if v == "" {
+			ip.report(path, n, fmt.Errorf("secret %q is not set", key))
+			return
+		}
+		n.Value, ref.secret = v, v
+	case strings.Contains(raw, "${"):
+		v, err := ip.expand(raw)
+		if err != nil {
+			ip.report(path, n, err)
+			return
+		}
+		n.Value = v
+	default:
+		return
+	}
+	if n.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+		n.Tag = "" // let the resolved value decide, so ${PORT} can fill an int
+	}
+	if ip.refs != nil {
+		ip.refs[path] = ref
+	}
+}
+
+// expand substitutes ${VAR} and ${VAR:-default} in s.
+func (ip *interpolator) expand(s string) (string, error) {
+	var b strings.Builder
+	var errs []error
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		if i > 0 && s[i-1] == '$' { // $${ escapes
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			errs = append(errs, fmt.Errorf("unterminated reference in %q", s))
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		expr := s[i+2 : i+end]
+		name, def, hasDef := strings.Cut(expr, ":-")
+		v, ok := ip.lookupEnv(name)
+		switch {
+		case ok && v != "":
+			b.WriteString(v)
+		case hasDef:
+			b.WriteString(def)
+		case ok:
+			// set but empty, no default: keep empty
+		default:
+			errs = append(errs, fmt.Errorf("environment variable %s is not set", name))
+		}
+		s = s[i+end+1:]
+	}
+	return b.String(), errors.Join(errs...)
+}
+
+// resolveRefs interpolates every scalar under n, whose config path is path.
+// Unresolved references are returned as one joined error.
+func resolveRefs(n *yaml.Node, path string, store Store) (map[string]configRef, error) {
+	var errs []error
+	ip := &interpolator{
+		lookupEnv: os.LookupEnv,
+		report: func(path string, _ *yaml.Node, err error) {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		},
+		refs: make(map[string]configRef),
+	}
+	if store != nil {
+		ip.secret = store.Get
+	}
+	eachScalar(n, path, ip.scalar)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("unresolved references: %w", errors.Join(errs...))
+	}
+	return ip.refs, nil
+}
+
+// decodeConfig interpolates root and decodes it into a Config that remembers
+// its references.
+func decodeConfig(root *yaml.Node, store Store) (*Config, error) {
+	refs, err := resolveRefs(root, "", store)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := root.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	cfg.mainApps = len(cfg.Apps)
+	if len(refs) > 0 {
+		// Record each resolved value as Marshal will render it, so later
+		// changes (e.g. a new version) are told apart from untouched fields.
+		var n yaml.Node
+		if err := n.Encode(plainConfig(cfg)); err == nil {
+			eachScalar(&n, "", func(path string, s *yaml.Node) {
+				if ref, ok := refs[path]; ok {
+					ref.canonical = s.Value
+					refs[path] = ref
+				}
+			})
+		}
+		cfg.refs = refs
+	}
+	return &cfg, nil
+}
+
+// plainConfig has Config's fields without its MarshalYAML method.
+type plainConfig Config
+
+// MarshalYAML writes interpolated values back as the references they were
+// loaded from. Values changed since Load are written as they are, except that
+// a resolved secret is always replaced by its reference, wherever it appears.
+//
+// Apps loaded from apps.d fragments are left out; they belong to their own files.
+func (c Config) MarshalYAML() (any, error) {
+	if c.fragments != nil {
+		c.Apps = c.Apps[:c.mainApps]
+	}
+	var n yaml.Node
+	if err := n.Encode(plainConfig(c)); err != nil {
+		return nil, err
+	}
+	if len(c.refs) == 0 {
+		return &n, nil
+	}
+	secrets := make(map[string]string)
+	for _, ref := range c.refs {
+		if ref.secret != "" {
+			secrets[ref.secret] = ref.raw
+		}
+	}
+	eachScalar(&n, "", func(path string, s *yaml.Node) {
+		raw, ok := secrets[s.Value]
+		if ref, found := c.refs[path]; !ok && found && s.Value == ref.canonical {
+			raw, ok = ref.raw, true
+		}
+		if ok {
+			s.Value, s.Tag, s.Style = raw, "!!str", 0
+		}
+	})
+	return &n, nil
+}
+
+// SecretValues returns the secrets resolved from secret:// references, for
+// registering with a Redactor.
+func (c *Config) SecretValues() []string {
+	var out []string
+	for _, refs := range append([]map[string]configRef{c.refs}, c.fragmentRefs()...) {
+		for _, path := range sortedKeys(refs) {
+			if s := refs[path].secret; s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// eachScalar calls fn for every scalar value under n with its config path.
+func eachScalar(n *yaml.Node, path string, fn func(path string, s *yaml.Node)) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			eachScalar(c, path, fn)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			eachScalar(n.Content[i+1], joinPath(path, n.Content[i].Value), fn)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			eachScalar(c, fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	case yaml.ScalarNode:
+		fn(path, n)
+	}
+}
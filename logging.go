@@ -0,0 +1,277 @@
+package deploy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ParseLogLevel maps the log_level values accepted in deploy.yaml to slog levels.
+// An empty value means "info".
+func ParseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("deploy: unknown log level %q", level)
+}
+
+// NewLogger builds the puller logger from the updater configuration.
+// Records go to log_file (text or json, rotated by size) when configured,
+// and always to hook, which keeps the untyped SetLog(func(...any)) contract
+// used by the TUI. The returned closer releases the log file.
+func NewLogger(u ConfigUpdater, hook func(...any)) (*slog.Logger, io.Closer, error) {
+	level, err := ParseLogLevel(u.LogLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	handlers := []slog.Handler{newHookHandler(hook, level)}
+	var closer io.Closer = nopCloser{}
+
+	if u.LogFile != "" {
+		maxSize := int64(u.LogMaxSize)
+		if maxSize <= 0 {
+			maxSize = 10
+		}
+		backups := u.LogMaxBackups
+		if backups <= 0 {
+			backups = 3
+		}
+		rf, err := NewRotatingFile(u.LogFile, maxSize*1024*1024, backups)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts := &slog.HandlerOptions{Level: level}
+		switch strings.ToLower(u.LogFormat) {
+		case "", "text":
+			handlers = append(handlers, slog.NewTextHandler(rf, opts))
+		case "json":
+			handlers = append(handlers, slog.NewJSONHandler(rf, opts))
+		default:
+			rf.Close()
+			return nil, nil, fmt.Errorf("deploy: unknown log format %q", u.LogFormat)
+		}
+		closer = rf
+	}
+
+	return slog.New(fanoutHandler(handlers)), closer, nil
+}
+
+// NewDeployID returns a short random identifier used to correlate all log
+// lines of a single deployment.
+func NewDeployID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "000000000000"
+	}
+	return hex.EncodeToString(b)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// discardLogger is used by components that were built without a logger.
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// ── hook handler ──────────────────────────────────────────────────────────────
+
+// hookHandler forwards records to a func(...any) as
+// [LEVEL] message key=value..., omitting the level prefix for info records.
+type hookHandler struct {
+	hook  func(...any)
+	level slog.Leveler
+	attrs []slog.Attr
+	group string
+}
+
+func newHookHandler(hook func(...any), level slog.Leveler) *hookHandler {
+	return &hookHandler{hook: hook, level: level}
+}
+
+func (h *hookHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return h.hook != nil && l >= h.level.Level()
+}
+
+func (h *hookHandler) Handle(_ context.Context, r slog.Record) error {
+	parts := make([]any, 0, 2+len(h.attrs)+r.NumAttrs())
+	if r.Level != slog.LevelInfo {
+		parts = append(parts, "["+r.Level.String()+"]")
+	}
+	parts = append(parts, r.Message)
+	for _, a := range h.attrs {
+		parts = append(parts, a.Key+"="+a.Value.String())
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		key := a.Key
+		if h.group != "" {
+			key = h.group + "." + key
+		}
+		parts = append(parts, key+"="+a.Value.String())
+		return true
+	})
+	h.hook(parts...)
+	return nil
+}
+
+func (h *hookHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + "." + a.Key
+		}
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *hookHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	if c.group != "" {
+		name = c.group + "." + name
+	}
+	c.group = name
+	return &c
+}
+
+// ── fan-out handler ───────────────────────────────────────────────────────────
+
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range f {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanoutHandler, len(f))
+	for i, h := range f {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	out := make(fanoutHandler, len(f))
+	for i, h := range f {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+// ── rotating file ─────────────────────────────────────────────────────────────
+
+// RotatingFile is an io.WriteCloser that renames the file to path.1, path.2, …
+// once it grows past maxSize bytes, keeping at most backups old files.
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+// NewRotatingFile opens (or creates) path for appending.
+func NewRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0755); err != nil {
+		return fmt.Errorf("deploy: create log dir: %w", err)
+	}
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("deploy: open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("deploy: stat log file: %w", err)
+	}
+	rf.f = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.backups))
+	for i := rf.backups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if rf.backups > 0 {
+		_ = os.Rename(rf.path, rf.path+".1")
+	} else {
+		_ = os.Remove(rf.path)
+	}
+	return rf.open()
+}
+
+// Close closes the underlying file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,209 @@
+package deploy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deploy outcomes recorded in deploy_deploys_total.
+const (
+	OutcomeSuccess        = "success"
+	OutcomeBusy           = "busy"
+	OutcomeDownloadFailed = "download_failed"
+	OutcomeDigestMismatch = "digest_mismatch"
+	OutcomeInstallFailed  = "install_failed"
+	OutcomeStartFailed    = "start_failed"
+	OutcomeHealthFailed   = "health_failed"
+	OutcomeError          = "error"
+	OutcomeUnchanged      = "unchanged" // the requested version was already installed
+	OutcomeRefused        = "refused"   // the version policy does not allow the tag
+)
+
+// Deploy phases recorded in deploy_phase_duration_seconds.
+const (
+	PhaseDownload = "download"
+	PhaseStop     = "stop"
+	PhaseStart    = "start"
+	PhaseHealth   = "health_check"
+)
+
+// phaseBuckets are the histogram upper bounds, in seconds.
+var phaseBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Metrics collects puller counters, histograms and gauges and serves them
+// in the Prometheus text exposition format. A nil *Metrics is a no-op.
+type Metrics struct {
+	mu           sync.Mutex
+	deploys      map[[2]string]uint64 // app, outcome
+	rollbacks    map[string]uint64
+	hmacFailures uint64
+	phases       map[[2]string]*histogram // app, phase
+	versions     map[string]string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, non-cumulative; last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics returns an empty metrics registry.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		deploys:   make(map[[2]string]uint64),
+		rollbacks: make(map[string]uint64),
+		phases:    make(map[[2]string]*histogram),
+		versions:  make(map[string]string),
+	}
+}
+
+// ObserveDeploy counts a finished deploy request for app.
+func (m *Metrics) ObserveDeploy(app, outcome string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deploys[[2]string{app, outcome}]++
+}
+
+// ObserveRollback counts an automatic rollback for app.
+func (m *Metrics) ObserveRollback(app string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollbacks[app]++
+}
+
+// ObserveHMACFailure counts a request rejected for its signature.
+func (m *Metrics) ObserveHMACFailure() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hmacFailures++
+}
+
+// ObservePhase records how long a deploy phase took for app.
+func (m *Metrics) ObservePhase(app, phase string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := [2]string{app, phase}
+	h := m.phases[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(phaseBuckets)+1)}
+		m.phases[key] = h
+	}
+	secs := d.Seconds()
+	i := sort.SearchFloat64s(phaseBuckets, secs)
+	h.counts[i]++
+	h.sum += secs
+	h.count++
+}
+
+// SetVersion records the version currently running for app.
+func (m *Metrics) SetVersion(app, version string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.versions[app] = version
+}
+
+// ServeHTTP writes all metrics in Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes all metrics in Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	if m != nil {
+		m.mu.Lock()
+		m.write(&b)
+		m.mu.Unlock()
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) write(b *strings.Builder) {
+	b.WriteString("# HELP deploy_deploys_total Deploy requests by app and outcome.\n")
+	b.WriteString("# TYPE deploy_deploys_total counter\n")
+	for _, k := range sortedPairs(m.deploys) {
+		fmt.Fprintf(b, "deploy_deploys_total{app=%s,outcome=%s} %d\n", quoteLabel(k[0]), quoteLabel(k[1]), m.deploys[k])
+	}
+
+	b.WriteString("# HELP deploy_rollbacks_total Automatic rollbacks by app.\n")
+	b.WriteString("# TYPE deploy_rollbacks_total counter\n")
+	for _, app := range sortedKeys(m.rollbacks) {
+		fmt.Fprintf(b, "deploy_rollbacks_total{app=%s} %d\n", quoteLabel(app), m.rollbacks[app])
+	}
+
+	b.WriteString("# HELP deploy_hmac_failures_total Requests rejected for an invalid signature.\n")
+	b.WriteString("# TYPE deploy_hmac_failures_total counter\n")
+	fmt.Fprintf(b, "deploy_hmac_failures_total %d\n", m.hmacFailures)
+
+	b.WriteString("# HELP deploy_phase_duration_seconds Duration of deploy phases.\n")
+	b.WriteString("# TYPE deploy_phase_duration_seconds histogram\n")
+	for _, k := range sortedPairs(m.phases) {
+		h := m.phases[k]
+		labels := fmt.Sprintf("app=%s,phase=%s", quoteLabel(k[0]), quoteLabel(k[1]))
+		var cum uint64
+		for i, le := range phaseBuckets {
+			cum += h.counts[i]
+			fmt.Fprintf(b, "deploy_phase_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, le, cum)
+		}
+		fmt.Fprintf(b, "deploy_phase_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(b, "deploy_phase_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(b, "deploy_phase_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	b.WriteString("# HELP deploy_app_version_info Version currently running per app.\n")
+	b.WriteString("# TYPE deploy_app_version_info gauge\n")
+	for _, app := range sortedKeys(m.versions) {
+		fmt.Fprintf(b, "deploy_app_version_info{app=%s,version=%s} 1\n", quoteLabel(app), quoteLabel(m.versions[app]))
+	}
+}
+
+func quoteLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPairs[V any](m map[[2]string]V) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
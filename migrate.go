@@ -0,0 +1,245 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigVersion is the deploy.yaml schema version written by this puller.
+// Files without a version: key are version 1.
+const ConfigVersion = 2
+
+// StoreVersion is the layout version of the keys this puller keeps in the
+// Store, recorded under storeVersionKey.
+const StoreVersion = 3
+
+const storeVersionKey = "DEPLOY_STORE_VERSION"
+
+// legacyMethods maps deploy method names used by older releases to the
+// current pusher names.
+var legacyMethods = map[string]string{
+	"cloudflare": "cloudflarePages",
+	"edgeworker": "cloudflarePages",
+}
+
+// configMigration upgrades a deploy.yaml from version From to From+1.
+type configMigration struct {
+	From        int
+	Description string
+	Apply       func(doc *ConfigDoc, configPath string, note func(string, ...any)) error
+}
+
+// storeMigration upgrades Store keys from version From to From+1.
+type storeMigration struct {
+	From        int
+	Description string
+	Apply       func(s Store, note func(string, ...any)) error
+}
+
+var configMigrations = []configMigration{
+	{1, "move deployed app versions to the state file", migrateVersionsToState},
+}
+
+// errMigrationPending is returned by a store migration that cannot run yet.
+// The puller starts anyway and the step runs again on the next start.
+var errMigrationPending = errors.New("migration pending")
+
+var storeMigrations = []storeMigration{
+	{1, "rename legacy deploy methods", migrateLegacyMethod},
+	{2, "move CF_PAGES_TOKEN to goflare/<project>", migratePagesToken},
+}
+
+// MigrateConfig upgrades the deploy.yaml at path to ConfigVersion, one
+// version at a time, logging each change. The original is copied to
+// <path>.v<N>.bak before anything is written. It reports whether the file
+// was changed; a missing file is not an error.
+func MigrateConfig(path string, log *slog.Logger) (migrated bool, err error) {
+	if log == nil {
+		log = discardLogger
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	from, err := configFileVersion(data)
+	if err != nil {
+		return false, fmt.Errorf("deploy: %s: %w", path, err)
+	}
+	if from > ConfigVersion {
+		return false, fmt.Errorf("deploy: %s has version %d, newer than this puller supports (%d)", path, from, ConfigVersion)
+	}
+	if from == ConfigVersion {
+		return false, nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d.bak", path, from)
+	if err := writeFileAtomic(backup, data, 0600); err != nil {
+		return false, fmt.Errorf("deploy: back up %s: %w", path, err)
+	}
+	err = EditConfig(path, func(doc *ConfigDoc) error {
+		for _, m := range configMigrations {
+			if m.From < from {
+				continue
+			}
+			note := func(format string, args ...any) {
+				log.Info("config migration: "+fmt.Sprintf(format, args...), "path", path, "from", m.From, "to", m.From+1)
+			}
+			if err := m.Apply(doc, path, note); err != nil {
+				return fmt.Errorf("deploy: migrate %s to version %d (%s): %w", path, m.From+1, m.Description, err)
+			}
+		}
+		return doc.setVersion(ConfigVersion)
+	})
+	if err != nil {
+		return false, err
+	}
+	log.Info("config migrated", "path", path, "from", from, "to", ConfigVersion, "backup", backup)
+	return true, nil
+}
+
+// MigrateStore upgrades the deploy keys in s to StoreVersion. Each step is
+// idempotent, so an interrupted migration is simply run again.
+func MigrateStore(s Store, log *slog.Logger) error {
+	if log == nil {
+		log = discardLogger
+	}
+	from := 1
+	if v, err := s.Get(storeVersionKey); err == nil && v != "" {
+		if from, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("deploy: invalid %s %q", storeVersionKey, v)
+		}
+	}
+	if from >= StoreVersion {
+		return nil
+	}
+	for _, m := range storeMigrations {
+		if m.From < from {
+			continue
+		}
+		note := func(format string, args ...any) {
+			log.Info("store migration: "+fmt.Sprintf(format, args...), "from", m.From, "to", m.From+1)
+		}
+		if err := m.Apply(s, note); errors.Is(err, errMigrationPending) {
+			log.Warn("store migration skipped", "from", m.From, "to", m.From+1, "reason", err)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("deploy: migrate store to version %d (%s): %w", m.From+1, m.Description, err)
+		}
+		if err := s.Set(storeVersionKey, strconv.Itoa(m.From+1)); err != nil {
+			return fmt.Errorf("deploy: record store version: %w", err)
+		}
+	}
+	return nil
+}
+
+// configFileVersion reads the top-level version: key; absent means 1.
+func configFileVersion(data []byte) (int, error) {
+	var head struct {
+		Version *int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &head); err != nil {
+		return 0, err
+	}
+	if head.Version == nil {
+		return 1, nil
+	}
+	return *head.Version, nil
+}
+
+// setVersion writes the top-level version: key, placing it first when new.
+func (d *ConfigDoc) setVersion(v int) error {
+	if d.Get("version") != nil {
+		return d.Set("version", v)
+	}
+	root := d.root.Content[0]
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	val := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(v)}
+	// Keep a leading file comment above the new key.
+	if len(root.Content) > 0 {
+		key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+	}
+	root.Content = append([]*yaml.Node{key, val}, root.Content...)
+	return nil
+}
+
+// migrateVersionsToState moves apps[].version out of deploy.yaml; since
+// version 2 deployed versions are runtime state. Versions already recorded
+// in the state file win.
+func migrateVersionsToState(doc *ConfigDoc, configPath string, note func(string, ...any)) error {
+	apps := doc.Get("apps")
+	if apps == nil || apps.Kind != yaml.SequenceNode {
+		return nil
+	}
+	found := make(map[string]string)
+	for i := range apps.Content {
+		var app AppConfig
+		if err := apps.Content[i].Decode(&app); err != nil || app.Version == "" {
+			continue
+		}
+		found[stateKey(&app)] = app.Version
+		doc.Delete(fmt.Sprintf("apps[%d].version", i))
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	state := &StateFile{Path: StatePath(configPath)}
+	return state.Update(func(s *State) error {
+		for _, name := range sortedKeys(found) {
+			if _, ok := s.Apps[name]; ok {
+				note("dropped apps[%s].version %s, state file already records a version", name, found[name])
+				continue
+			}
+			s.Apps[name] = AppState{Version: found[name], DeployedAt: time.Now().UTC()}
+			note("moved %s version %s to %s", name, found[name], state.Path)
+		}
+		return nil
+	})
+}
+
+func migrateLegacyMethod(s Store, note func(string, ...any)) error {
+	method, err := s.Get("DEPLOY_METHOD")
+	if err != nil {
+		return nil
+	}
+	if current, ok := legacyMethods[strings.ToLower(method)]; ok {
+		if err := s.Set("DEPLOY_METHOD", current); err != nil {
+			return err
+		}
+		note("DEPLOY_METHOD %q renamed to %q", method, current)
+	}
+	return nil
+}
+
+// migratePagesToken moves the pre-goflare CF_PAGES_TOKEN to the per-project
+// key goflare reads, then clears the old key. Without CF_PROJECT it waits,
+// since pullers of other methods must still start.
+func migratePagesToken(s Store, note func(string, ...any)) error {
+	token, err := s.Get("CF_PAGES_TOKEN")
+	if err != nil || token == "" {
+		return nil
+	}
+	project, err := s.Get("CF_PROJECT")
+	if err != nil || project == "" {
+		return fmt.Errorf("%w: CF_PAGES_TOKEN is set but CF_PROJECT is not; set CF_PROJECT and restart", errMigrationPending)
+	}
+	key := "goflare/" + project
+	if existing, err := s.Get(key); err != nil || existing == "" {
+		if err := s.Set(key, token); err != nil {
+			return err
+		}
+		note("CF_PAGES_TOKEN moved to %s", key)
+	} else {
+		note("CF_PAGES_TOKEN dropped, %s is already set", key)
+	}
+	return s.Set("CF_PAGES_TOKEN", "")
+}
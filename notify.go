@@ -0,0 +1,309 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Notification kinds, as listed in a notifier's events filter.
+const (
+	NotifyStarted    = "started"
+	NotifySucceeded  = "succeeded"
+	NotifyFailed     = "failed"
+	NotifyRolledBack = "rolled_back"
+)
+
+// defaultNotifyTemplate is used when a notifier has no template.
+const defaultNotifyTemplate = `[{{.App}}] deploy {{.Kind}}{{if .Tag}} {{.Tag}}{{end}}{{if .Error}}: {{.Error}}{{end}}`
+
+// NotifierConfig configures one notification target in deploy.yaml.
+//
+//	notifications:
+//	  - name: ops
+//	    type: slack              # webhook | slack | discord | email
+//	    url: https://hooks.slack.com/services/...
+//	    events: [failed, rolled_back]
+//	    apps: [api]
+type NotifierConfig struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	URL      string   `yaml:"url"`      // webhook, slack, discord
+	Events   []string `yaml:"events"`   // empty: all events
+	Apps     []string `yaml:"apps"`     // empty: all apps
+	Template string   `yaml:"template"` // text/template over Notification
+
+	// email
+	SMTPHost    string   `yaml:"smtp_host"` // host:port
+	SMTPUser    string   `yaml:"smtp_user"`
+	PasswordKey string   `yaml:"password_key"` // Store key holding the SMTP password
+	From        string   `yaml:"from"`
+	To          []string `yaml:"to"`
+}
+
+// Notification is a deploy lifecycle event sent to notifiers.
+type Notification struct {
+	Kind     string    `json:"kind"`
+	App      string    `json:"app"`
+	Tag      string    `json:"tag,omitempty"`
+	Repo     string    `json:"repo,omitempty"`
+	DeployID string    `json:"deploy_id"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Notifier delivers a rendered message for a notification.
+type Notifier interface {
+	Notify(n Notification, message string) error
+}
+
+// Notifications routes notifications to the configured notifiers.
+// Delivery is asynchronous; a nil *Notifications drops everything.
+type Notifications struct {
+	routes []notifyRoute
+	Log    *slog.Logger
+	wg     sync.WaitGroup
+}
+
+type notifyRoute struct {
+	name     string
+	notifier Notifier
+	events   map[string]bool
+	apps     map[string]bool
+	tmpl     *template.Template
+}
+
+// NewNotifications builds notifiers from configuration. Secrets referenced by
+// password_key are read from store.
+func NewNotifications(cfgs []NotifierConfig, store Store) (*Notifications, error) {
+	n := &Notifications{}
+	for i, c := range cfgs {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("notifications[%d]", i)
+		}
+		notifier, err := newNotifier(c, store)
+		if err != nil {
+			return nil, fmt.Errorf("deploy: notifier %s: %w", name, err)
+		}
+		text := c.Template
+		if text == "" {
+			text = defaultNotifyTemplate
+		}
+		tmpl := template.Must(template.New(name).Parse(text)) // checked by newNotifier
+		n.Add(name, notifier, c.Events, c.Apps, tmpl)
+	}
+	return n, nil
+}
+
+// Add registers a notifier. Empty events or apps match everything; a nil
+// template uses the default message format.
+func (n *Notifications) Add(name string, notifier Notifier, events, apps []string, tmpl *template.Template) {
+	if tmpl == nil {
+		tmpl = template.Must(template.New(name).Parse(defaultNotifyTemplate))
+	}
+	n.routes = append(n.routes, notifyRoute{
+		name:     name,
+		notifier: notifier,
+		events:   toSet(events),
+		apps:     toSet(apps),
+		tmpl:     tmpl,
+	})
+}
+
+// check reports missing or invalid settings for the notifier type.
+func (c NotifierConfig) check() error {
+	switch strings.ToLower(c.Type) {
+	case "webhook", "", "slack", "discord":
+		if c.URL == "" {
+			return fmt.Errorf("url is required")
+		}
+	case "email":
+		if c.SMTPHost == "" || c.From == "" || len(c.To) == 0 {
+			return fmt.Errorf("smtp_host, from and to are required")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", c.Type)
+	}
+	if c.Template != "" {
+		if _, err := template.New("").Parse(c.Template); err != nil {
+			return fmt.Errorf("template: %w", err)
+		}
+	}
+	return nil
+}
+
+func newNotifier(c NotifierConfig, store Store) (Notifier, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(c.Type) {
+	case "slack", "discord":
+		return &ChatNotifier{URL: c.URL, Discord: strings.EqualFold(c.Type, "discord")}, nil
+	case "email":
+		e := &EmailNotifier{Addr: c.SMTPHost, From: c.From, To: c.To, User: c.SMTPUser}
+		if c.PasswordKey != "" {
+			pw, err := store.Get(c.PasswordKey)
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", c.PasswordKey, err)
+			}
+			e.Password = pw
+		}
+		return e, nil
+	}
+	return &WebhookNotifier{URL: c.URL}, nil
+}
+
+// OnEvent implements Observer, turning lifecycle events into notifications.
+func (n *Notifications) OnEvent(e Event) {
+	var kind string
+	switch e.Type {
+	case EventDeployStarted:
+		kind = NotifyStarted
+	case EventCompleted:
+		kind = NotifySucceeded
+	case EventFailed:
+		kind = NotifyFailed
+	case EventRolledBack:
+		kind = NotifyRolledBack
+	default:
+		return
+	}
+	ev := Notification{Kind: kind, App: e.App, Tag: e.Tag, Repo: e.Repo, DeployID: e.DeployID, Time: e.Time}
+	if e.Err != nil {
+		ev.Error = e.Err.Error()
+	}
+	n.Emit(ev)
+}
+
+// Emit sends ev to every matching notifier in the background.
+func (n *Notifications) Emit(ev Notification) {
+	if n == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	for _, r := range n.routes {
+		if len(r.events) > 0 && !r.events[ev.Kind] {
+			continue
+		}
+		if len(r.apps) > 0 && !r.apps[ev.App] {
+			continue
+		}
+		var msg bytes.Buffer
+		if err := r.tmpl.Execute(&msg, ev); err != nil {
+			n.logger().Warn("notification template failed", "notifier", r.name, "error", err)
+			continue
+		}
+		n.wg.Add(1)
+		go func(r notifyRoute, text string) {
+			defer n.wg.Done()
+			if err := r.notifier.Notify(ev, text); err != nil {
+				n.logger().Warn("notification failed", "notifier", r.name, "kind", ev.Kind, "error", err)
+			}
+		}(r, msg.String())
+	}
+}
+
+// Wait blocks until every notification emitted so far has been delivered.
+func (n *Notifications) Wait() {
+	if n != nil {
+		n.wg.Wait()
+	}
+}
+
+func (n *Notifications) logger() *slog.Logger {
+	if n.Log == nil {
+		return discardLogger
+	}
+	return n.Log
+}
+
+func toSet(list []string) map[string]bool {
+	if len(list) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(list))
+	for _, v := range list {
+		set[v] = true
+	}
+	return set
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+func postJSON(url string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// WebhookNotifier POSTs the notification as JSON with the rendered message.
+type WebhookNotifier struct {
+	URL string
+}
+
+func (w *WebhookNotifier) Notify(n Notification, message string) error {
+	return postJSON(w.URL, struct {
+		Notification
+		Message string `json:"message"`
+	}{n, message})
+}
+
+// ChatNotifier posts to Slack- or Discord-compatible incoming webhooks.
+type ChatNotifier struct {
+	URL     string
+	Discord bool
+}
+
+func (c *ChatNotifier) Notify(n Notification, message string) error {
+	if c.Discord {
+		return postJSON(c.URL, map[string]string{"content": message})
+	}
+	return postJSON(c.URL, map[string]string{"text": message})
+}
+
+// EmailNotifier sends the message over SMTP; the first line is the subject.
+type EmailNotifier struct {
+	Addr     string // host:port
+	From     string
+	To       []string
+	User     string
+	Password string
+}
+
+func (e *EmailNotifier) Notify(n Notification, message string) error {
+	subject, _, _ := strings.Cut(message, "\n")
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(message, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if e.User != "" {
+		host, _, _ := strings.Cut(e.Addr, ":")
+		auth = smtp.PlainAuth("", e.User, e.Password, host)
+	}
+	return smtp.SendMail(e.Addr, auth, e.From, e.To, msg.Bytes())
+}
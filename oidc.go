@@ -0,0 +1,305 @@
+package deploy
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultOIDCIssuer issues the OIDC tokens of GitHub Actions jobs.
+const DefaultOIDCIssuer = "https://token.actions.githubusercontent.com"
+
+// OIDCConfig lets /update accept OIDC tokens instead of an HMAC signature:
+//
+//	updater:
+//	  oidc:
+//	    audience: deploy.example.com
+//	apps:
+//	  - name: api
+//	    oidc:
+//	      repository: acme/api
+//	      ref: refs/tags/v*
+//
+// A workflow requests a token with `permissions: id-token: write` and sends
+// it as "Authorization: Bearer <token>". Only apps declaring oidc claims
+// accept tokens.
+type OIDCConfig struct {
+	Issuer   string        `yaml:"issuer"`         // default: DefaultOIDCIssuer
+	Audience string        `yaml:"audience"`       // required "aud" claim
+	JWKSURL  string        `yaml:"jwks_url"`       // default: <issuer>/.well-known/jwks
+	CacheTTL time.Duration `yaml:"jwks_cache_ttl"` // default: 1h
+}
+
+// OIDCClaims are the token claims an app requires. Each is a path.Match
+// pattern (refs/tags/v*); empty claims are not checked, except repository,
+// which is required.
+type OIDCClaims struct {
+	Repository  string `yaml:"repository"`  // owner/name
+	Ref         string `yaml:"ref"`         // refs/heads/main, refs/tags/v1.2.0
+	Environment string `yaml:"environment"` // GitHub deployment environment of the job
+	Workflow    string `yaml:"workflow"`    // workflow name
+}
+
+// OIDCToken holds the verified claims of a token.
+type OIDCToken struct {
+	Subject     string `json:"sub"`
+	Repository  string `json:"repository"`
+	Ref         string `json:"ref"`
+	Environment string `json:"environment"`
+	Workflow    string `json:"workflow"`
+}
+
+// allows reports why t does not carry the claims c requires, or nil.
+func (c *OIDCClaims) allows(t *OIDCToken) error {
+	for _, cl := range []struct{ name, pattern, value string }{
+		{"repository", c.Repository, t.Repository},
+		{"ref", c.Ref, t.Ref},
+		{"environment", c.Environment, t.Environment},
+		{"workflow", c.Workflow, t.Workflow},
+	} {
+		if cl.pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(cl.pattern, cl.value); !ok {
+			return fmt.Errorf("claim %s %q does not match %q", cl.name, cl.value, cl.pattern)
+		}
+	}
+	return nil
+}
+
+// oidcLeeway tolerates clock skew when checking exp and nbf.
+const oidcLeeway = time.Minute
+
+// oidcRefetchInterval limits JWKS downloads, whether triggered by unknown
+// key IDs or retrying an unreachable issuer.
+const oidcRefetchInterval = time.Minute
+
+// OIDCVerifier verifies RS256 tokens against the issuer's JWKS, which it
+// caches for CacheTTL and refreshes early when a token names an unknown key.
+type OIDCVerifier struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PublicKey
+	fetched  time.Time  // last successful download
+	tried    time.Time  // last download started
+	inflight *jwksFetch // running download, shared by all callers
+}
+
+// jwksFetch is a JWKS download; keys and err are set when done is closed.
+type jwksFetch struct {
+	done chan struct{}
+	keys map[string]*rsa.PublicKey
+	err  error
+}
+
+// NewOIDCVerifier returns a verifier for cfg with defaults applied.
+func NewOIDCVerifier(cfg OIDCConfig) *OIDCVerifier {
+	if cfg.Issuer == "" {
+		cfg.Issuer = DefaultOIDCIssuer
+	}
+	if cfg.JWKSURL == "" {
+		cfg.JWKSURL = strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/jwks"
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = time.Hour
+	}
+	return &OIDCVerifier{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Verify checks the signature, issuer, audience and lifetime of token and
+// returns its claims.
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (*OIDCToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("oidc: header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("oidc: unsupported algorithm %q", header.Alg)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, errors.New("oidc: invalid signature")
+	}
+
+	var claims struct {
+		OIDCToken
+		Issuer    string   `json:"iss"`
+		Audience  audience `json:"aud"`
+		Expiry    int64    `json:"exp"`
+		NotBefore int64    `json:"nbf"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("oidc: claims: %w", err)
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != v.cfg.Issuer:
+		return nil, fmt.Errorf("oidc: issuer %q, want %q", claims.Issuer, v.cfg.Issuer)
+	case !claims.Audience.contains(v.cfg.Audience):
+		return nil, fmt.Errorf("oidc: audience %v, want %q", []string(claims.Audience), v.cfg.Audience)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(oidcLeeway)):
+		return nil, errors.New("oidc: token expired")
+	case claims.NotBefore != 0 && now.Add(oidcLeeway).Before(time.Unix(claims.NotBefore, 0)):
+		return nil, errors.New("oidc: token not yet valid")
+	}
+	return &claims.OIDCToken, nil
+}
+
+// key returns the public key kid, downloading the JWKS when the cache is
+// stale or, at most once per oidcRefetchInterval, when kid is unknown. The
+// download runs without holding v.mu, so it only delays the callers that
+// need it.
+func (v *OIDCVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	k, ok := v.keys[kid]
+	if ok && time.Since(v.fetched) < v.cfg.CacheTTL {
+		v.mu.Unlock()
+		return k, nil
+	}
+	f := v.refresh()
+	v.mu.Unlock()
+
+	if f != nil {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if f.err == nil {
+			if k, ok := f.keys[kid]; ok {
+				return k, nil
+			}
+			return nil, fmt.Errorf("oidc: unknown key %q", kid)
+		}
+	}
+	if ok {
+		return k, nil // keep serving the stale key while the issuer is unreachable
+	}
+	if f != nil {
+		return nil, f.err
+	}
+	return nil, fmt.Errorf("oidc: unknown key %q", kid)
+}
+
+// refresh returns the running JWKS download, starting one unless the last
+// started less than oidcRefetchInterval ago; nil means none. v.mu must be
+// held.
+func (v *OIDCVerifier) refresh() *jwksFetch {
+	if v.inflight != nil {
+		return v.inflight
+	}
+	if !v.tried.IsZero() && time.Since(v.tried) < min(oidcRefetchInterval, v.cfg.CacheTTL) {
+		return nil
+	}
+	f := &jwksFetch{done: make(chan struct{})}
+	v.inflight, v.tried = f, time.Now()
+	go func() {
+		// Not tied to the request that started it: others may be waiting.
+		keys, err := v.fetch(context.Background())
+		v.mu.Lock()
+		if err == nil {
+			v.keys, v.fetched = keys, time.Now()
+		}
+		v.inflight = nil
+		v.mu.Unlock()
+		f.keys, f.err = keys, err
+		close(f.done)
+	}()
+	return f
+}
+
+func (v *OIDCVerifier) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.cfg.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: fetch jwks: %s", resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("oidc: parse jwks: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// audience is the "aud" claim: a string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+func (a audience) contains(want string) bool {
+	for _, s := range a {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,349 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is how often the poll method checks for releases.
+const DefaultPollInterval = 5 * time.Minute
+
+// PollConfig configures the poll deploy method, which lets servers without
+// inbound ports deploy by polling the releases API of each app's repo:
+//
+//	updater:
+//	  poll:
+//	    interval: 5m
+//	apps:
+//	  - name: api
+//	    repo: acme/api
+type PollConfig struct {
+	Interval time.Duration `yaml:"interval"` // default: DefaultPollInterval
+	// APIURL is the base of the forge API, which also lists the artifacts
+	// of GitHub workflow runs. Defaults by source: https://api.github.com
+	// and https://gitlab.com/api/v4; Gitea and Forgejo need their
+	// <host>/api/v1.
+	APIURL string `yaml:"api_url"`
+}
+
+// defaultAPIURLs are the release APIs polled when PollConfig.APIURL is empty.
+var defaultAPIURLs = map[Source]string{
+	SourceGitHub: "https://api.github.com",
+	SourceGitLab: "https://gitlab.com/api/v4",
+}
+
+// apiBase returns the API base URL of src: updater.poll.api_url when set,
+// otherwise the public default, if src has one.
+func apiBase(cfg *Config, src Source) string {
+	if cfg.Updater.Poll != nil && cfg.Updater.Poll.APIURL != "" {
+		return strings.TrimSuffix(cfg.Updater.Poll.APIURL, "/")
+	}
+	return defaultAPIURLs[src]
+}
+
+// validRepo reports whether repo is a plain owner/name on src, or
+// group/.../name on GitLab, safe to put in an API path.
+func validRepo(src Source, repo string) bool {
+	parts := strings.Split(repo, "/")
+	if len(parts) < 2 || (len(parts) > 2 && src != SourceGitLab) {
+		return false
+	}
+	for _, s := range parts {
+		if s == "" || s == "." || s == ".." || strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// pollPageSize is the number of releases fetched per poll, newest first.
+const pollPageSize = 10
+
+// Poller deploys new releases through the pipeline of Handler. Each poll
+// lists the releases of every app with a repo and deploys the newest one
+// that passes the app's allow rules and version policy, has a matching asset
+// and is newer than the deployed version. Drafts are skipped, and so are
+// prereleases unless the app's channel or policy accepts them; a release that
+// failed to deploy is not retried until a newer one is published.
+//
+// Lists are fetched with If-None-Match, so unchanged repos do not count
+// against GitHub's rate limit, and an API reporting its limit exhausted is
+// not queried again before the limit resets.
+type Poller struct {
+	Handler  *Handler
+	Interval time.Duration // default: updater.poll.interval
+	Client   *http.Client  // default: http.DefaultClient
+
+	mu      sync.Mutex
+	repos   map[string]*pollState // by app name
+	limited map[string]time.Time  // API base URL → end of its rate limit
+}
+
+// pollState is what the poller remembers about an app's releases.
+type pollState struct {
+	etag     string
+	releases []polledRelease
+	failed   string // tag whose deploy failed
+}
+
+// polledRelease is a release as listed by any of the supported APIs.
+type polledRelease struct {
+	Tag        string
+	Prerelease bool
+	Assets     []forgeAsset
+}
+
+// Run polls until ctx is done.
+func (p *Poller) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+	for {
+		p.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Poller) interval() time.Duration {
+	if p.Interval > 0 {
+		return p.Interval
+	}
+	if poll := p.Handler.config().Updater.Poll; poll != nil && poll.Interval > 0 {
+		return poll.Interval
+	}
+	return DefaultPollInterval
+}
+
+// Poll checks every app with a repo once, deploying new releases in turn.
+// It returns the IDs of the deploys it ran.
+func (p *Poller) Poll(ctx context.Context) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.repos == nil {
+		p.repos = make(map[string]*pollState)
+		p.limited = make(map[string]time.Time)
+	}
+
+	h := p.Handler
+	cfg := h.config()
+	var deployed []string
+	for i := range cfg.Apps {
+		app := &cfg.Apps[i]
+		if app.Repo == "" || ctx.Err() != nil {
+			continue
+		}
+		log := h.logger().With("app", app.Name, "repo", app.Repo)
+		st := p.repos[app.Name]
+		if st == nil {
+			st = &pollState{}
+			p.repos[app.Name] = st
+		}
+		if err := p.refresh(ctx, cfg, app, st); err != nil {
+			log.Warn("poll releases", "error", err)
+			continue
+		}
+		installed := h.installedVersion(app)
+		req, ok := pollCandidate(app, installed, st)
+		if !ok {
+			continue
+		}
+		id := NewDeployID()
+		log.Info("new release found", "tag", req.Tag, "deployed", installed)
+		switch outcome := h.deploy(discardResponse{}, cfg, app, req, nil, id); outcome {
+		case OutcomeSuccess, OutcomeBusy: // busy apps are retried next poll
+		default:
+			st.failed = req.Tag
+			log.Warn("release not retried until a newer one is published", "tag", req.Tag, "outcome", outcome)
+		}
+		deployed = append(deployed, id)
+	}
+	return deployed
+}
+
+// pollCandidate returns the request deploying the newest acceptable release
+// of app newer than the installed version.
+func pollCandidate(app *AppConfig, installed string, st *pollState) (UpdateRequest, bool) {
+	for _, rel := range st.releases {
+		if rel.Tag == installed || rel.Tag == st.failed {
+			break
+		}
+		if rel.Prerelease && !app.acceptsPrereleases() {
+			continue
+		}
+		for _, a := range rel.Assets {
+			if !assetMatches(app, a.Name) {
+				continue
+			}
+			req := UpdateRequest{Repo: app.Repo, Tag: rel.Tag, Executable: app.Executable, DownloadURL: a.URL, Digest: a.Digest}
+			if authorize(app, &req, nil) == nil && checkVersion(app, installed, &req) == nil {
+				return req, true
+			}
+			break
+		}
+	}
+	return UpdateRequest{}, false
+}
+
+// refresh fetches the releases of app into st unless its API is rate
+// limited. An unchanged list (304) keeps the releases already known.
+func (p *Poller) refresh(ctx context.Context, cfg *Config, app *AppConfig, st *pollState) error {
+	src := app.source()
+	base := apiBase(cfg, src)
+	if base == "" {
+		return fmt.Errorf("updater.poll.api_url is required for source %s", src)
+	}
+	if !validRepo(src, app.Repo) {
+		return fmt.Errorf("invalid repo %q", app.Repo)
+	}
+	if until, ok := p.limited[base]; ok && time.Now().Before(until) {
+		return nil
+	}
+
+	owner, name, _ := strings.Cut(app.Repo, "/")
+	repo := url.PathEscape(owner) + "/" + url.PathEscape(name)
+	var endpoint string
+	switch src {
+	case SourceGitLab:
+		endpoint = fmt.Sprintf("%s/projects/%s/releases?per_page=%d", base, url.PathEscape(app.Repo), pollPageSize)
+	case SourceGitea:
+		endpoint = fmt.Sprintf("%s/repos/%s/releases?limit=%d", base, repo, pollPageSize)
+	default:
+		endpoint = fmt.Sprintf("%s/repos/%s/releases?per_page=%d", base, repo, pollPageSize)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	token, _ := p.Handler.Keys.Get(src.TokenKey())
+	p.Handler.Redact.Add(token)
+	setSourceAuth(req.Header, src, token)
+	if src == SourceGitHub {
+		req.Header.Set("Accept", "application/vnd.github+json")
+	}
+	if st.etag != "" {
+		req.Header.Set("If-None-Match", st.etag)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if until, ok := rateLimitEnd(resp); ok {
+		p.limited[base] = until
+		p.Handler.logger().Warn("release API rate limit reached", "api", base, "until", until.Format(time.RFC3339))
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	releases, err := decodeReleases(src, resp.Body)
+	if err != nil {
+		return fmt.Errorf("decode releases: %w", err)
+	}
+	st.releases, st.etag = releases, resp.Header.Get("ETag")
+	return nil
+}
+
+// rateLimitEnd reports when the rate limit of an API ends, if resp says it
+// is exhausted. GitHub sends X-RateLimit-*, GitLab RateLimit-*; both may
+// send Retry-After instead.
+func rateLimitEnd(resp *http.Response) (time.Time, bool) {
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Now().Add(time.Duration(secs) * time.Second), true
+		}
+	}
+	for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+		if resp.Header.Get(prefix+"Remaining") != "0" {
+			continue
+		}
+		if reset, err := strconv.ParseInt(resp.Header.Get(prefix+"Reset"), 10, 64); err == nil {
+			return time.Unix(reset, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// decodeReleases parses a release list of src into polledReleases, drafts
+// and upcoming releases left out.
+func decodeReleases(src Source, r io.Reader) ([]polledRelease, error) {
+	var list []struct {
+		TagName         string          `json:"tag_name"`
+		Draft           bool            `json:"draft"`
+		Prerelease      bool            `json:"prerelease"`
+		UpcomingRelease bool            `json:"upcoming_release"`
+		Assets          json.RawMessage `json:"assets"`
+	}
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	var out []polledRelease
+	for _, rel := range list {
+		if rel.Draft || rel.UpcomingRelease {
+			continue
+		}
+		pr := polledRelease{Tag: rel.TagName, Prerelease: rel.Prerelease}
+		var err error
+		switch src {
+		case SourceGitLab:
+			var assets struct {
+				Links []struct {
+					Name           string `json:"name"`
+					URL            string `json:"url"`
+					DirectAssetURL string `json:"direct_asset_url"`
+				} `json:"links"`
+			}
+			err = json.Unmarshal(rel.Assets, &assets)
+			for _, l := range assets.Links {
+				a := forgeAsset{Name: l.Name, URL: l.DirectAssetURL}
+				if a.URL == "" {
+					a.URL = l.URL
+				}
+				pr.Assets = append(pr.Assets, a)
+			}
+		default:
+			var assets []struct {
+				Name               string `json:"name"`
+				URL                string `json:"url"`
+				BrowserDownloadURL string `json:"browser_download_url"`
+				Digest             string `json:"digest"`
+			}
+			err = json.Unmarshal(rel.Assets, &assets)
+			for _, a := range assets {
+				// GitHub serves private assets from the API URL only.
+				u := a.URL
+				if src == SourceGitea {
+					u = a.BrowserDownloadURL
+				}
+				pr.Assets = append(pr.Assets, forgeAsset{Name: a.Name, URL: u, Digest: a.Digest})
+			}
+		}
+		if err != nil && len(rel.Assets) > 0 {
+			return nil, fmt.Errorf("release %s: %w", rel.TagName, err)
+		}
+		out = append(out, pr)
+	}
+	return out, nil
+}
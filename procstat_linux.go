@@ -0,0 +1,127 @@
+//go:build linux
+
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, which is 100 on every Linux platform Go supports.
+const clockTicks = 100
+
+// inspectProcess finds the live process running the executable at exePath
+// and reads its usage. It returns nil, nil when no such process is running.
+func inspectProcess(exePath string) (*ProcInfo, error) {
+	pid, err := findPID(exePath)
+	if err != nil || pid == 0 {
+		return nil, err
+	}
+	return readProcInfo(pid)
+}
+
+// findPID returns the first process whose /proc/<pid>/exe is exePath, a
+// binary replaced by a deploy included. Zombies and processes whose exe
+// link is not readable are skipped: another binary of the same name must
+// not be reported as the app.
+func findPID(exePath string) (int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	want, err := filepath.Abs(exePath)
+	if err != nil {
+		return 0, err
+	}
+	if resolved, err := filepath.EvalSymlinks(want); err == nil {
+		want = resolved
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		dir := filepath.Join("/proc", e.Name())
+		target, err := os.Readlink(filepath.Join(dir, "exe"))
+		if err != nil || strings.TrimSuffix(target, " (deleted)") != want {
+			continue
+		}
+		if procState(dir) == "Z" {
+			continue
+		}
+		return pid, nil
+	}
+	return 0, nil
+}
+
+// procState returns the state letter of the process at dir, /proc/<pid>.
+func procState(dir string) string {
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return ""
+	}
+	if end := bytes.LastIndexByte(stat, ')'); end >= 0 {
+		if f := strings.Fields(string(stat[end+1:])); len(f) > 0 {
+			return f[0]
+		}
+	}
+	return ""
+}
+
+func readProcInfo(pid int) (*ProcInfo, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// The command name may contain spaces; fields resume after the last ')'.
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return nil, fmt.Errorf("deploy: malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	// fields[0] is field 3 (state); utime=14, stime=15, starttime=22.
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("deploy: short /proc/%d/stat", pid)
+	}
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	start, _ := strconv.ParseUint(fields[19], 10, 64)
+
+	info := &ProcInfo{
+		PID: pid,
+		CPU: time.Duration(utime+stime) * time.Second / clockTicks,
+	}
+
+	if data, err := os.ReadFile("/proc/uptime"); err == nil {
+		if f := strings.Fields(string(data)); len(f) > 0 {
+			if up, err := strconv.ParseFloat(f[0], 64); err == nil {
+				secs := up - float64(start)/clockTicks
+				if secs > 0 {
+					info.Uptime = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	if f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid)); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := sc.Text()
+			if rest, ok := strings.CutPrefix(line, "VmRSS:"); ok {
+				if kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(rest), " kB"), 10, 64); err == nil {
+					info.RSS = kb * 1024
+				}
+				break
+			}
+		}
+		f.Close()
+	}
+
+	return info, nil
+}
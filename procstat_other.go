@@ -0,0 +1,6 @@
+//go:build !linux
+
+package deploy
+
+// inspectProcess is only implemented on Linux, where /proc is available.
+func inspectProcess(exePath string) (*ProcInfo, error) { return nil, nil }
@@ -0,0 +1,166 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// PromotionStep is one hop of an artifact between environments: the deploy
+// that ran it in Env before it was promoted onwards.
+//
+// Steps are supplied by whoever sends the update and recorded as given: the
+// target does not check them against the pullers of the environments they
+// name, so any holder of its secret can write them. Treat them as metadata;
+// the digest is what the target verifies.
+type PromotionStep struct {
+	Env      string `json:"env"`
+	DeployID string `json:"deploy_id"`
+	Version  string `json:"version,omitempty"`
+}
+
+// PromoteResult describes a promotion.
+type PromoteResult struct {
+	App       string
+	Version   string
+	Digest    string
+	DeployID  string          // deploy in the target environment; empty when Unchanged
+	Chain     []PromotionStep // environments the artifact ran in, oldest first
+	Unchanged bool            // the target already ran the artifact
+}
+
+// Promote deploys the artifact app is running in environment from to the
+// puller of environment to. The target downloads the same URL and refuses
+// the deploy unless the binary has the digest the source recorded, so what
+// was tested is exactly what ships. The promotion chain is recorded in the
+// target's deploy history, unverified (see PromotionStep).
+//
+// Each environment's puller is reached at its host (DEPLOY_SERVER_HOST) and
+// authenticated with its DEPLOY_HMAC_SECRET, read through NewEnvStore. The
+// deploy carries the repo the artifact was built from, for allow.repos, and
+// is signed with the app's own secret in the target environment when its
+// allow.secret requires it.
+func Promote(ctx context.Context, cfg *Config, store Store, app, from, to string) (*PromoteResult, error) {
+	if from == to {
+		return nil, fmt.Errorf("deploy: promote: source and target are both %q", from)
+	}
+	srcURL, srcSecret, err := cfg.pullerEndpoint(from, store)
+	if err != nil {
+		return nil, err
+	}
+	dstURL, dstSecret, err := cfg.pullerEndpoint(to, store)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := statusOf(ctx, srcURL, srcSecret, app, from)
+	if err != nil {
+		return nil, err
+	}
+	if src.Digest == "" || src.DownloadURL == "" {
+		return nil, fmt.Errorf("deploy: promote: %s has no recorded artifact for %q; deploy it there first", from, app)
+	}
+	dst, err := statusOf(ctx, dstURL, dstSecret, app, to)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &PromoteResult{
+		App:     app,
+		Version: src.Version,
+		Digest:  src.Digest,
+		Chain:   append(append([]PromotionStep(nil), src.PromotedFrom...), PromotionStep{Env: from, DeployID: src.DeployID, Version: src.Version}),
+	}
+	if dst.Digest == src.Digest {
+		res.Unchanged = true
+		return res, nil
+	}
+
+	if dst.AppSecret {
+		s := cfg.envStore(to, store)
+		if dstSecret, err = s.Get(AppSecretKey(app)); err != nil || dstSecret == "" {
+			return nil, fmt.Errorf("deploy: promote: %s in %s requires its own secret; no %s for environment %s", app, to, AppSecretKey(app), to)
+		}
+	}
+	body, err := json.Marshal(UpdateRequest{
+		Repo:         src.Repo,
+		Tag:          src.Version,
+		Executable:   dst.Executable,
+		DownloadURL:  src.DownloadURL,
+		Digest:       src.Digest,
+		PromotedFrom: res.Chain,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dstURL+"/update", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature", NewHMACValidator(dstSecret).Sign(body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("deploy: promote %s to %s: %w", app, to, err)
+	}
+	defer resp.Body.Close()
+	res.DeployID = resp.Header.Get("X-Deploy-Id")
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return res, fmt.Errorf("deploy: promote %s to %s: %s: %s", app, to, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return res, nil
+}
+
+// statusOf returns the status of app reported by the puller of env.
+func statusOf(ctx context.Context, baseURL, secret, app, env string) (*AppStatus, error) {
+	report, err := FetchStatus(ctx, baseURL, secret)
+	if err != nil {
+		return nil, err
+	}
+	for i := range report.Apps {
+		if report.Apps[i].Name == app {
+			return &report.Apps[i], nil
+		}
+	}
+	return nil, fmt.Errorf("deploy: promote: app %q is not configured in %s", app, env)
+}
+
+// pullerEndpoint returns the base URL and HMAC secret of the puller serving
+// environment name.
+func (c *Config) pullerEndpoint(name string, store Store) (baseURL, secret string, err error) {
+	if _, ok := c.Environments[name]; !ok {
+		return "", "", fmt.Errorf("deploy: unknown environment %q (configured: %s)", name, strings.Join(sortedKeys(c.Environments), ", "))
+	}
+	s := c.envStore(name, store)
+	host, err := s.Get("DEPLOY_SERVER_HOST")
+	if err != nil || host == "" {
+		return "", "", fmt.Errorf("deploy: no DEPLOY_SERVER_HOST for environment %s", name)
+	}
+	if secret, err = s.Get("DEPLOY_HMAC_SECRET"); err != nil || secret == "" {
+		return "", "", fmt.Errorf("deploy: no DEPLOY_HMAC_SECRET for environment %s", name)
+	}
+	return PullerURL(host), secret, nil
+}
+
+// envStore returns store as seen by environment name.
+func (c *Config) envStore(name string, store Store) *EnvStore {
+	env := c.Environments[name]
+	s := NewEnvStore(store, name)
+	s.Overrides = env.storeOverrides()
+	return s
+}
+
+// PullerURL returns the base URL of the puller at host (DEPLOY_SERVER_HOST),
+// which may omit the scheme.
+func PullerURL(host string) string {
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return strings.TrimSuffix(host, "/")
+}
@@ -1,8 +1,9 @@
 // Package deploy implements a lightweight continuous deployment agent.
-// It supports three modes:
+// It supports four modes:
 //   - cloudflare: uploads build artifacts to Cloudflare Pages via API.
 //   - webhook: an HTTP daemon on the server that GitHub Actions triggers via POST.
 //   - ssh: generates a shell script that GitHub Actions runs via SSH on the server.
+//   - poll: a daemon on the server that polls release APIs, for hosts without inbound ports.
 //
 // Usage:
 //
@@ -12,9 +13,18 @@ package deploy
 
 import (
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// Version is the puller build version reported by /status.
+// Set it at build time with -ldflags "-X github.com/tinywasm/deploy.Version=v1.2.3".
+var Version = "dev"
+
 // Puller is the main orchestrator for all deployment modes.
 // Store must be injected — kvdb.KVStore satisfies the Store interface directly.
 type Puller struct {
@@ -23,46 +33,115 @@ type Puller struct {
 	Downloader Downloader
 	Checker    HealthChecker
 	ConfigPath string
+	Env        string   // environment from deploy.yaml's environments; empty: none
 	Provider   Provider // replaces: Goflare *goflare.Goflare
+	envStore   *EnvStore
 	log        func(...any)
+	slog       *slog.Logger
+	logFile    io.Closer
+	secrets    *Redactor
+	events     eventBus
 }
 
 // SetLog injects a logger (called by tinywasm/app after registration with TUI).
 func (p *Puller) SetLog(f func(...any)) { p.log = f }
 
-func (p *Puller) logger(msgs ...any) {
+// Subscribe registers an observer for deploy lifecycle events, letting
+// embedding programs drive progress UIs or side effects without parsing
+// log lines. Call the returned function to unsubscribe.
+func (p *Puller) Subscribe(o Observer) (unsubscribe func()) {
+	return p.events.subscribe(o)
+}
+
+// store returns Store, scoped with EnvStore when an environment is selected.
+func (p *Puller) store() Store {
+	if p.Env == "" {
+		return p.Store
+	}
+	if p.envStore == nil {
+		p.envStore = NewEnvStore(p.Store, p.Env)
+	}
+	return p.envStore
+}
+
+// emit forwards to the SetLog hook, which may be replaced at any time.
+func (p *Puller) emit(msgs ...any) {
 	if p.log != nil {
 		p.log(msgs...)
 	}
 }
 
+// Secrets returns the Redactor applied to all puller output. When Store is
+// a SecureStore it shares that store's knowledge of sensitive values.
+func (p *Puller) Secrets() *Redactor {
+	if p.secrets == nil {
+		if ss, ok := p.Store.(*SecureStore); ok {
+			p.secrets = ss.Redactor()
+		} else {
+			p.secrets = NewRedactor()
+		}
+	}
+	return p.secrets
+}
+
+// Logger returns the structured logger. Until Run has read deploy.yaml it
+// only forwards info-level records to the SetLog hook.
+func (p *Puller) Logger() *slog.Logger {
+	if p.slog == nil {
+		p.slog = slog.New(p.Secrets().Handler(newHookHandler(p.emit, slog.LevelInfo)))
+	}
+	return p.slog
+}
+
+// configureLogging replaces the logger with one honouring the updater's
+// log_level, log_file and log_format settings.
+func (p *Puller) configureLogging(u ConfigUpdater) error {
+	l, closer, err := NewLogger(u, p.emit)
+	if err != nil {
+		return err
+	}
+	if p.logFile != nil {
+		_ = p.logFile.Close()
+	}
+	p.slog, p.logFile = slog.New(p.Secrets().Handler(l.Handler())), closer
+	return nil
+}
+
+func (p *Puller) logger(msgs ...any) {
+	p.Logger().Info(strings.TrimSuffix(fmt.Sprintln(msgs...), "\n"))
+}
+
 // Name returns the TUI tab label for the orchestrator.
 func (p *Puller) Name() string { return "DEPLOY/DAEMON" }
 
 // IsConfigured returns true if a deploy method has been stored.
 func (p *Puller) IsConfigured() bool {
-	method, err := p.Store.Get("DEPLOY_METHOD")
+	method, err := p.store().Get("DEPLOY_METHOD")
 	return err == nil && method != ""
 }
 
 // Run executes the deployment based on the stored DEPLOY_METHOD.
 // Called from cmd/deploy/main.go for standalone daemon mode.
+// Returned errors are redacted so they can be printed safely.
 func (p *Puller) Run() error {
-	method, err := p.Store.Get("DEPLOY_METHOD")
+	return p.Secrets().Error(p.run())
+}
+
+func (p *Puller) run() error {
+	if err := MigrateStore(p.Store, p.Logger()); err != nil {
+		return err
+	}
+	if err := p.selectEnv(); err != nil {
+		return err
+	}
+	method, err := p.store().Get("DEPLOY_METHOD")
 	if err != nil || method == "" {
 		return fmt.Errorf("deploy: not configured — run wizard first (DEPLOY_METHOD not set)")
 	}
 
-	// DEPRECATED: Internal naming consistency. Strategy name is now "cloudflarePages" or "cloudflareWorker".
-	// The store might still hold "cloudflare" or "edgeworker" from older app configs.
-	// Kept for seamless backward compatibility; do not remove unless breaking changes are allowed.
-	if method == "cloudflare" || method == "edgeworker" {
-		method = "cloudflarePages"
-	}
-
 	// Use provider for supported deployment methods
 	if p.Provider != nil && p.Provider.Supports(method) {
-		return p.Provider.Deploy(p.Store)
+		return p.Provider.Deploy(p.store())
 	}
 
 	strat, err := GetPusher(method)
@@ -70,7 +149,10 @@ func (p *Puller) Run() error {
 		return err
 	}
 
-	cfg, err := Load(p.ConfigPath)
+	if _, err := MigrateConfig(p.ConfigPath, p.Logger()); err != nil {
+		return err
+	}
+	cfg, err := LoadWithStore(p.ConfigPath, p.store())
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("deploy: load config: %w", err)
 	}
@@ -78,11 +160,110 @@ func (p *Puller) Run() error {
 		if err := CreateDefaultConfig(p.ConfigPath); err != nil {
 			return fmt.Errorf("deploy: create default config: %w", err)
 		}
-		cfg, err = Load(p.ConfigPath)
+		cfg, err = LoadWithStore(p.ConfigPath, p.store())
 		if err != nil {
 			return fmt.Errorf("deploy: reload config: %w", err)
 		}
 	}
+	if cfg, err = cfg.ForEnvironment(p.Env); err != nil {
+		return err
+	}
+
+	p.Secrets().Add(cfg.SecretValues()...)
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("deploy: invalid config: %w", err)
+	}
+
+	if err := p.configureLogging(cfg.Updater); err != nil {
+		return fmt.Errorf("deploy: configure logging: %w", err)
+	}
+	defer p.logFile.Close()
 
 	return strat.Run(cfg, p)
 }
+
+// selectEnv reads the selected environment from deploy.yaml and lets it fix
+// Store settings such as the deploy method. It is a no-op without Env.
+func (p *Puller) selectEnv() error {
+	if p.Env == "" {
+		return nil
+	}
+	if _, err := MigrateConfig(p.ConfigPath, p.Logger()); err != nil {
+		return err
+	}
+	cfg, err := LoadWithStore(p.ConfigPath, p.store())
+	if err != nil {
+		return fmt.Errorf("deploy: environment %s: load config: %w", p.Env, err)
+	}
+	env, ok := cfg.Environments[p.Env]
+	if !ok {
+		_, err := cfg.ForEnvironment(p.Env)
+		return err
+	}
+	p.store().(*EnvStore).Overrides = env.storeOverrides()
+	p.Logger().Info("environment selected", "env", p.Env)
+	return nil
+}
+
+// newHandler builds the deploy pipeline of the methods running on the
+// server: metrics, notifications, events and config reloads. reload, when
+// set, sees each reloaded config before it becomes active. Call stop when
+// done.
+func (p *Puller) newHandler(cfg *Config, reload func(next *Config)) (h *Handler, stop func(), err error) {
+	notify, err := NewNotifications(cfg.Notifications, p.store())
+	if err != nil {
+		return nil, nil, err
+	}
+	notify.Log = p.Logger()
+
+	handler := &Handler{
+		Config:     cfg,
+		ConfigPath: p.ConfigPath,
+		Downloader: p.Downloader,
+		Process:    p.Process,
+		Checker:    p.Checker,
+		Keys:       p.store(),
+		Log:        p.Logger(),
+		Redact:     p.Secrets(),
+		Metrics:    NewMetrics(),
+		StartedAt:  time.Now(),
+	}
+	for _, app := range cfg.Apps {
+		handler.Metrics.SetVersion(app.Name, app.Version)
+	}
+	handler.Subscribe(ObserverFunc(p.events.emit))
+
+	// Notifiers are rebuilt on reload, so subscribe through a swappable pointer.
+	var notifiers atomic.Pointer[Notifications]
+	notifiers.Store(notify)
+	handler.Subscribe(ObserverFunc(func(e Event) { notifiers.Load().OnEvent(e) }))
+
+	if p.ConfigPath == "" {
+		return handler, func() {}, nil
+	}
+	watcher := &ConfigWatcher{
+		Path:  p.ConfigPath,
+		Log:   p.Logger(),
+		Store: p.store(),
+		Env:   p.Env,
+		Apply: func(next *Config) error {
+			n, err := NewNotifications(next.Notifications, p.store())
+			if err != nil {
+				return err
+			}
+			n.Log = p.Logger()
+			p.Secrets().Add(next.SecretValues()...)
+			if reload != nil {
+				reload(next)
+			}
+			notifiers.Store(n)
+			handler.SetConfig(next)
+			for _, app := range next.Apps {
+				handler.Metrics.SetVersion(app.Name, app.Version)
+			}
+			return nil
+		},
+	}
+	watcher.Prime()
+	return handler, watcher.Start(), nil
+}
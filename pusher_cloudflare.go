@@ -12,7 +12,7 @@ type CloudflarePagesPusher struct{}
 func (s *CloudflarePagesPusher) Name() string { return "cloudflarePages" }
 func (s *CloudflarePagesPusher) Run(cfg *Config, p *Puller) error {
 	if p.Provider != nil {
-		return p.Provider.Deploy(p.Store)
+		return p.Provider.Deploy(p.store())
 	}
 	return nil
 }
@@ -23,7 +23,7 @@ type CloudflareWorkerPusher struct{}
 func (s *CloudflareWorkerPusher) Name() string { return "cloudflareWorker" }
 func (s *CloudflareWorkerPusher) Run(cfg *Config, p *Puller) error {
 	if p.Provider != nil {
-		return p.Provider.Deploy(p.Store)
+		return p.Provider.Deploy(p.store())
 	}
 	return nil
 }
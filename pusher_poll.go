@@ -0,0 +1,63 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+
+	twctx "github.com/tinywasm/context"
+	"github.com/tinywasm/wizard"
+)
+
+func init() {
+	RegisterPusher(&PollPusher{})
+}
+
+// PollPusher deploys by polling release APIs instead of waiting for a
+// webhook, for servers without inbound ports. See Poller.
+type PollPusher struct{}
+
+func (s *PollPusher) Name() string { return "poll" }
+
+func (s *PollPusher) Run(cfg *Config, p *Puller) error {
+	polled := 0
+	for _, app := range cfg.Apps {
+		if app.Repo != "" {
+			polled++
+		}
+	}
+	if polled == 0 {
+		return fmt.Errorf("deploy: no app has a repo to poll")
+	}
+
+	handler, stop, err := p.newHandler(cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	poller := &Poller{Handler: handler}
+	p.logger("Polling releases of", polled, "apps every", poller.interval())
+	return poller.Run(context.Background())
+}
+
+func (s *PollPusher) WizardSteps(store Store, log func(...any)) []*wizard.Step {
+	return []*wizard.Step{
+		{
+			LabelText: "GitHub PAT (ghp_... or github_pat_... — needs repo read access)",
+			OnInputFn: func(input string, ctx *twctx.Context) (bool, error) {
+				if input == "" {
+					return false, fmt.Errorf("PAT cannot be empty")
+				}
+				ctx.Set(ctxPAT, input)
+				if err := store.Set("DEPLOY_GITHUB_PAT", input); err != nil {
+					return false, err
+				}
+				if err := CreateDefaultConfig("deploy.yaml"); err != nil {
+					return false, err
+				}
+				log("Set the repo (owner/name) of each app in deploy.yaml; its releases are polled every " + DefaultPollInterval.String() + ".")
+				return true, nil
+			},
+		},
+	}
+}
@@ -16,10 +16,11 @@ type SSHPusher struct{}
 func (s *SSHPusher) Name() string { return "ssh" }
 
 func (s *SSHPusher) Run(cfg *Config, p *Puller) error {
-	pat, err := p.Store.Get("DEPLOY_GITHUB_PAT")
+	pat, err := p.store().Get("DEPLOY_GITHUB_PAT")
 	if err != nil || pat == "" {
 		return fmt.Errorf("deploy: GitHub PAT not configured")
 	}
+	p.Secrets().Add(pat) // the scripts embed it
 	for _, app := range cfg.Apps {
 		script := SSHScript(app, "", pat)
 		p.logger("# SSH script for", app.Name+":\n"+script)
@@ -0,0 +1,177 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedMask replaces every known secret in redacted output.
+const RedactedMask = "[REDACTED]"
+
+// minSecretLen avoids masking trivially short values that would make
+// logs unreadable (and are not real secrets anyway).
+const minSecretLen = 4
+
+// Redactor masks known secret values in strings, errors and log records.
+// SecureStore registers every sensitive value it reads or writes, so a
+// Redactor obtained from it covers PATs, HMAC secrets and goflare/* tokens.
+type Redactor struct {
+	mu       sync.RWMutex
+	secrets  map[string]struct{}
+	replacer *strings.Replacer
+}
+
+// NewRedactor returns an empty Redactor.
+func NewRedactor() *Redactor {
+	return &Redactor{secrets: make(map[string]struct{})}
+}
+
+// Add registers secret values to be masked. Empty and very short values are ignored.
+func (r *Redactor) Add(secrets ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changed := false
+	for _, s := range secrets {
+		if len(s) < minSecretLen {
+			continue
+		}
+		if _, ok := r.secrets[s]; !ok {
+			r.secrets[s] = struct{}{}
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	// Longest first so a secret containing another is masked whole.
+	list := make([]string, 0, len(r.secrets))
+	for s := range r.secrets {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+	pairs := make([]string, 0, 2*len(list))
+	for _, s := range list {
+		pairs = append(pairs, s, RedactedMask)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact returns s with all registered secrets masked.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.RLock()
+	rep := r.replacer
+	r.mu.RUnlock()
+	if rep == nil {
+		return s
+	}
+	return rep.Replace(s)
+}
+
+// Error returns err with its message redacted, or err unchanged when it
+// contains no secret.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if red := r.Redact(msg); red != msg {
+		return errors.New(red)
+	}
+	return err
+}
+
+// Func wraps an untyped log hook so that every argument is redacted.
+func (r *Redactor) Func(f func(...any)) func(...any) {
+	if f == nil {
+		return nil
+	}
+	return func(msgs ...any) {
+		out := make([]any, len(msgs))
+		for i, m := range msgs {
+			out[i] = r.redactAny(m)
+		}
+		f(out...)
+	}
+}
+
+func (r *Redactor) redactAny(v any) any {
+	switch t := v.(type) {
+	case string:
+		return r.Redact(t)
+	case error:
+		return r.Error(t)
+	case interface{ String() string }:
+		s := t.String()
+		if red := r.Redact(s); red != s {
+			return red
+		}
+	}
+	return v
+}
+
+// Handler wraps h so that messages and attribute values are redacted
+// before they reach any sink.
+func (r *Redactor) Handler(h slog.Handler) slog.Handler {
+	return &redactHandler{next: h, r: r}
+}
+
+type redactHandler struct {
+	next slog.Handler
+	r    *Redactor
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, h.r.Redact(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.r.redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	red := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		red[i] = h.r.redactAttr(a)
+	}
+	return &redactHandler{next: h.next.WithAttrs(red), r: h.r}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{next: h.next.WithGroup(name), r: h.r}
+}
+
+func (r *Redactor) redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.Redact(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		red := make([]any, len(group))
+		for i, g := range group {
+			red[i] = r.redactAttr(g)
+		}
+		return slog.Group(a.Key, red...)
+	case slog.KindAny:
+		s := v.String()
+		if red := r.Redact(s); red != s {
+			return slog.String(a.Key, red)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
@@ -0,0 +1,507 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A relay lets pullers without a public port receive deploy requests. CI
+// sends the requests it would send a puller to the relay instead, under
+// /r/<puller id>/, e.g. POST https://relay.example.com/r/web1/update. The
+// puller keeps an outbound long poll open on /r/<id>/poll, handles each
+// request it receives and streams the response back through
+// /r/<id>/result/<job>, which the relay copies to CI as it arrives.
+//
+// The relay only forwards: signatures are checked by the puller, so a
+// compromised relay cannot forge deploys. For the same reason pullers
+// refuse OIDC tokens on relayed requests: a token does not cover the body,
+// and the relay could keep it and send bodies of its own. Each puller authenticates to
+// the relay with its own bearer token, kept under RelayTokenKey(id) on both
+// sides, so it can only poll and answer for its own ID.
+const (
+	relayPollWait      = 25 * time.Second // a poll without a request answers 204 after this
+	relayStaleAfter    = 2 * relayPollWait
+	relayForgetAfter   = 24 * time.Hour   // a puller not seen for this long is dropped
+	relayResultTimeout = 15 * time.Minute // longer than a deploy waiting for a busy app
+	relayMaxBody       = 25 << 20         // GitHub's webhook payload limit
+)
+
+// RelayStatusHeader carries the puller's response status on /result.
+const RelayStatusHeader = "X-Relay-Status"
+
+// relayResultHeaders are the response headers forwarded back to CI.
+var relayResultHeaders = []string{"Content-Type", "X-Deploy-Id", IdempotentReplayHeader}
+
+// RelayTokenKey returns the Store key of the relay token of puller id.
+func RelayTokenKey(id string) string {
+	return "DEPLOY_RELAY_TOKEN/" + id
+}
+
+// relayedKey marks the context of a request received through a relay.
+type relayedKey struct{}
+
+// relayed reports whether r was received through a relay.
+func relayed(r *http.Request) bool {
+	v, _ := r.Context().Value(relayedKey{}).(bool)
+	return v
+}
+
+// RelayConfig connects a webhook puller to a relay instead of listening on
+// updater.port.
+type RelayConfig struct {
+	URL string `yaml:"url"` // relay base URL, e.g. https://relay.example.com
+	ID  string `yaml:"id"`  // this puller's ID, the <id> of /r/<id>/
+}
+
+// relayRequest is a request forwarded to a puller.
+type relayRequest struct {
+	ID     string      `json:"id"`
+	Method string      `json:"method"`
+	Path   string      `json:"path"` // puller path and query, e.g. /update
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// relayJob is a forwarded request waiting for its response.
+type relayJob struct {
+	relayRequest
+	w    http.ResponseWriter
+	done chan struct{}
+
+	mu       sync.Mutex
+	answered bool
+}
+
+// claim reserves the CI response of j for one writer: the puller's result
+// or the relay's timeout.
+func (j *relayJob) claim() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.answered {
+		return false
+	}
+	j.answered = true
+	return true
+}
+
+// expired reports whether j was answered without the puller.
+func (j *relayJob) expired() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.answered
+}
+
+type relayPuller struct {
+	jobs     chan *relayJob
+	pending  map[string]*relayJob
+	lastSeen time.Time
+}
+
+// Relay is the relay server, an http.Handler for /r/.
+type Relay struct {
+	Keys Store        // holds the token of each puller under RelayTokenKey; required
+	Log  *slog.Logger // optional; nil discards
+
+	mu      sync.Mutex
+	pullers map[string]*relayPuller
+}
+
+func (rl *Relay) logger() *slog.Logger {
+	if rl.Log == nil {
+		return discardLogger
+	}
+	return rl.Log
+}
+
+// puller returns the state of puller id, creating it. Only authenticated
+// pollers create pullers; creating one also drops those not seen for
+// relayForgetAfter.
+func (rl *Relay) puller(id string) *relayPuller {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.pullers == nil {
+		rl.pullers = make(map[string]*relayPuller)
+	}
+	p := rl.pullers[id]
+	if p == nil {
+		for other, q := range rl.pullers {
+			if time.Since(q.lastSeen) > relayForgetAfter && len(q.pending) == 0 {
+				delete(rl.pullers, other)
+			}
+		}
+		p = &relayPuller{jobs: make(chan *relayJob, 16), pending: make(map[string]*relayJob)}
+		rl.pullers[id] = p
+	}
+	return p
+}
+
+// lookup returns the state of puller id, or nil if it never polled.
+func (rl *Relay) lookup(id string) *relayPuller {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.pullers[id]
+}
+
+func (rl *Relay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/r/"), "/")
+	if !strings.HasPrefix(r.URL.Path, "/r/") || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case rest == "poll":
+		if rl.authorized(w, r, id) {
+			rl.handlePoll(w, r, id)
+		}
+	case strings.HasPrefix(rest, "result/"):
+		if rl.authorized(w, r, id) {
+			rl.handleResult(w, r, id, strings.TrimPrefix(rest, "result/"))
+		}
+	default:
+		rl.forward(w, r, id, "/"+rest)
+	}
+}
+
+// authorized checks the bearer token of a request for puller id against
+// the token issued to id.
+func (rl *Relay) authorized(w http.ResponseWriter, r *http.Request, id string) bool {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	var want string
+	if rl.Keys != nil {
+		want, _ = rl.Keys.Get(RelayTokenKey(id))
+	}
+	if want == "" || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		rl.logger().Warn("rejected relay client", "puller", id, "remote", r.RemoteAddr)
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// forward queues a CI request for puller id and waits for its response.
+func (rl *Relay) forward(w http.ResponseWriter, r *http.Request, id, path string) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, relayMaxBody+1))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > relayMaxBody {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	job := &relayJob{
+		relayRequest: relayRequest{ID: NewDeployID(), Method: r.Method, Path: path, Header: r.Header.Clone(), Body: body},
+		w:            w,
+		done:         make(chan struct{}),
+	}
+
+	p := rl.lookup(id)
+	if p == nil {
+		http.Error(w, "Puller not connected", http.StatusServiceUnavailable)
+		return
+	}
+	rl.mu.Lock()
+	connected := time.Since(p.lastSeen) < relayStaleAfter
+	if connected {
+		p.pending[job.ID] = job
+	}
+	rl.mu.Unlock()
+	if !connected {
+		http.Error(w, "Puller not connected", http.StatusServiceUnavailable)
+		return
+	}
+	defer func() {
+		rl.mu.Lock()
+		delete(p.pending, job.ID)
+		rl.mu.Unlock()
+	}()
+	log := rl.logger().With("puller", id, "job", job.ID, "path", path)
+	log.Debug("forwarding request")
+
+	timeout := time.NewTimer(relayResultTimeout)
+	defer timeout.Stop()
+	select {
+	case p.jobs <- job:
+		select {
+		case <-job.done:
+			return
+		case <-timeout.C:
+		case <-r.Context().Done():
+		}
+	case <-timeout.C:
+	case <-r.Context().Done():
+	}
+	if job.claim() {
+		log.Warn("no response from puller")
+		http.Error(w, "Puller did not respond", http.StatusGatewayTimeout)
+		return
+	}
+	<-job.done // the result is being written
+}
+
+// handlePoll hands the next request for puller id to it, or answers 204
+// after relayPollWait.
+func (rl *Relay) handlePoll(w http.ResponseWriter, r *http.Request, id string) {
+	p := rl.puller(id)
+	touch := func() {
+		rl.mu.Lock()
+		p.lastSeen = time.Now()
+		rl.mu.Unlock()
+	}
+	touch()
+	defer touch()
+
+	wait := time.NewTimer(relayPollWait)
+	defer wait.Stop()
+	for {
+		select {
+		case job := <-p.jobs:
+			if job.expired() {
+				continue // timed out while queued
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(job.relayRequest)
+			return
+		case <-wait.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleResult streams the response of job to the waiting CI request.
+func (rl *Relay) handleResult(w http.ResponseWriter, r *http.Request, id, jobID string) {
+	var job *relayJob
+	if p := rl.lookup(id); p != nil {
+		rl.mu.Lock()
+		job = p.pending[jobID]
+		rl.mu.Unlock()
+	}
+	if job == nil || !job.claim() {
+		http.Error(w, "Unknown or expired job", http.StatusGone)
+		return
+	}
+	defer close(job.done)
+
+	status, err := strconv.Atoi(r.Header.Get(RelayStatusHeader))
+	if err != nil {
+		status = http.StatusBadGateway
+	}
+	for _, name := range relayResultHeaders {
+		if v := r.Header.Get(name); v != "" {
+			job.w.Header().Set(name, v)
+		}
+	}
+	job.w.WriteHeader(status)
+	flusher, _ := job.w.(http.Flusher)
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Body.Read(buf)
+		if n > 0 {
+			job.w.Write(buf[:n])
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RelayClient connects a puller to a relay: it long-polls for requests,
+// serves them with Handler and streams the responses back.
+type RelayClient struct {
+	URL     string       // relay base URL
+	ID      string       // puller ID on the relay
+	Token   string       // the RelayTokenKey(ID) token
+	Handler http.Handler // serves forwarded requests, e.g. the puller's mux
+	Client  *http.Client // default: http.DefaultClient
+	Log     *slog.Logger // optional; nil discards
+}
+
+func (c *RelayClient) logger() *slog.Logger {
+	if c.Log == nil {
+		return discardLogger
+	}
+	return c.Log
+}
+
+func (c *RelayClient) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
+
+// endpoint returns the URL of path under the puller's prefix on the relay.
+func (c *RelayClient) endpoint(path string) string {
+	return strings.TrimSuffix(c.URL, "/") + "/r/" + c.ID + path
+}
+
+// Run polls the relay until ctx is done, backing off while it is
+// unreachable. Requests are served concurrently.
+func (c *RelayClient) Run(ctx context.Context) error {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		req, err := c.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			c.logger().Warn("relay poll failed", "relay", c.URL, "error", err, "retry_in", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+			}
+			backoff = min(2*backoff, time.Minute)
+			continue
+		}
+		backoff = time.Second
+		if req != nil {
+			go c.serve(ctx, req)
+		}
+	}
+	return ctx.Err()
+}
+
+// poll waits for the next forwarded request; nil means none arrived.
+func (c *RelayClient) poll(ctx context.Context) (*relayRequest, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint("/poll"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	var rr relayRequest
+	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
+		return nil, fmt.Errorf("decode request: %w", err)
+	}
+	return &rr, nil
+}
+
+// serve handles a forwarded request and sends its response to the relay.
+func (c *RelayClient) serve(ctx context.Context, rr *relayRequest) {
+	if !strings.HasPrefix(rr.Path, "/") {
+		rr.Path = "/" + rr.Path
+	}
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, relayedKey{}, true), rr.Method, rr.Path, bytes.NewReader(rr.Body))
+	if err != nil {
+		c.logger().Warn("invalid relayed request", "job", rr.ID, "error", err)
+		return
+	}
+	req.Header = rr.Header
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.RemoteAddr = "relay"
+	req.RequestURI = rr.Path
+
+	w := &relayResponse{c: c, ctx: ctx, job: rr.ID, header: http.Header{}}
+	c.Handler.ServeHTTP(w, req)
+	if err := w.finish(); err != nil {
+		c.logger().Warn("relay result failed", "job", rr.ID, "error", err)
+	}
+}
+
+// relayResponse streams a response to the relay's /result endpoint. The
+// upload starts at WriteHeader, so status and headers go first and the
+// body follows as it is written.
+type relayResponse struct {
+	c      *RelayClient
+	ctx    context.Context
+	job    string
+	header http.Header
+
+	pw   *io.PipeWriter
+	sent chan error
+}
+
+func (w *relayResponse) Header() http.Header { return w.header }
+
+func (w *relayResponse) WriteHeader(status int) {
+	if w.pw != nil {
+		return
+	}
+	pr, pw := io.Pipe()
+	w.pw, w.sent = pw, make(chan error, 1)
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.c.endpoint("/result/"+w.job), pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		w.sent <- err
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+w.c.Token)
+	req.Header.Set(RelayStatusHeader, strconv.Itoa(status))
+	for _, name := range relayResultHeaders {
+		if v := w.header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	go func() {
+		resp, err := w.c.client().Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		pr.CloseWithError(err)
+		w.sent <- err
+	}()
+}
+
+func (w *relayResponse) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pw.Write(b)
+}
+
+// Flush is a no-op: writes reach the relay unbuffered.
+func (w *relayResponse) Flush() {}
+
+// finish ends the response body and waits for the relay to accept it.
+func (w *relayResponse) finish() error {
+	w.WriteHeader(http.StatusOK)
+	w.pw.Close()
+	return <-w.sent
+}
+
+// serveRelay serves handler through the relay of cfg until it fails.
+func (p *Puller) serveRelay(r *RelayConfig, handler http.Handler) error {
+	token, err := p.store().Get(RelayTokenKey(r.ID))
+	if err != nil || token == "" {
+		return fmt.Errorf("deploy: %s not configured", RelayTokenKey(r.ID))
+	}
+	p.Secrets().Add(token)
+	p.logger("Connecting to relay", r.URL, "as", r.ID)
+	c := &RelayClient{URL: r.URL, ID: r.ID, Token: token, Handler: handler, Log: p.Logger()}
+	return c.Run(context.Background())
+}
@@ -0,0 +1,170 @@
+package deploy
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ConfigWatcher reloads deploy.yaml when its content or one of its apps.d
+// fragments changes, or the process receives SIGHUP. Each candidate is loaded
+// and validated before Apply sees it; invalid edits are logged and the
+// previous configuration stays active. When only fragments changed, just
+// those files are read again and the other apps are kept as they are.
+type ConfigWatcher struct {
+	Path     string
+	Interval time.Duration       // poll interval (default: 2s)
+	Apply    func(*Config) error // returning an error rejects the new config
+	Store    Store               // resolves secret:// references, may be nil
+	Env      string              // environment applied to each reload, see Config.ForEnvironment
+	Log      *slog.Logger
+
+	mu      sync.Mutex
+	sums    map[string][sha256.Size]byte // last seen content of every file
+	current *Config                      // last applied configuration
+}
+
+// Prime records the current files as already applied, so Run only reacts to
+// later edits.
+func (w *ConfigWatcher) Prime() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if cfg, err := LoadWithStore(w.Path, w.Store); err == nil {
+		w.current = cfg
+	}
+	w.sums, _ = w.scan()
+}
+
+// Run polls the file and listens for SIGHUP until ctx is cancelled.
+func (w *ConfigWatcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-hup:
+			w.logger().Info("SIGHUP received, reloading config", "path", w.Path)
+			w.reload(true)
+		case <-ticker.C:
+			w.reload(false)
+		}
+	}
+}
+
+// Start runs the watcher in the background; call stop to end it.
+func (w *ConfigWatcher) Start() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go w.Run(ctx)
+	return cancel
+}
+
+// Reload loads, validates and applies the file regardless of whether it changed.
+func (w *ConfigWatcher) Reload() error {
+	return w.reload(true)
+}
+
+func (w *ConfigWatcher) reload(force bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	sums, err := w.scan()
+	if err != nil {
+		if force {
+			w.logger().Error("config reload failed", "path", w.Path, "error", err)
+		}
+		return err
+	}
+	var changed []string
+	for _, file := range sortedKeys(sums) {
+		if old, ok := w.sums[file]; !ok || old != sums[file] {
+			changed = append(changed, file)
+		}
+	}
+	for _, file := range sortedKeys(w.sums) {
+		if _, ok := sums[file]; !ok {
+			changed = append(changed, file)
+		}
+	}
+	if !force && len(changed) == 0 {
+		return nil
+	}
+	// Remember the content even if it is rejected, so a broken edit is
+	// reported once instead of on every poll.
+	w.sums = sums
+
+	var cfg *Config
+	if force || w.current == nil || slices.Contains(changed, w.Path) {
+		cfg, err = LoadWithStore(w.Path, w.Store)
+	} else {
+		w.logger().Info("config fragments changed", "files", changed)
+		set := make(map[string]bool, len(changed))
+		for _, f := range changed {
+			set[f] = true
+		}
+		cfg, err = w.current.withFragments(w.Path, set, w.Store)
+	}
+	applied := cfg
+	if err == nil {
+		applied, err = cfg.ForEnvironment(w.Env)
+	}
+	if err == nil {
+		err = applied.Validate()
+	}
+	if err == nil && w.Apply != nil {
+		err = w.Apply(applied)
+	}
+	if err != nil {
+		w.logger().Error("config reload rejected, keeping previous config", "path", w.Path, "error", err)
+		return fmt.Errorf("deploy: config reload rejected: %w", err)
+	}
+	w.current = cfg
+	w.logger().Info("config reloaded", "path", w.Path, "apps", len(applied.Apps))
+	return nil
+}
+
+// scan hashes deploy.yaml and every fragment in its apps.d directory.
+func (w *ConfigWatcher) scan() (map[string][sha256.Size]byte, error) {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		return nil, err
+	}
+	sums := map[string][sha256.Size]byte{w.Path: sha256.Sum256(data)}
+	cfg := w.current
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	files, err := fragmentFiles(cfg.appsDir(w.Path))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if data, err := os.ReadFile(file); err == nil {
+			sums[file] = sha256.Sum256(data)
+		}
+	}
+	return sums, nil
+}
+
+func (w *ConfigWatcher) logger() *slog.Logger {
+	if w.Log == nil {
+		return discardLogger
+	}
+	return w.Log
+}
@@ -0,0 +1,138 @@
+package deploy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LoadHMACKeys returns the key set kept in the Store: the DEPLOY_HMAC_KEYS
+// list, plus DEPLOY_HMAC_SECRET when the list lacks it (set by the wizard,
+// or before the first rotation). Nil means no secret is configured.
+func LoadHMACKeys(store Store) ([]HMACKey, error) {
+	var keys []HMACKey
+	if data, err := store.Get("DEPLOY_HMAC_KEYS"); err == nil && data != "" {
+		if err := json.Unmarshal([]byte(data), &keys); err != nil {
+			return nil, fmt.Errorf("deploy: parse DEPLOY_HMAC_KEYS: %w", err)
+		}
+	}
+	current, err := store.Get("DEPLOY_HMAC_SECRET")
+	if err != nil || current == "" {
+		return keys, nil
+	}
+	for _, k := range keys {
+		if k.Secret == current {
+			return keys, nil
+		}
+	}
+	return append(keys, HMACKey{ID: DefaultHMACKeyID, Secret: current}), nil
+}
+
+// RotateHMACKey adds a new random secret to the key set in store and makes
+// it DEPLOY_HMAC_SECRET. Keys without an expiry keep validating for overlap,
+// long enough to update CI and every puller; expired keys are dropped.
+func RotateHMACKey(store Store, overlap time.Duration) (HMACKey, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return HMACKey{}, err
+	}
+	now := time.Now().UTC()
+	next := HMACKey{ID: now.Format("20060102-150405"), Secret: hex.EncodeToString(buf), Created: now}
+	if err := addHMACKey(store, next, overlap); err != nil {
+		return HMACKey{}, err
+	}
+	return next, nil
+}
+
+// ImportHMACKey adds a secret generated elsewhere, e.g. by the secret
+// manager of the CI, as key id and makes it DEPLOY_HMAC_SECRET, keeping
+// the previous keys valid for overlap like RotateHMACKey.
+func ImportHMACKey(store Store, id, secret string, overlap time.Duration) (HMACKey, error) {
+	if id == "" || strings.ContainsAny(id, " \t\r\n") {
+		return HMACKey{}, fmt.Errorf("deploy: invalid key id %q", id)
+	}
+	if secret == "" {
+		return HMACKey{}, fmt.Errorf("deploy: key %s has an empty secret", id)
+	}
+	next := HMACKey{ID: id, Secret: secret, Created: time.Now().UTC()}
+	if err := addHMACKey(store, next, overlap); err != nil {
+		return HMACKey{}, err
+	}
+	return next, nil
+}
+
+// addHMACKey appends next to the key set in store, starting the overlap
+// window of older keys, and makes it DEPLOY_HMAC_SECRET.
+func addHMACKey(store Store, next HMACKey, overlap time.Duration) error {
+	keys, err := LoadHMACKeys(store)
+	if err != nil {
+		return err
+	}
+	kept := keys[:0]
+	for _, k := range keys {
+		if k.ID == next.ID {
+			return fmt.Errorf("deploy: key %s already exists", k.ID)
+		}
+		if k.Secret == next.Secret {
+			return fmt.Errorf("deploy: key %s already uses this secret", k.ID)
+		}
+		if k.NotAfter.IsZero() {
+			k.NotAfter = next.Created.Add(overlap)
+		}
+		if k.active(next.Created) {
+			kept = append(kept, k)
+		}
+	}
+	data, err := json.Marshal(append(kept, next))
+	if err != nil {
+		return err
+	}
+	// The list goes first: until DEPLOY_HMAC_SECRET changes, the new key is
+	// merely accepted.
+	if err := store.Set("DEPLOY_HMAC_KEYS", string(data)); err != nil {
+		return err
+	}
+	return store.Set("DEPLOY_HMAC_SECRET", next.Secret)
+}
+
+// HMACRotation is what a puller reports after a rotation to key KeyID.
+type HMACRotation struct {
+	KeyID  string
+	Loaded bool            // the puller accepts KeyID
+	InUse  []HMACKeyStatus // older keys that validated requests since KeyID was created
+}
+
+// CheckHMACRotation asks the puller at baseURL whether it accepts the key
+// keyID of keys and which older keys still sign its requests. The status
+// request is signed with the newest key the puller accepts.
+func CheckHMACRotation(ctx context.Context, baseURL string, keys []HMACKey, keyID string) (*HMACRotation, error) {
+	var since time.Time
+	for _, k := range keys {
+		if k.ID == keyID {
+			since = k.Created
+		}
+	}
+	var report *StatusReport
+	var err error
+	for i := len(keys) - 1; i >= 0 && report == nil; i-- {
+		report, err = FetchStatus(ctx, baseURL, keys[i].Secret)
+	}
+	if report == nil {
+		return nil, err
+	}
+
+	r := &HMACRotation{KeyID: keyID}
+	for _, k := range report.Puller.HMACKeys {
+		switch {
+		case k.ID == keyID:
+			r.Loaded = true
+		case k.LastUsed != nil && !k.LastUsed.Before(since):
+			r.InUse = append(r.InUse, k)
+		}
+	}
+	return r, nil
+}
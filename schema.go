@@ -0,0 +1,216 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// SchemaID is the $id of the generated deploy.yaml JSON Schema.
+const SchemaID = "https://github.com/tinywasm/deploy/deploy.schema.json"
+
+// fieldDoc documents one configuration key for the JSON Schema.
+type fieldDoc struct {
+	desc string
+	def  any      // default value, nil if none
+	enum []string // allowed values, nil if free-form
+}
+
+// configDocs documents every yaml key of the configuration types, keyed by
+// "TypeName.yaml_key". Schema fails for fields missing here and for entries
+// that no longer match a field, so the two cannot drift apart.
+var configDocs = map[string]fieldDoc{
+	"Config.version":       {desc: "Schema version of this file; older files are migrated on start (`puller migrate`).", def: ConfigVersion},
+	"Config.updater":       {desc: "Settings of the puller agent itself."},
+	"Config.apps":          {desc: "Apps managed by the puller, one per executable."},
+	"Config.notifications": {desc: "Targets notified about deploy lifecycle events."},
+	"Config.apps_dir":      {desc: "Directory of per-app fragment files (*.yaml, one app or a list each), relative to this file; validate them against #/$defs/AppConfig.", def: DefaultAppsDir},
+
+	"Config.environments": {desc: "Named deploy targets overriding method, host and apps; select one with --env."},
+
+	"Environment.method": {desc: "Deploy method for this environment, overriding DEPLOY_METHOD in the Store."},
+	"Environment.host":   {desc: "Server host for this environment, overriding DEPLOY_SERVER_HOST in the Store."},
+	"Environment.apps":   {desc: "App overrides matched by name; only the keys given change. Unknown names add an app."},
+
+	"ConfigUpdater.port":            {desc: "Port of the webhook server.", def: 8080},
+	"ConfigUpdater.log_level":       {desc: "Minimum log level.", def: "info", enum: []string{"debug", "info", "warn", "error"}},
+	"ConfigUpdater.log_file":        {desc: "File receiving logs in addition to the SetLog hook; rotated by size."},
+	"ConfigUpdater.log_format":      {desc: "Format of log_file entries.", def: "text", enum: []string{"text", "json"}},
+	"ConfigUpdater.log_max_size":    {desc: "Size in MB at which log_file is rotated.", def: 10},
+	"ConfigUpdater.log_max_backups": {desc: "Number of rotated log files kept.", def: 3},
+	"ConfigUpdater.temp_dir":        {desc: "Directory for downloads in progress (default: <os temp>/deploy)."},
+	"ConfigUpdater.retry":           {desc: "Retry policy for failed downloads."},
+	"ConfigUpdater.signing_keys":    {desc: "Ed25519 public keys (base64) by key ID, verifying X-Signature: ed25519=... with X-Signature-Key-Id."},
+	"ConfigUpdater.poll":            {desc: "Settings of the poll deploy method, which deploys new releases of each app's repo without inbound webhooks."},
+	"PollConfig.interval":           {desc: "How often release APIs are polled.", def: "5m"},
+	"PollConfig.api_url":            {desc: "Base URL of the forge API, for release polls and GitHub workflow run artifacts (default: https://api.github.com, or https://gitlab.com/api/v4 for source gitlab); required for gitea."},
+	"ConfigUpdater.relay":           {desc: "Receive webhook requests through a relay over an outbound connection instead of listening on port; authenticates with DEPLOY_RELAY_TOKEN/<id>."},
+	"RelayConfig.url":               {desc: "Base URL of the relay (`puller relay`)."},
+	"RelayConfig.id":                {desc: "ID of this puller on the relay; CI posts to <url>/r/<id>/update."},
+	"ConfigUpdater.oidc":            {desc: "Accept GitHub Actions OIDC tokens on /update, authorized by each app's oidc claims."},
+	"OIDCConfig.issuer":             {desc: "Token issuer.", def: DefaultOIDCIssuer},
+	"OIDCConfig.audience":           {desc: "Required aud claim; set the same audience when requesting the token."},
+	"OIDCConfig.jwks_url":           {desc: "Signing keys of the issuer (default: <issuer>/.well-known/jwks)."},
+	"OIDCConfig.jwks_cache_ttl":     {desc: "How long fetched signing keys are cached.", def: "1h"},
+
+	"RetryConfig.max_attempts": {desc: "Download attempts before giving up."},
+	"RetryConfig.delay":        {desc: "Wait between download attempts."},
+
+	"AppConfig.name":                {desc: "Name used in logs, metrics and notifications."},
+	"AppConfig.version":             {desc: "Deprecated since version 2: the deployed version is recorded in the state file."},
+	"AppConfig.executable":          {desc: "File name of the executable; matched against the webhook payload."},
+	"AppConfig.path":                {desc: "Directory the executable is installed and run from."},
+	"AppConfig.port":                {desc: "Port the app listens on."},
+	"AppConfig.health_endpoint":     {desc: "URL polled after a deploy, or a path such as /health on localhost:<port>; must answer with status \"ok\"."},
+	"AppConfig.health_timeout":      {desc: "Timeout of each health check request."},
+	"AppConfig.startup_delay":       {desc: "Wait after starting the app before the first health check."},
+	"AppConfig.busy_retry_interval": {desc: "Wait between checks while the app reports it cannot restart.", def: "10s"},
+	"AppConfig.busy_timeout":        {desc: "Give up a deploy when the app stays busy this long.", def: "5m"},
+	"AppConfig.rollback":            {desc: "What to do with previous versions."},
+	"AppConfig.allow":               {desc: "Restricts the update requests that may deploy this app; lists hold path.Match patterns."},
+	"AppAllow.repos":                {desc: "Source repositories (owner/name) allowed in the request's repo."},
+	"AppAllow.tags":                 {desc: "Release tags allowed, e.g. v*."},
+	"AppAllow.url_hosts":            {desc: "Hosts download_url may point at, e.g. api.github.com."},
+	"AppAllow.secret":               {desc: "Accept only signatures made with this app's own secret, Store key DEPLOY_HMAC_SECRET/<name> (DEPLOY_GITLAB_WEBHOOK_TOKEN/<name> for GitLab webhooks)."},
+	"AppAllow.signing_keys":         {desc: "IDs of the updater.signing_keys accepted for this app; empty accepts all, or none when secret is set."},
+	"AppConfig.repo":                {desc: "Repository (owner/name) whose releases the poll method deploys."},
+	"AppConfig.source":              {desc: "Forge hosting the app's releases; selects the download token and the webhook endpoint (/github, /gitlab or /gitea).", def: string(SourceGitHub), enum: []string{string(SourceGitHub), string(SourceGitLab), string(SourceGitea)}},
+	"AppConfig.asset":               {desc: "Release asset or workflow artifact name (path.Match pattern) deploying this app from forge webhooks (default: executable)."},
+	"AppConfig.channel":             {desc: "Release channel followed: stable deploys releases only, any other name also its prereleases (beta accepts v1.2.0-beta.1). Requires semantic version tags and refuses downgrades."},
+	"AppConfig.version_policy":      {desc: "Versions this app may be updated to; requires semantic version tags and refuses downgrades."},
+	"VersionPolicy.constraint":      {desc: "Semantic version range, e.g. \">=1.4, <2\" or \"^1.4 || ^2\"; operators =, !=, >, >=, <, <=, ~ and ^."},
+	"VersionPolicy.prerelease":      {desc: "Accept prereleases of any channel."},
+	"VersionPolicy.allow_downgrade": {desc: "Deploy versions older than the installed one without a forced request."},
+	"AppConfig.oidc":                {desc: "Claims an OIDC token must carry to deploy this app; values are path.Match patterns."},
+	"OIDCClaims.repository":         {desc: "Repository (owner/name) running the workflow. Required."},
+	"OIDCClaims.ref":                {desc: "Git ref of the run, e.g. refs/heads/main or refs/tags/v*."},
+	"OIDCClaims.environment":        {desc: "GitHub deployment environment of the job."},
+	"OIDCClaims.workflow":           {desc: "Workflow name."},
+
+	"RollbackConfig.enabled":                  {desc: "Keep the previous executable as <name>-older."},
+	"RollbackConfig.keep_versions":            {desc: "Number of previous versions kept."},
+	"RollbackConfig.auto_rollback_on_failure": {desc: "Restore the previous version when the new one fails its health check."},
+
+	"NotifierConfig.name":         {desc: "Name used in logs."},
+	"NotifierConfig.type":         {desc: "Kind of target.", def: "webhook", enum: []string{"webhook", "slack", "discord", "email"}},
+	"NotifierConfig.url":          {desc: "Webhook URL (webhook, slack and discord)."},
+	"NotifierConfig.events":       {desc: "Events to send; empty sends all.", enum: []string{NotifyStarted, NotifySucceeded, NotifyFailed, NotifyRolledBack}},
+	"NotifierConfig.apps":         {desc: "Apps to notify about; empty means all."},
+	"NotifierConfig.template":     {desc: "Go text/template rendering the message from the notification."},
+	"NotifierConfig.smtp_host":    {desc: "SMTP server as host:port (email)."},
+	"NotifierConfig.smtp_user":    {desc: "SMTP user name (email)."},
+	"NotifierConfig.password_key": {desc: "Store key holding the SMTP password (email)."},
+	"NotifierConfig.from":         {desc: "Sender address (email)."},
+	"NotifierConfig.to":           {desc: "Recipient addresses (email)."},
+}
+
+// durationPattern matches time.ParseDuration strings such as "1m30s".
+const durationPattern = `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$|^0$`
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Schema returns a JSON Schema (draft 2020-12) describing deploy.yaml, for
+// editors with a YAML language server. It is generated from the Config types.
+func Schema() ([]byte, error) {
+	g := &schemaGen{defs: make(map[string]any), used: make(map[string]bool)}
+	root, err := g.object(reflect.TypeOf(Config{}))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range sortedKeys(configDocs) {
+		if !g.used[key] {
+			return nil, fmt.Errorf("deploy: schema: %s is documented but not a config field", key)
+		}
+	}
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["$id"] = SchemaID
+	root["title"] = "deploy.yaml"
+	root["$defs"] = g.defs
+	return json.MarshalIndent(root, "", "  ")
+}
+
+type schemaGen struct {
+	defs map[string]any
+	used map[string]bool // configDocs entries seen
+}
+
+// object builds the schema of struct type t with one property per yaml key.
+func (g *schemaGen) object(t reflect.Type) (map[string]any, error) {
+	props := make(map[string]any)
+	for key, f := range yamlFields(t) {
+		doc, ok := configDocs[t.Name()+"."+key]
+		g.used[t.Name()+"."+key] = true
+		if !ok {
+			return nil, fmt.Errorf("deploy: schema: %s.%s is not documented", t.Name(), key)
+		}
+		s, err := g.value(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		s["description"] = doc.desc
+		if doc.def != nil {
+			s["default"] = doc.def
+		}
+		if doc.enum != nil {
+			if items, ok := s["items"].(map[string]any); ok {
+				items["enum"] = doc.enum
+			} else {
+				s["enum"] = doc.enum
+			}
+		}
+		props[key] = s
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}, nil
+}
+
+// value returns the schema of a field of type t. Nested structs are placed
+// in $defs and referenced, so each appears once in the output.
+func (g *schemaGen) value(t reflect.Type) (map[string]any, error) {
+	if t == durationType {
+		return map[string]any{
+			"type":    []string{"string", "integer"},
+			"pattern": durationPattern,
+		}, nil
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.value(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.Slice:
+		items, err := g.value(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		values, err := g.value(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // reserve, in case of recursion
+			def, err := g.object(t)
+			if err != nil {
+				return nil, err
+			}
+			g.defs[t.Name()] = def
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}, nil
+	}
+	return nil, fmt.Errorf("deploy: schema: unsupported type %s", t)
+}
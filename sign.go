@@ -0,0 +1,116 @@
+package deploy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SignatureKeyIDHeader names the key that signed a request.
+const SignatureKeyIDHeader = "X-Signature-Key-Id"
+
+// Ed25519 signatures are sent as "X-Signature: ed25519=<base64>" together
+// with SignatureKeyIDHeader. The puller only holds the public keys, listed
+// in deploy.yaml by key ID:
+//
+//	updater:
+//	  signing_keys:
+//	    ci-2026: MCowBQYDK2VwAyEA...   # base64 public key
+//
+// so a compromised server cannot forge deploys to the others. CI keeps the
+// private key (PKCS#8 PEM) and signs the exact request body with
+// `puller sign` or openssl:
+//
+//	openssl pkeyutl -sign -inkey key.pem -rawin -in payload.json | base64 -w0
+const ed25519SigPrefix = "ed25519="
+
+// GenerateSigningKey returns a new private key as PKCS#8 PEM and its public
+// key in the form expected by updater.signing_keys.
+func GenerateSigningKey() (privatePEM []byte, public string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, "", err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// ParseSigningKey parses a PKCS#8 PEM ed25519 private key.
+func ParseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("deploy: signing key is not PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("deploy: signing key: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("deploy: signing key is %T, want ed25519", key)
+	}
+	return priv, nil
+}
+
+// ParsePublicKey parses a public key of updater.signing_keys: the base64 raw
+// 32-byte key, or the base64 DER (SubjectPublicKeyInfo) openssl prints.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("deploy: public key: %w", err)
+	}
+	if len(raw) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(raw), nil
+	}
+	key, err := x509.ParsePKIXPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("deploy: public key: %w", err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("deploy: public key is %T, want ed25519", key)
+	}
+	return pub, nil
+}
+
+// SignEd25519 returns the X-Signature header value for payload.
+func SignEd25519(key ed25519.PrivateKey, payload []byte) string {
+	return ed25519SigPrefix + base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+}
+
+// isEd25519Signature reports whether an X-Signature value is an ed25519 one.
+func isEd25519Signature(signature string) bool {
+	return strings.HasPrefix(signature, ed25519SigPrefix)
+}
+
+// verifyEd25519 checks signature over payload against the public key keyID
+// of keys.
+func verifyEd25519(keys map[string]string, keyID string, payload []byte, signature string) error {
+	if keyID == "" {
+		return fmt.Errorf("missing %s", SignatureKeyIDHeader)
+	}
+	encoded, ok := keys[keyID]
+	if !ok {
+		return fmt.Errorf("unknown signing key %q", keyID)
+	}
+	pub, err := ParsePublicKey(encoded)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(signature, ed25519SigPrefix))
+	if err != nil {
+		return fmt.Errorf("invalid base64 signature: %w", err)
+	}
+	if !ed25519.Verify(pub, payload, sig) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
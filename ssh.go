@@ -49,7 +49,7 @@ func SSHScript(app AppConfig, downloadURL, githubPAT string) string {
 		fmt.Fprintf(&b, "# Health check\n")
 		fmt.Fprintf(&b, "sleep %d\n", startupSecs)
 		fmt.Fprintf(&b, "for i in $(seq 1 %d); do\n", retries)
-		fmt.Fprintf(&b, "  if curl -sf %q > /dev/null; then echo 'health ok'; exit 0; fi\n", app.HealthEndpoint)
+		fmt.Fprintf(&b, "  if curl -sf %q > /dev/null; then echo 'health ok'; exit 0; fi\n", app.healthURL())
 		fmt.Fprintf(&b, "  sleep 2\n")
 		fmt.Fprintf(&b, "done\n")
 		fmt.Fprintf(&b, "echo 'health check failed'\n")
@@ -0,0 +1,162 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// State holds runtime facts the puller records between deploys, kept apart
+// from deploy.yaml so the hand-edited configuration is never rewritten.
+type State struct {
+	Apps map[string]AppState `json:"apps"` // by app name, or executable when unnamed
+}
+
+// stateHistoryLimit bounds AppState.History.
+const stateHistoryLimit = 20
+
+// AppState is what the puller knows about one deployed app.
+type AppState struct {
+	Version      string          `json:"version"`
+	DeployID     string          `json:"deploy_id,omitempty"`
+	DeployedAt   time.Time       `json:"deployed_at"`
+	Digest       string          `json:"digest,omitempty"`        // "sha256:<hex>" of the running binary
+	DownloadURL  string          `json:"download_url,omitempty"`  // where that binary was downloaded from
+	Repo         string          `json:"repo,omitempty"`          // repository the binary was built from
+	PromotedFrom []PromotionStep `json:"promoted_from,omitempty"` // as claimed by the update, unverified
+	History      []Deployment    `json:"history,omitempty"`       // most recent first
+}
+
+// Deployment is one successful deploy kept in AppState.History.
+type Deployment struct {
+	Version      string          `json:"version,omitempty"`
+	DeployID     string          `json:"deploy_id"`
+	DeployedAt   time.Time       `json:"deployed_at"`
+	Digest       string          `json:"digest,omitempty"`
+	Repo         string          `json:"repo,omitempty"`
+	PromotedFrom []PromotionStep `json:"promoted_from,omitempty"`
+}
+
+// record makes d the current deploy of s and prepends it to the history.
+func (s *AppState) record(d Deployment, downloadURL string) {
+	if d.Version != "" {
+		s.Version = d.Version
+	}
+	s.DeployID, s.DeployedAt, s.Digest = d.DeployID, d.DeployedAt, d.Digest
+	s.DownloadURL, s.Repo, s.PromotedFrom = downloadURL, d.Repo, d.PromotedFrom
+	s.History = append([]Deployment{d}, s.History...)
+	if len(s.History) > stateHistoryLimit {
+		s.History = s.History[:stateHistoryLimit]
+	}
+}
+
+// StatePath returns the state file used for the configuration at configPath:
+// deploy.yaml → deploy.state.json in the same directory.
+func StatePath(configPath string) string {
+	return strings.TrimSuffix(configPath, filepath.Ext(configPath)) + ".state.json"
+}
+
+// stateKey identifies app in State.Apps.
+func stateKey(app *AppConfig) string {
+	if app.Name != "" {
+		return app.Name
+	}
+	return app.Executable
+}
+
+// Apply overlays recorded versions onto cfg.
+func (s *State) Apply(cfg *Config) {
+	for i := range cfg.Apps {
+		if st, ok := s.Apps[stateKey(&cfg.Apps[i])]; ok && st.Version != "" {
+			cfg.Apps[i].Version = st.Version
+		}
+	}
+}
+
+// StateFile reads and atomically updates a State stored as JSON. Updates are
+// serialised across processes with a lock file next to Path.
+type StateFile struct {
+	Path string
+}
+
+// Read returns the stored state; a missing file is an empty state.
+func (f *StateFile) Read() (*State, error) {
+	s := &State{Apps: make(map[string]AppState)}
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("deploy: parse state %s: %w", f.Path, err)
+	}
+	if s.Apps == nil {
+		s.Apps = make(map[string]AppState)
+	}
+	return s, nil
+}
+
+// Update locks the file, applies fn to the current state and writes the
+// result atomically. Nothing is written when fn returns an error.
+func (f *StateFile) Update(fn func(*State) error) error {
+	unlock, err := lockFile(f.Path + ".lock")
+	if err != nil {
+		return fmt.Errorf("deploy: lock state: %w", err)
+	}
+	defer unlock()
+
+	s, err := f.Read()
+	if err != nil {
+		return err
+	}
+	if err := fn(s); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.Path, append(data, '\n'), 0644)
+}
+
+// writeFileAtomic replaces path with data so readers see either the old or
+// the new content: it writes a temporary file in the same directory, syncs
+// it, and renames it over path.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Persist the rename itself; not supported on every platform.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
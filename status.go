@@ -0,0 +1,224 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusMaxSkew bounds how far X-Timestamp may drift from the puller clock.
+const statusMaxSkew = 5 * time.Minute
+
+// StatusReport is the body returned by GET /status.
+type StatusReport struct {
+	Puller PullerStatus `json:"puller"`
+	Apps   []AppStatus  `json:"apps"`
+}
+
+// PullerStatus describes the puller process itself.
+type PullerStatus struct {
+	Version       string          `json:"version"`
+	UptimeSeconds float64         `json:"uptime_seconds"`
+	HMACKeys      []HMACKeyStatus `json:"hmac_keys,omitempty"`
+}
+
+// AppStatus describes one configured app and the process running it.
+type AppStatus struct {
+	Name          string        `json:"name"`
+	Executable    string        `json:"executable"`
+	Version       string        `json:"version"`
+	Running       bool          `json:"running"`
+	PID           int           `json:"pid,omitempty"`
+	UptimeSeconds float64       `json:"uptime_seconds,omitempty"`
+	CPUSeconds    float64       `json:"cpu_seconds,omitempty"`
+	CPUPercent    float64       `json:"cpu_percent,omitempty"` // average since process start
+	RSSBytes      uint64        `json:"rss_bytes,omitempty"`
+	Restarts      int           `json:"restarts"`
+	LastDeploy    *DeployRecord `json:"last_deploy,omitempty"`
+
+	// The artifact recorded in the state file for the running version.
+	DeployID     string          `json:"deploy_id,omitempty"`
+	Digest       string          `json:"digest,omitempty"`
+	DownloadURL  string          `json:"download_url,omitempty"`
+	Repo         string          `json:"repo,omitempty"`
+	PromotedFrom []PromotionStep `json:"promoted_from,omitempty"`
+
+	// AppSecret is set when updates of the app must be signed with its own
+	// secret, AppSecretKey(Name), rather than DEPLOY_HMAC_SECRET.
+	AppSecret bool `json:"app_secret,omitempty"`
+}
+
+// DeployRecord summarises the last deploy attempt of an app.
+type DeployRecord struct {
+	ID      string    `json:"id"`
+	Tag     string    `json:"tag,omitempty"`
+	Outcome string    `json:"outcome"`
+	At      time.Time `json:"at"`
+}
+
+// ProcInfo is the resource usage of a running process, read from /proc.
+type ProcInfo struct {
+	PID    int
+	Uptime time.Duration
+	CPU    time.Duration // user + system time
+	RSS    uint64        // bytes
+}
+
+// appRuntime is what the handler remembers about an app between requests.
+type appRuntime struct {
+	restarts   int
+	lastDeploy *DeployRecord
+}
+
+// StatusSigningPayload returns the bytes a client signs with the HMAC secret
+// to call GET /status; timestamp is the X-Timestamp header (unix seconds).
+func StatusSigningPayload(timestamp string) []byte {
+	return []byte("GET /status\n" + timestamp)
+}
+
+// FetchStatus calls GET /status on the puller at baseURL, signing the
+// request with secret.
+func FetchStatus(ctx context.Context, baseURL, secret string) (*StatusReport, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Timestamp", ts)
+	req.Header.Set("X-Signature", NewHMACValidator(secret).Sign(StatusSigningPayload(ts)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("deploy: status %s: %w", baseURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("deploy: status %s: %s: %s", baseURL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var report StatusReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("deploy: status %s: %w", baseURL, err)
+	}
+	return &report, nil
+}
+
+// HandleStatus serves GET /status. The request must carry X-Timestamp and an
+// X-Signature over StatusSigningPayload, so only holders of the HMAC secret
+// can read process details.
+func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ts := r.Header.Get("X-Timestamp")
+	signature := r.Header.Get("X-Signature")
+	if ts == "" || signature == "" {
+		http.Error(w, "Missing signature", http.StatusUnauthorized)
+		return
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid timestamp", http.StatusUnauthorized)
+		return
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > statusMaxSkew || skew < -statusMaxSkew {
+		http.Error(w, "Stale timestamp", http.StatusUnauthorized)
+		return
+	}
+	// Status reads do not count as key use, so rotation reports see deploys only.
+	if err := h.verifySignature(r, nil, h.Validator, StatusSigningPayload(ts), signature, false); err != nil {
+		h.Metrics.ObserveHMACFailure()
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Status())
+}
+
+// Status collects the current state of the puller and every configured app.
+func (h *Handler) Status() *StatusReport {
+	report := &StatusReport{Puller: PullerStatus{Version: Version, HMACKeys: h.Validator.Keys()}}
+	if !h.StartedAt.IsZero() {
+		report.Puller.UptimeSeconds = time.Since(h.StartedAt).Seconds()
+	}
+
+	state := &State{}
+	if f := h.stateFile(); f != nil {
+		if s, err := f.Read(); err == nil {
+			state = s
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, app := range h.config().Apps {
+		st := AppStatus{
+			Name:       app.Name,
+			Executable: app.Executable,
+			Version:    app.Version,
+		}
+		if a, ok := state.Apps[stateKey(&app)]; ok {
+			st.DeployID, st.Digest, st.DownloadURL, st.PromotedFrom = a.DeployID, a.Digest, a.DownloadURL, a.PromotedFrom
+			st.Repo = a.Repo
+		}
+		st.AppSecret = app.Allow != nil && app.Allow.Secret
+		if rt := h.runtime[app.Name]; rt != nil {
+			st.Restarts = rt.restarts
+			if rt.lastDeploy != nil {
+				last := *rt.lastDeploy
+				st.LastDeploy = &last
+			}
+		}
+		if info, err := inspectProcess(filepath.Join(app.Path, app.Executable)); err == nil && info != nil {
+			st.Running = true
+			st.PID = info.PID
+			st.UptimeSeconds = info.Uptime.Seconds()
+			st.CPUSeconds = info.CPU.Seconds()
+			st.RSSBytes = info.RSS
+			if info.Uptime > 0 {
+				st.CPUPercent = 100 * info.CPU.Seconds() / info.Uptime.Seconds()
+			}
+		}
+		report.Apps = append(report.Apps, st)
+	}
+	return report
+}
+
+func (h *Handler) appState(name string) *appRuntime {
+	if h.runtime == nil {
+		h.runtime = make(map[string]*appRuntime)
+	}
+	rt := h.runtime[name]
+	if rt == nil {
+		rt = &appRuntime{}
+		h.runtime[name] = rt
+	}
+	return rt
+}
+
+// startProcess starts exePath and counts the restart for app.
+func (h *Handler) startProcess(app, exePath string) error {
+	if err := h.Process.Start(exePath); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.appState(app).restarts++
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *Handler) recordDeploy(app string, rec DeployRecord) {
+	h.mu.Lock()
+	h.appState(app).lastDeploy = &rec
+	h.mu.Unlock()
+}
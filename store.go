@@ -3,6 +3,7 @@ package deploy
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/zalando/go-keyring"
 )
@@ -14,35 +15,52 @@ import (
 //
 //	DEPLOY_METHOD       → "cloudflarePages" | "cloudflareWorker" | "webhook" | "ssh"
 //	DEPLOY_GITHUB_PAT   → GitHub Personal Access Token
+//	DEPLOY_GITLAB_TOKEN → GitLab access token, for apps with source gitlab
+//	DEPLOY_GITLAB_WEBHOOK_TOKEN[/<app>] → secret token of GitLab webhooks (see GitLabWebhookTokenKey)
+//	DEPLOY_GITEA_TOKEN  → Gitea/Forgejo access token, for apps with source gitea
 //	DEPLOY_HMAC_SECRET  → HMAC-SHA256 secret for webhook validation
+//	DEPLOY_HMAC_KEYS    → all accepted HMAC secrets during rotation (see RotateHMACKey)
+//	DEPLOY_HMAC_SECRET/<app> → HMAC secret of one app with allow.secret (see AppSecretKey)
 //	DEPLOY_SERVER_HOST  → host:port for webhook or SSH host
+//	DEPLOY_RELAY_TOKEN/<id> → token puller <id> presents to the relay (see RelayTokenKey)
 //	DEPLOY_SSH_USER     → SSH username
 //	DEPLOY_SSH_KEY      → SSH private key path/content
 //	CF_ACCOUNT_ID       → Cloudflare account ID
-//	CF_PAGES_TOKEN      → Cloudflare scoped Pages:Edit token (auto-created)
+//	CF_PAGES_TOKEN      → legacy Pages token, migrated to goflare/<CF_PROJECT>
+//	goflare/<project>   → Cloudflare scoped Pages:Edit token (auto-created)
 //	CF_PROJECT          → Cloudflare project name
 //	CF_WORKER_TOKEN     → Cloudflare scoped Workers:Edit token
+//	*_PASSWORD          → notifier credentials (e.g. NOTIFY_SMTP_PASSWORD)
+//	DEPLOY_STORE_VERSION → layout version of these keys (see MigrateStore)
+//	env/<name>/<KEY>    → any key above, scoped to an environment (see EnvStore)
 // KeyringServiceName is the service name used for storing secrets in the OS keyring.
 const KeyringServiceName = "tinywasm-deploy"
 
 var sensitiveKeys = map[string]bool{
-	"DEPLOY_GITHUB_PAT":  true,
-	"DEPLOY_HMAC_SECRET": true,
-	"DEPLOY_SSH_KEY":     true,
-	"CF_PAGES_TOKEN":     true,
-	"CF_WORKER_TOKEN":    true,
+	"DEPLOY_GITHUB_PAT":           true,
+	"DEPLOY_GITLAB_TOKEN":         true,
+	"DEPLOY_GITLAB_WEBHOOK_TOKEN": true,
+	"DEPLOY_GITEA_TOKEN":          true,
+	"DEPLOY_HMAC_SECRET":          true,
+	"DEPLOY_HMAC_KEYS":            true,
+	"DEPLOY_SSH_KEY":              true,
+	"CF_PAGES_TOKEN":              true,
+	"CF_WORKER_TOKEN":             true,
 }
 
 // isSensitive reports whether the given key contains sensitive information
 // that should be stored in the OS keyring.
 func isSensitive(key string) bool {
-	return sensitiveKeys[key] || strings.HasPrefix(key, "goflare/")
+	key = baseKey(key) // env/<name>/KEY is as sensitive as KEY
+	return sensitiveKeys[key] || strings.HasPrefix(key, "goflare/") || strings.HasPrefix(key, "DEPLOY_HMAC_SECRET/") || strings.HasPrefix(key, "DEPLOY_RELAY_TOKEN/") || strings.HasPrefix(key, "DEPLOY_GITLAB_WEBHOOK_TOKEN/") || strings.HasSuffix(key, "_PASSWORD")
 }
 
 // SecureStore wraps a base Store and routes sensitive keys securely to the OS keyring.
 // Minimal interface: no adapter needed for the base KVStore.
 type SecureStore struct {
-	base Store
+	base     Store
+	once     sync.Once
+	redactor *Redactor
 }
 
 // NewSecureStore initializes a new SecureStore wrapping the given base store.
@@ -57,6 +75,7 @@ func (s *SecureStore) Get(key string) (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("secure store: key %q not found in keyring: %w", key, err)
 		}
+		s.Redactor().Add(val)
 		return val, nil
 	}
 	return s.base.Get(key)
@@ -69,6 +88,7 @@ func (s *SecureStore) Set(key, value string) error {
 		if value == "" {
 			return keyring.Delete(KeyringServiceName, key)
 		}
+		s.Redactor().Add(value)
 		if err := keyring.Set(KeyringServiceName, key, value); err != nil {
 			return fmt.Errorf("secure store: failed to save %q to keyring: %w", key, err)
 		}
@@ -78,3 +98,26 @@ func (s *SecureStore) Set(key, value string) error {
 	}
 	return s.base.Set(key, value)
 }
+
+// Redactor returns the Redactor fed with every sensitive value this store
+// has read or written. On first use it preloads the well-known keys, and
+// the goflare/<CF_PROJECT> token, from the keyring so secrets are masked
+// even before they are fetched.
+func (s *SecureStore) Redactor() *Redactor {
+	s.once.Do(func() {
+		s.redactor = NewRedactor()
+		keys := make([]string, 0, len(sensitiveKeys)+1)
+		for key := range sensitiveKeys {
+			keys = append(keys, key)
+		}
+		if project, err := s.base.Get("CF_PROJECT"); err == nil && project != "" {
+			keys = append(keys, "goflare/"+project)
+		}
+		for _, key := range keys {
+			if val, err := keyring.Get(KeyringServiceName, key); err == nil {
+				s.redactor.Add(val)
+			}
+		}
+	})
+	return s.redactor
+}
@@ -0,0 +1,133 @@
+package deploy_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+func TestHandleUpdate_AllowRules(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "api.exe"), []byte("old"), 0755)
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	keys.Set(deploy.AppSecretKey("billing"), "billing-secret")
+
+	app := func(name string, allow *deploy.AppAllow) deploy.AppConfig {
+		return deploy.AppConfig{Name: name, Executable: name + ".exe", Path: tmpDir,
+			BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond, Allow: allow}
+	}
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{
+				app("api", &deploy.AppAllow{Repos: []string{"acme/api"}, Tags: []string{"v*"}, URLHosts: []string{"api.github.com"}}),
+				app("billing", &deploy.AppAllow{Secret: true}),
+				app("open", nil),
+			},
+		},
+		Validator:  deploy.NewHMACValidator("shared-secret"),
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}
+
+	post := func(secret, body string) int {
+		req := httptest.NewRequest("POST", "/update", bytes.NewBufferString(body))
+		req.Header.Set("X-Signature", deploy.NewHMACValidator(secret).Sign([]byte(body)))
+		w := httptest.NewRecorder()
+		handler.HandleUpdate(w, req)
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		name   string
+		secret string
+		body   string
+		want   int
+	}{
+		{"allowed", "shared-secret", `{"executable":"api.exe","repo":"acme/api","tag":"v1.0.0","download_url":"https://api.github.com/x"}`, http.StatusOK},
+		{"other repo", "shared-secret", `{"executable":"api.exe","repo":"acme/web","tag":"v1.0.0","download_url":"https://api.github.com/x"}`, http.StatusForbidden},
+		{"missing repo", "shared-secret", `{"executable":"api.exe","tag":"v1.0.0","download_url":"https://api.github.com/x"}`, http.StatusForbidden},
+		{"other tag", "shared-secret", `{"executable":"api.exe","repo":"acme/api","tag":"nightly","download_url":"https://api.github.com/x"}`, http.StatusForbidden},
+		{"other host", "shared-secret", `{"executable":"api.exe","repo":"acme/api","tag":"v1.0.0","download_url":"https://evil.example.com/x"}`, http.StatusForbidden},
+		{"app secret", "billing-secret", `{"executable":"billing.exe","tag":"v1"}`, http.StatusOK},
+		{"shared secret for app with own secret", "shared-secret", `{"executable":"billing.exe","tag":"v1"}`, http.StatusUnauthorized},
+		{"app secret for another app", "billing-secret", `{"executable":"open.exe","tag":"v1"}`, http.StatusUnauthorized},
+		{"no rules", "shared-secret", `{"executable":"open.exe","tag":"v1"}`, http.StatusOK},
+	} {
+		if got := post(tc.secret, tc.body); got != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHandleUpdate_AllowSigningKeys(t *testing.T) {
+	privPEM, pub, _ := deploy.GenerateSigningKey()
+	key, _ := deploy.ParseSigningKey(privPEM)
+	_, otherPub, _ := deploy.GenerateSigningKey()
+	tmpDir := t.TempDir()
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	keys.Set(deploy.AppSecretKey("billing"), "billing-secret")
+	keys.Set(deploy.AppSecretKey("payroll"), "payroll-secret")
+	app := func(name string, allow *deploy.AppAllow) deploy.AppConfig {
+		return deploy.AppConfig{Name: name, Executable: name, Path: tmpDir,
+			BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond, Allow: allow}
+	}
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir, SigningKeys: map[string]string{"ci": pub, "other": otherPub}},
+			Apps: []deploy.AppConfig{
+				app("billing", &deploy.AppAllow{Secret: true}),
+				app("payroll", &deploy.AppAllow{Secret: true, SigningKeys: []string{"ci"}}),
+				app("api", &deploy.AppAllow{SigningKeys: []string{"other"}}),
+				app("open", nil),
+			},
+		},
+		Validator:  deploy.NewHMACValidator("shared-secret"),
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}
+
+	for exe, want := range map[string]int{
+		"billing": http.StatusUnauthorized, // own secret only
+		"payroll": http.StatusOK,           // own secret or key ci
+		"api":     http.StatusUnauthorized, // key other only
+		"open":    http.StatusOK,
+	} {
+		body := []byte(`{"executable":"` + exe + `","tag":"v1"}`)
+		req := httptest.NewRequest("POST", "/update", bytes.NewReader(body))
+		req.Header.Set("X-Signature", deploy.SignEd25519(key, body))
+		req.Header.Set(deploy.SignatureKeyIDHeader, "ci")
+		w := httptest.NewRecorder()
+		handler.HandleUpdate(w, req)
+		if w.Code != want {
+			t.Errorf("%s signed with key ci: status %d, want %d", exe, w.Code, want)
+		}
+	}
+}
+
+func TestValidateBytes_AllowRules(t *testing.T) {
+	data := "version: 2\napps:\n  - executable: api\n    path: /srv\n    allow:\n      tags: [\"v[\"]\n      secret: true\n      signing_keys: [ci]\n"
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(data))
+	if d := findDiag(ds, "apps[0].allow.tags[0]"); d == nil || !strings.Contains(d.Message, "invalid pattern") {
+		t.Errorf("expected invalid pattern error, got %v", ds)
+	}
+	if d := findDiag(ds, "apps[0].allow.secret"); d == nil || d.Line != 7 {
+		t.Errorf("expected secret without name error on line 7, got %v", ds)
+	}
+	if d := findDiag(ds, "apps[0].allow.signing_keys[0]"); d == nil || !strings.Contains(d.Message, "unknown signing key") {
+		t.Errorf("expected unknown signing key error, got %v", ds)
+	}
+}
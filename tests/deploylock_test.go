@@ -0,0 +1,94 @@
+package deploy_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+// overlapDownloader records how many downloads ran at once.
+type overlapDownloader struct {
+	mu           sync.Mutex
+	active, peak int
+}
+
+func (d *overlapDownloader) Download(url, dest, token string) error {
+	d.mu.Lock()
+	d.active++
+	d.peak = max(d.peak, d.active)
+	d.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	d.mu.Lock()
+	d.active--
+	d.mu.Unlock()
+	return os.WriteFile(dest, []byte(url), 0644)
+}
+
+func TestDeploy_SerializedPerApp(t *testing.T) {
+	dl := &overlapDownloader{}
+	h, _ := newGitHubHandler(t, dl)
+	release := `{"action":"published","repository":{"full_name":"acme/api"},"release":{"tag_name":"v1.2.0","assets":[
+		{"name":"api-linux-amd64","url":"https://api.github.com/repos/acme/api/releases/assets/2"}]}}`
+
+	var wg sync.WaitGroup
+	codes := make([]int, 3)
+	for i, tag := range []string{"v1.0.0", "v1.1.0"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := `{"executable":"api","tag":"` + tag + `"}`
+			req := httptest.NewRequest("POST", "/update", bytes.NewBufferString(body))
+			req.Header.Set("X-Signature", deploy.NewHMACValidator(githubSecret).Sign([]byte(body)))
+			w := httptest.NewRecorder()
+			h.HandleUpdate(w, req)
+			codes[i] = w.Code
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		codes[2] = deliver(h, "release", release, githubSecret).Code
+	}()
+	wg.Wait()
+	h.Wait()
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusAccepted {
+		t.Errorf("statuses %v", codes)
+	}
+	if dl.peak != 1 {
+		t.Errorf("%d deploys of one app ran at once", dl.peak)
+	}
+}
+
+func TestDeploy_ConcurrentSameVersionDeploysOnce(t *testing.T) {
+	dl := &overlapDownloader{}
+	h, _ := newGitHubHandler(t, dl)
+	body := `{"executable":"api","tag":"v2.0.0"}`
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 2)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/update", bytes.NewBufferString(body))
+			req.Header.Set("X-Signature", deploy.NewHMACValidator(githubSecret).Sign([]byte(body)))
+			w := httptest.NewRecorder()
+			h.HandleUpdate(w, req)
+			bodies[i] = w.Body.String()
+		}()
+	}
+	wg.Wait()
+
+	// The second request waits for the first and then finds v2.0.0 installed.
+	if !(bodies[0] == "Update successful" && bodies[1] == "Already up to date") &&
+		!(bodies[1] == "Update successful" && bodies[0] == "Already up to date") {
+		t.Errorf("responses %q", bodies)
+	}
+}
@@ -0,0 +1,161 @@
+package deploy_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tinywasm/deploy"
+)
+
+const envConfig = `version: 2
+apps:
+  - name: api
+    executable: api
+    path: /srv/api
+    port: 8000
+    health_endpoint: http://localhost:8000/h
+environments:
+  staging:
+    host: staging.example.com:8080
+    apps:
+      - name: api
+        port: 9001
+      - name: debug
+        executable: debug
+        path: /srv/debug
+  production:
+    method: ssh
+`
+
+func TestForEnvironment_OverridesPresentKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	os.WriteFile(path, []byte(envConfig), 0644)
+	cfg, err := deploy.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	staging, err := cfg.ForEnvironment("staging")
+	if err != nil {
+		t.Fatalf("ForEnvironment() error = %v", err)
+	}
+	if got := appNames(staging); got != "api,debug" {
+		t.Fatalf("unexpected apps %q", got)
+	}
+	api := staging.Apps[0]
+	if api.Port != 9001 || api.Path != "/srv/api" || api.HealthEndpoint != "http://localhost:8000/h" {
+		t.Errorf("expected only port overridden, got %+v", api)
+	}
+	if staging.Apps[1].BusyTimeout == 0 {
+		t.Error("expected defaults applied to an environment-only app")
+	}
+	if cfg.Apps[0].Port != 8000 || len(cfg.Apps) != 1 {
+		t.Errorf("base config modified: %+v", cfg.Apps)
+	}
+
+	if same, _ := cfg.ForEnvironment(""); same != cfg {
+		t.Error("empty environment should return the config unchanged")
+	}
+	if _, err := cfg.ForEnvironment("qa"); err == nil || !strings.Contains(err.Error(), "production, staging") {
+		t.Errorf("expected unknown environment error listing environments, got %v", err)
+	}
+}
+
+func TestEnvStore_Scoping(t *testing.T) {
+	base := NewMockStore()
+	base.Set("DEPLOY_SERVER_HOST", "shared:8080")
+	base.Set("DEPLOY_HMAC_SECRET", "shared-secret")
+	base.Set(deploy.EnvKey("production", "DEPLOY_HMAC_SECRET"), "prod-secret")
+
+	prod := deploy.NewEnvStore(base, "production")
+	if v, _ := prod.Get("DEPLOY_HMAC_SECRET"); v != "prod-secret" {
+		t.Errorf("scoped secret = %q", v)
+	}
+	if v, _ := prod.Get("DEPLOY_SERVER_HOST"); v != "shared:8080" {
+		t.Errorf("non-secret should fall back to the shared key, got %q", v)
+	}
+
+	staging := deploy.NewEnvStore(base, "staging")
+	if v, err := staging.Get("DEPLOY_HMAC_SECRET"); err == nil {
+		t.Errorf("secret must not fall back to the shared key, got %q", v)
+	}
+	staging.Set("DEPLOY_HMAC_SECRET", "staging-secret")
+	if v, _ := base.Get("env/staging/DEPLOY_HMAC_SECRET"); v != "staging-secret" {
+		t.Errorf("Set wrote %q to the scoped key", v)
+	}
+
+	staging.Overrides = map[string]string{"DEPLOY_SERVER_HOST": "staging:8080"}
+	if v, _ := staging.Get("DEPLOY_SERVER_HOST"); v != "staging:8080" {
+		t.Errorf("override = %q", v)
+	}
+}
+
+func TestValidateBytes_Environments(t *testing.T) {
+	data := "version: 2\napps: []\nenvironments:\n  staging:\n    method: carrier-pigeon\n    apps:\n      - port: 1\n    hots: x\n"
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(data))
+
+	if d := findDiag(ds, "environments.staging.method"); d == nil || d.Line != 5 || !strings.Contains(d.Message, "carrier-pigeon") {
+		t.Errorf("expected unknown method on line 5, got %v", ds)
+	}
+	if d := findDiag(ds, "environments.staging.apps[0]"); d == nil || d.Severity != deploy.SeverityError {
+		t.Errorf("expected missing name error, got %v", ds)
+	}
+	if d := findDiag(ds, "environments.staging.hots"); d == nil || d.Line != 8 {
+		t.Errorf("expected unknown field on line 8, got %v", ds)
+	}
+}
+
+func TestForEnvironment_LeavesBaseUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	os.WriteFile(path, []byte(`version: 2
+apps:
+  - name: api
+    executable: api
+    path: /srv/api
+    oidc:
+      repository: acme/api
+    allow:
+      tags: ["v*"]
+    version_policy:
+      constraint: "<2"
+environments:
+  staging:
+    apps:
+      - name: api
+        oidc:
+          ref: refs/heads/main
+        allow:
+          secret: true
+        version_policy:
+          prerelease: true
+  qa:
+    apps:
+      - name: api
+        version_policy:
+          constraint: "<3"
+`), 0644)
+	cfg, err := deploy.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	staging, err := cfg.ForEnvironment("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	qa, err := cfg.ForEnvironment("qa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := staging.Apps[0]; a.OIDC.Ref != "refs/heads/main" || a.OIDC.Repository != "acme/api" || !a.Allow.Secret || !a.VersionPolicy.Prerelease || a.VersionPolicy.Constraint != "<2" {
+		t.Errorf("staging: %+v %+v %+v", a.OIDC, a.Allow, a.VersionPolicy)
+	}
+	if a := qa.Apps[0]; a.VersionPolicy.Constraint != "<3" || a.VersionPolicy.Prerelease || a.Allow.Secret {
+		t.Errorf("qa sees staging's overrides: %+v %+v", a.Allow, a.VersionPolicy)
+	}
+	if a := cfg.Apps[0]; a.OIDC.Ref != "" || a.Allow.Secret || a.VersionPolicy.Prerelease || a.VersionPolicy.Constraint != "<2" {
+		t.Errorf("base config modified: %+v %+v %+v", a.OIDC, a.Allow, a.VersionPolicy)
+	}
+}
@@ -0,0 +1,131 @@
+package deploy_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+func newEventsHandler(t *testing.T, checker *MockHealthChecker) *deploy.Handler {
+	t.Helper()
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "app.exe"), []byte("old"), 0755)
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	return &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{{
+				Name:              "app",
+				Executable:        "app.exe",
+				Path:              tmpDir,
+				HealthEndpoint:    "http://localhost/health",
+				BusyTimeout:       10 * time.Millisecond,
+				BusyRetryInterval: time.Millisecond,
+			}},
+		},
+		Validator:  deploy.NewHMACValidator("secret"),
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    checker,
+		Keys:       keys,
+	}
+}
+
+func sendUpdate(h *deploy.Handler, payload string) {
+	sendUpdateTo(h, httptest.NewRecorder(), payload)
+}
+
+func sendUpdateTo(h *deploy.Handler, w http.ResponseWriter, payload string) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(payload))
+	req := httptest.NewRequest("POST", "/update", bytes.NewReader([]byte(payload)))
+	req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	h.HandleUpdate(w, req)
+}
+
+func TestHandler_EventsOnSuccess(t *testing.T) {
+	h := newEventsHandler(t, NewMockHealthChecker())
+	var events []deploy.Event
+	h.Subscribe(deploy.ObserverFunc(func(e deploy.Event) { events = append(events, e) }))
+
+	sendUpdate(h, `{"executable":"app.exe","tag":"v1.4.0"}`)
+
+	var types []deploy.EventType
+	for _, e := range events {
+		types = append(types, e.Type)
+		if e.App != "app" || e.Tag != "v1.4.0" || e.DeployID == "" {
+			t.Errorf("event missing context: %+v", e)
+		}
+	}
+	want := []deploy.EventType{
+		deploy.EventDeployStarted,
+		deploy.EventDownloaded,
+		deploy.EventStopped,
+		deploy.EventStarted,
+		deploy.EventCompleted,
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+	if last := events[len(events)-1]; last.Version != "v1.4.0" || last.Outcome != deploy.OutcomeSuccess {
+		t.Errorf("unexpected completed event: %+v", last)
+	}
+}
+
+func TestHandler_EventsOnHealthFailure(t *testing.T) {
+	checker := NewMockHealthChecker()
+	checker.QueueResponses["http://localhost/health"] = []*deploy.HealthStatus{
+		{Status: "ok", CanRestart: true},
+		{Status: "degraded"},
+	}
+	h := newEventsHandler(t, checker)
+	var types []deploy.EventType
+	var failed deploy.Event
+	h.Subscribe(deploy.ObserverFunc(func(e deploy.Event) {
+		types = append(types, e.Type)
+		if e.Type == deploy.EventFailed {
+			failed = e
+		}
+	}))
+
+	sendUpdate(h, `{"executable":"app.exe"}`)
+
+	want := []deploy.EventType{
+		deploy.EventDeployStarted,
+		deploy.EventDownloaded,
+		deploy.EventStopped,
+		deploy.EventStarted,
+		deploy.EventHealthFailed,
+		deploy.EventRolledBack,
+		deploy.EventFailed,
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+	if failed.Outcome != deploy.OutcomeHealthFailed || failed.Err == nil {
+		t.Errorf("unexpected failed event: %+v", failed)
+	}
+}
+
+func TestHandler_Unsubscribe(t *testing.T) {
+	h := newEventsHandler(t, NewMockHealthChecker())
+	count := 0
+	unsubscribe := h.Subscribe(deploy.ObserverFunc(func(deploy.Event) { count++ }))
+	unsubscribe()
+
+	sendUpdate(h, `{"executable":"app.exe"}`)
+	if count != 0 {
+		t.Errorf("expected no events after unsubscribe, got %d", count)
+	}
+}
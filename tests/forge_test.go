@@ -0,0 +1,140 @@
+package deploy_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+// newForgeHandler serves app "api" from source and app "gh" from GitHub.
+func newForgeHandler(t *testing.T, source deploy.Source) (*deploy.Handler, *MockDownloader, string) {
+	t.Helper()
+	dir := t.TempDir()
+	keys := NewMockStore()
+	keys.Set(source.TokenKey(), "forge-token")
+	dl := NewMockDownloader()
+	return &deploy.Handler{
+		Config: &deploy.Config{Updater: deploy.ConfigUpdater{TempDir: dir}, Apps: []deploy.AppConfig{
+			{Name: "api", Executable: "api", Path: dir, Source: string(source), BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond},
+			{Name: "gh", Executable: "gh", Path: dir, BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond},
+		}},
+		Validator:  deploy.NewHMACValidator(githubSecret),
+		Downloader: dl,
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}, dl, dir
+}
+
+func TestHandleGitLab_Release(t *testing.T) {
+	h, dl, dir := newForgeHandler(t, deploy.SourceGitLab)
+	h.Keys.Set(deploy.GitLabWebhookTokenKey(""), "gitlab-token")
+	release := `{"object_kind":"release","action":"create","tag":"v2.0.0","project":{"path_with_namespace":"acme/api"},
+		"assets":{"links":[{"name":"api","url":"https://gitlab.example.com/acme/api/-/releases/v2.0.0/downloads/api",
+		"direct_asset_url":"https://gitlab.example.com/api/v4/projects/1/packages/generic/api/2.0.0/api"},
+		{"name":"gh","url":"https://gitlab.example.com/gh"}]}}`
+	post := func(event, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/gitlab", strings.NewReader(body))
+		req.Header.Set(deploy.GitLabEventHeader, event)
+		req.Header.Set(deploy.GitLabTokenHeader, token)
+		w := httptest.NewRecorder()
+		h.HandleGitLab(w, req)
+		h.Wait()
+		return w
+	}
+
+	if w := post("Release Hook", release, "gitlab-token"); w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if len(dl.Downloaded) != 1 || !strings.HasPrefix(dl.Downloaded[0], "https://gitlab.example.com/api/v4/projects/1/") {
+		t.Errorf("expected only the direct asset URL of api, got %v", dl.Downloaded)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "api")); err != nil {
+		t.Errorf("api not installed: %v", err)
+	}
+	if w := post("Release Hook", release, "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", w.Code)
+	}
+	// The token travels in plain text, so it must not be the HMAC secret.
+	if w := post("Release Hook", release, githubSecret); w.Code != http.StatusUnauthorized {
+		t.Errorf("HMAC secret as token: status %d", w.Code)
+	}
+	if w := post("Release Hook", strings.Replace(release, `"create"`, `"delete"`, 1), "gitlab-token"); w.Code != http.StatusOK {
+		t.Errorf("deleted release: status %d", w.Code)
+	}
+	if w := post("Push Hook", `{}`, "gitlab-token"); w.Code != http.StatusOK {
+		t.Errorf("push: status %d", w.Code)
+	}
+	if len(dl.Downloaded) != 1 {
+		t.Errorf("unexpected downloads %v", dl.Downloaded)
+	}
+}
+
+func TestHandleGitea_Release(t *testing.T) {
+	h, dl, _ := newForgeHandler(t, deploy.SourceGitea)
+	release := `{"action":"published","repository":{"full_name":"acme/api"},"release":{"tag_name":"v3.0.0",
+		"assets":[{"name":"api","browser_download_url":"https://gitea.example.com/acme/api/releases/download/v3.0.0/api"}]}}`
+	post := func(eventHeader, sigHeader, body, secret string) int {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		req := httptest.NewRequest("POST", "/gitea", strings.NewReader(body))
+		req.Header.Set(eventHeader, "release")
+		req.Header.Set(sigHeader, hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		h.HandleGitea(w, req)
+		h.Wait()
+		return w.Code
+	}
+
+	if code := post(deploy.GiteaEventHeader, deploy.GiteaSignatureHeader, release, githubSecret); code != http.StatusAccepted {
+		t.Errorf("Gitea: status %d", code)
+	}
+	if code := post(deploy.ForgejoEventHeader, deploy.ForgejoSignatureHeader, strings.ReplaceAll(release, "v3.0.0", "v3.0.1"), githubSecret); code != http.StatusAccepted {
+		t.Errorf("Forgejo: status %d", code)
+	}
+	if code := post(deploy.GiteaEventHeader, deploy.GiteaSignatureHeader, release, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: status %d", code)
+	}
+	if len(dl.Downloaded) != 2 {
+		t.Errorf("downloads %v", dl.Downloaded)
+	}
+}
+
+func TestHTTPDownloader_DownloadFrom(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte("binary"))
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "app")
+	for src, header := range map[deploy.Source][2]string{
+		deploy.SourceGitHub: {"Authorization", "Bearer tok"},
+		deploy.SourceGitLab: {"Private-Token", "tok"},
+		deploy.SourceGitea:  {"Authorization", "token tok"},
+	} {
+		if err := deploy.NewDownloader().DownloadFrom(src, srv.URL, dest, "tok"); err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if v := got.Get(header[0]); v != header[1] {
+			t.Errorf("%s: %s = %q, want %q", src, header[0], v, header[1])
+		}
+	}
+}
+
+func TestValidateBytes_Source(t *testing.T) {
+	data := "version: 2\napps:\n  - name: api\n    executable: api\n    path: /srv\n    source: bitbucket\n"
+	if d := findDiag(deploy.ValidateBytes("deploy.yaml", []byte(data)), "apps[0].source"); d == nil || d.Line != 6 {
+		t.Errorf("expected unknown source on line 6, got %v", d)
+	}
+}
@@ -0,0 +1,137 @@
+package deploy_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+// writeFragments creates deploy.yaml and apps.d files in a temp dir.
+func writeFragments(t *testing.T, main string, fragments map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "deploy.yaml")
+	os.WriteFile(path, []byte(main), 0644)
+	os.MkdirAll(filepath.Join(dir, "apps.d"), 0755)
+	for name, content := range fragments {
+		os.WriteFile(filepath.Join(dir, "apps.d", name), []byte(content), 0644)
+	}
+	return path
+}
+
+func appNames(cfg *deploy.Config) string {
+	var names []string
+	for _, a := range cfg.Apps {
+		names = append(names, a.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestLoad_MergesFragments(t *testing.T) {
+	path := writeFragments(t, "apps:\n  - name: main\n    executable: main\n    path: /srv\n", map[string]string{
+		"20-web.yaml":   "name: web\nexecutable: web\npath: /srv\n",
+		"10-api.yml":    "- name: api\n  executable: api\n  path: /srv\n- name: worker\n  executable: worker\n  path: /srv\n",
+		"notes.txt":     "name: ignored\n",
+		".hidden.yaml":  "name: ignored\n",
+		"30-empty.yaml": "",
+	})
+
+	cfg, err := deploy.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := appNames(cfg); got != "main,api,worker,web" {
+		t.Errorf("unexpected app order %q", got)
+	}
+	if cfg.Apps[3].BusyTimeout != 5*time.Minute {
+		t.Errorf("expected defaults applied to fragment apps, got %v", cfg.Apps[3].BusyTimeout)
+	}
+}
+
+func TestFragments_DuplicateNamePointsAtBothFiles(t *testing.T) {
+	path := writeFragments(t, "version: 2\napps:\n  - name: api\n    executable: api\n    path: /srv\n", map[string]string{
+		"api.yaml": "name: api\nexecutable: api2\npath: /srv\n",
+	})
+	fragment := filepath.Join(filepath.Dir(path), "apps.d", "api.yaml")
+
+	cfg, err := deploy.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), fragment+":1") || !strings.Contains(err.Error(), path+":3") {
+		t.Errorf("expected error naming both files, got %v", err)
+	}
+
+	ds, _ := deploy.ValidateFile(path)
+	d := findDiag(ds, "apps[1].name")
+	if d == nil {
+		t.Fatalf("expected duplicate diagnostic, got %v", ds)
+	}
+	if d.File != fragment || d.Line != 1 || !strings.Contains(d.Message, "also at "+path+":3:11") {
+		t.Errorf("unexpected diagnostic %s", d)
+	}
+}
+
+func TestValidateFile_FragmentUnknownField(t *testing.T) {
+	path := writeFragments(t, "version: 2\napps: []\n", map[string]string{
+		"api.yaml": "name: api\nexecutable: api\npath: /srv\nhealth: x\n",
+	})
+	ds, _ := deploy.ValidateFile(path)
+	d := findDiag(ds, "apps[0].health")
+	if d == nil || !strings.HasSuffix(d.File, "api.yaml") || d.Line != 4 {
+		t.Errorf("expected unknown field in fragment on line 4, got %v", ds)
+	}
+}
+
+func TestConfigWatcher_ReloadsOnlyChangedFragment(t *testing.T) {
+	t.Setenv("DEPLOY_TEST_WEB_PATH", "/srv/web")
+	path := writeFragments(t, "apps: []\n", map[string]string{
+		"api.yaml": "name: api\nexecutable: api\npath: /srv/api\n",
+		"web.yaml": "name: web\nexecutable: web\npath: ${DEPLOY_TEST_WEB_PATH}\n",
+	})
+
+	var mu sync.Mutex
+	var applied *deploy.Config
+	w := &deploy.ConfigWatcher{
+		Path:     path,
+		Interval: 5 * time.Millisecond,
+		Apply: func(c *deploy.Config) error {
+			mu.Lock()
+			defer mu.Unlock()
+			applied = c
+			return nil
+		},
+	}
+	w.Prime()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	// web.yaml is not edited, so its path must not be re-resolved.
+	t.Setenv("DEPLOY_TEST_WEB_PATH", "/changed")
+	os.WriteFile(filepath.Join(filepath.Dir(path), "apps.d", "api.yaml"), []byte("name: api\nexecutable: api\npath: /srv/api2\n"), 0644)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		cfg := applied
+		mu.Unlock()
+		if cfg != nil {
+			if cfg.Apps[0].Path != "/srv/api2" || cfg.Apps[1].Path != "/srv/web" {
+				t.Errorf("unexpected apps after fragment reload: %+v", cfg.Apps)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("fragment edit was not applied")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
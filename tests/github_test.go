@@ -0,0 +1,174 @@
+package deploy_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+const githubSecret = "github-webhook-secret-0123456789abcdef"
+
+// zipDownloader serves every download as a zip archive holding one file.
+type zipDownloader struct{ Name, Content string }
+
+func (d *zipDownloader) Download(url, dest, token string) error {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, _ := zw.Create(d.Name)
+	f.Write([]byte(d.Content))
+	zw.Close()
+	os.MkdirAll(filepath.Dir(dest), 0755)
+	return os.WriteFile(dest, buf.Bytes(), 0644)
+}
+
+func newGitHubHandler(t *testing.T, dl deploy.Downloader) (*deploy.Handler, string) {
+	t.Helper()
+	dir := t.TempDir()
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	app := func(name, exe string) deploy.AppConfig {
+		return deploy.AppConfig{Name: name, Executable: exe, Path: dir, BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond}
+	}
+	api := app("api", "api")
+	api.Asset = "api-linux-*"
+	web := app("web", "web")
+	web.Allow = &deploy.AppAllow{Repos: []string{"acme/web"}}
+	return &deploy.Handler{
+		Config:     &deploy.Config{Updater: deploy.ConfigUpdater{TempDir: dir}, Apps: []deploy.AppConfig{api, web}},
+		Validator:  deploy.NewHMACValidator(githubSecret),
+		Downloader: dl,
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}, dir
+}
+
+// deliver posts a webhook delivery signed the way GitHub signs it.
+func deliver(h *deploy.Handler, event, body, secret string) *httptest.ResponseRecorder {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	req := httptest.NewRequest("POST", "/github", strings.NewReader(body))
+	req.Header.Set(deploy.GitHubEventHeader, event)
+	req.Header.Set(deploy.GitHubSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	h.HandleGitHub(w, req)
+	h.Wait()
+	return w
+}
+
+func TestHandleGitHub_Release(t *testing.T) {
+	dl := NewMockDownloader()
+	h, dir := newGitHubHandler(t, dl)
+
+	release := `{"action":"published","repository":{"full_name":"acme/api"},"release":{"tag_name":"v1.2.0","assets":[
+		{"name":"checksums.txt","url":"https://api.github.com/repos/acme/api/releases/assets/1"},
+		{"name":"api-linux-amd64","url":"https://api.github.com/repos/acme/api/releases/assets/2"},
+		{"name":"web","url":"https://api.github.com/repos/acme/api/releases/assets/3"}]}}`
+	w := deliver(h, "release", release, githubSecret)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var results []deploy.WebhookDeploy
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil || len(results) != 2 {
+		t.Fatalf("unexpected response %v, %v", results, err)
+	}
+	if r := results[0]; r.App != "api" || r.Tag != "v1.2.0" || r.DeployID == "" || r.Error != "" {
+		t.Errorf("api: %+v", r)
+	}
+	if r := results[1]; r.App != "web" || r.DeployID != "" || !strings.Contains(r.Error, "acme/api") {
+		t.Errorf("web should be refused by allow.repos: %+v", r)
+	}
+	if len(dl.Downloaded) != 1 || !strings.HasPrefix(dl.Downloaded[0], "https://api.github.com/repos/acme/api/releases/assets/2 ") {
+		t.Errorf("downloads %v", dl.Downloaded)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "api")); string(got) != "mock downloaded content" {
+		t.Errorf("api not installed: %q", got)
+	}
+
+	for name, tc := range map[string]struct {
+		event, body, secret string
+		code                int
+	}{
+		"ping":           {"ping", `{"zen":"Keep it logically awesome."}`, githubSecret, http.StatusOK},
+		"wrong secret":   {"release", release, "not-the-secret", http.StatusUnauthorized},
+		"edited":         {"release", strings.Replace(release, "published", "edited", 1), githubSecret, http.StatusOK},
+		"no match":       {"release", `{"action":"published","release":{"tag_name":"v1","assets":[{"name":"x"}]}}`, githubSecret, http.StatusOK},
+		"other event":    {"push", `{}`, githubSecret, http.StatusOK},
+		"invalid":        {"release", `{`, githubSecret, http.StatusBadRequest},
+		"only forbidden": {"release", strings.Replace(release, "api-linux-amd64", "api.tar.gz", 1), githubSecret, http.StatusForbidden},
+	} {
+		if w := deliver(h, tc.event, tc.body, tc.secret); w.Code != tc.code {
+			t.Errorf("%s: status %d, want %d: %s", name, w.Code, tc.code, w.Body)
+		}
+	}
+	if len(dl.Downloaded) != 1 {
+		t.Errorf("unexpected downloads %v", dl.Downloaded)
+	}
+}
+
+func TestHandleGitHub_WorkflowRun(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.URL.Path != "/repos/acme/api/actions/runs/42/artifacts" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"artifacts":[
+			{"name":"api-linux-amd64","archive_download_url":"https://api.github.com/artifacts/7/zip"},
+			{"name":"web","archive_download_url":"https://api.github.com/artifacts/8/zip","expired":true}]}`))
+	}))
+	defer api.Close()
+	// The payload's artifacts_url is never fetched: it would receive the token.
+	var foreign int
+	evil := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { foreign++ }))
+	defer evil.Close()
+	h, dir := newGitHubHandler(t, &zipDownloader{Name: "api", Content: "built by CI"})
+	h.Config.Updater.Poll = &deploy.PollConfig{APIURL: api.URL}
+
+	run := `{"action":"completed","repository":{"full_name":"acme/api"},"workflow_run":{"id":42,"name":"build","event":"push",
+		"conclusion":"success","head_sha":"0123456789abcdef","artifacts_url":"` + evil.URL + `/artifacts"}}`
+	w := deliver(h, "workflow_run", run, githubSecret)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"tag":"0123456"`) {
+		t.Errorf("expected the short commit as tag: %s", w.Body)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "api")); string(got) != "built by CI" {
+		t.Errorf("artifact not extracted and installed: %q", got)
+	}
+	if foreign != 0 {
+		t.Errorf("artifacts_url of the payload was requested %d times", foreign)
+	}
+	if w := deliver(h, "workflow_run", strings.Replace(run, `"acme/api"`, `"acme/../evil"`, 1), githubSecret); w.Code != http.StatusBadRequest {
+		t.Errorf("repository outside owner/name: status %d", w.Code)
+	}
+
+	for name, body := range map[string]string{
+		"failed":       strings.Replace(run, `"success"`, `"failure"`, 1),
+		"pull request": strings.Replace(run, `"push"`, `"pull_request"`, 1),
+		"requested":    strings.Replace(run, `"completed"`, `"requested"`, 1),
+	} {
+		if w := deliver(h, "workflow_run", body, githubSecret); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "Ignored") {
+			t.Errorf("%s: status %d: %s", name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestValidateBytes_Asset(t *testing.T) {
+	data := "version: 2\napps:\n  - name: api\n    executable: api\n    path: /srv\n    asset: \"api-[\"\n"
+	if d := findDiag(deploy.ValidateBytes("deploy.yaml", []byte(data)), "apps[0].asset"); d == nil || d.Line != 6 {
+		t.Errorf("expected invalid asset pattern on line 6, got %v", d)
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"time"
 
 	"github.com/tinywasm/deploy"
-	"gopkg.in/yaml.v3"
 )
 
 func TestHandleUpdate_Rollback_CreatesFailedArtifact(t *testing.T) {
@@ -101,7 +100,7 @@ func TestHandleUpdate_Rollback_CreatesFailedArtifact(t *testing.T) {
 	}
 }
 
-func TestHandleUpdate_Success_UpdatesConfig(t *testing.T) {
+func TestHandleUpdate_Success_RecordsVersion(t *testing.T) {
 	// Setup
 	tmpDir := t.TempDir()
 	appDir := filepath.Join(tmpDir, "app")
@@ -165,14 +164,16 @@ apps:
 		t.Errorf("expected 200 OK, got %d. Body: %s", w.Result().StatusCode, w.Body.String())
 	}
 
-	// Verify config.yaml updated
-	updatedConfigBytes, _ := os.ReadFile(configPath)
-	var updatedConfig deploy.Config
-	if err := yaml.Unmarshal(updatedConfigBytes, &updatedConfig); err != nil {
-		t.Fatalf("failed to parse updated config: %v", err)
+	// deploy.yaml is left untouched; the version is recorded in the state file
+	// and overlaid on the next Load.
+	if data, _ := os.ReadFile(configPath); string(data) != initialConfig {
+		t.Errorf("config.yaml was rewritten:\n%s", data)
 	}
-
-	if updatedConfig.Apps[0].Version != "1.1.0" {
-		t.Errorf("expected version 1.1.0, got %s", updatedConfig.Apps[0].Version)
+	reloaded, err := deploy.Load(configPath)
+	if err != nil {
+		t.Fatalf("failed to reload config: %v", err)
+	}
+	if reloaded.Apps[0].Version != "1.1.0" {
+		t.Errorf("expected version 1.1.0, got %s", reloaded.Apps[0].Version)
 	}
 }
@@ -164,3 +164,16 @@ func TestHandleUpdate_InvalidSignature(t *testing.T) {
 		t.Errorf("expected 401 Unauthorized, got %d", w.Result().StatusCode)
 	}
 }
+
+func TestHandleUpdate_RelativeHealthEndpoint(t *testing.T) {
+	h, _, _ := newIdempotencyHandler(t)
+	h.Config.Apps[0].Port = 1200
+	h.Config.Apps[0].HealthEndpoint = "/health"
+	checker := NewMockHealthChecker()
+	checker.Responses["/health"] = &deploy.HealthStatus{Status: "down"}
+	h.Checker = checker
+
+	if w := postUpdate(h, `{"executable":"api","tag":"v1.0.0"}`, ""); w.Code != http.StatusOK {
+		t.Errorf("status %d: %s, want the check on http://localhost:1200/health", w.Code, w.Body)
+	}
+}
@@ -1,10 +1,15 @@
 package deploy_test
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/tinywasm/deploy"
 )
@@ -47,3 +52,121 @@ func TestValidate_InvalidHex(t *testing.T) {
 		t.Fatal("expected error for invalid hex, got nil")
 	}
 }
+
+func TestHMACKeySet_Overlap(t *testing.T) {
+	v := deploy.NewHMACKeySet([]deploy.HMACKey{
+		{ID: "old", Secret: "old-secret", NotAfter: time.Now().Add(time.Hour)},
+		{ID: "expired", Secret: "expired-secret", NotAfter: time.Now().Add(-time.Hour)},
+		{ID: "new", Secret: "new-secret"},
+	})
+	payload := []byte(`{"foo":"bar"}`)
+	sign := func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	if err := v.ValidateKey(payload, sign("old-secret"), ""); err != nil {
+		t.Errorf("old key within overlap: %v", err)
+	}
+	if err := v.ValidateKey(payload, sign("new-secret"), "new"); err != nil {
+		t.Errorf("new key by ID: %v", err)
+	}
+	if err := v.ValidateKey(payload, sign("old-secret"), "new"); err == nil {
+		t.Error("expected mismatch when the key ID names another key")
+	}
+	if err := v.ValidateKey(payload, sign("expired-secret"), ""); err == nil {
+		t.Error("expected expired key to be rejected")
+	}
+	if err := v.ValidateKey(payload, sign("new-secret"), "nope"); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Errorf("expected unknown key error, got %v", err)
+	}
+	if v.Sign(payload) != sign("new-secret") {
+		t.Error("expected Sign to use the newest key")
+	}
+
+	used := map[string]bool{}
+	for _, k := range v.Keys() {
+		used[k.ID] = k.LastUsed != nil
+	}
+	if !used["old"] || !used["new"] || used["expired"] {
+		t.Errorf("unexpected key usage %v", used)
+	}
+}
+
+func TestRotateHMACKey(t *testing.T) {
+	store := NewMockStore()
+	store.Set("DEPLOY_HMAC_SECRET", "legacy-secret")
+
+	key, err := deploy.RotateHMACKey(store, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateHMACKey() error = %v", err)
+	}
+	if current, _ := store.Get("DEPLOY_HMAC_SECRET"); current != key.Secret || len(key.Secret) != 64 {
+		t.Errorf("DEPLOY_HMAC_SECRET = %q, want the new key", current)
+	}
+	keys, err := deploy.LoadHMACKeys(store)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("LoadHMACKeys() = %+v, %v", keys, err)
+	}
+	if keys[0].ID != deploy.DefaultHMACKeyID || keys[0].Secret != "legacy-secret" || keys[0].NotAfter.IsZero() {
+		t.Errorf("expected the legacy key kept with an expiry, got %+v", keys[0])
+	}
+	if keys[1].ID != key.ID || !keys[1].NotAfter.IsZero() {
+		t.Errorf("expected the new key last without expiry, got %+v", keys[1])
+	}
+
+	// A secret set outside rotation (e.g. by the wizard) is still accepted.
+	store.Set("DEPLOY_HMAC_SECRET", "wizard-secret")
+	if keys, _ := deploy.LoadHMACKeys(store); len(keys) != 3 || keys[2].Secret != "wizard-secret" {
+		t.Errorf("expected DEPLOY_HMAC_SECRET appended, got %+v", keys)
+	}
+}
+
+func TestImportHMACKey(t *testing.T) {
+	store := NewMockStore()
+	store.Set("DEPLOY_HMAC_SECRET", "legacy-secret")
+
+	key, err := deploy.ImportHMACKey(store, "ci-2026", "vault-secret", time.Hour)
+	if err != nil {
+		t.Fatalf("ImportHMACKey() error = %v", err)
+	}
+	if current, _ := store.Get("DEPLOY_HMAC_SECRET"); current != "vault-secret" || key.ID != "ci-2026" {
+		t.Errorf("DEPLOY_HMAC_SECRET = %q, key %+v", current, key)
+	}
+	keys, _ := deploy.LoadHMACKeys(store)
+	if len(keys) != 2 || keys[0].NotAfter.IsZero() || keys[1].ID != "ci-2026" {
+		t.Errorf("expected the legacy key to overlap the imported one, got %+v", keys)
+	}
+
+	for _, tc := range [][2]string{{"ci-2026", "other"}, {"again", "vault-secret"}, {"", "x"}, {"bad id", "x"}, {"empty", ""}} {
+		if _, err := deploy.ImportHMACKey(store, tc[0], tc[1], time.Hour); err == nil {
+			t.Errorf("ImportHMACKey(%q, %q) accepted", tc[0], tc[1])
+		}
+	}
+}
+
+func TestCheckHMACRotation(t *testing.T) {
+	old := deploy.HMACKey{ID: "old", Secret: "old-secret", Created: time.Now().Add(-time.Hour)}
+	next := deploy.HMACKey{ID: "new", Secret: "new-secret", Created: time.Now()}
+	validator := deploy.NewHMACKeySet([]deploy.HMACKey{old})
+	handler := &deploy.Handler{Config: &deploy.Config{}, Validator: validator}
+	srv := httptest.NewServer(http.HandlerFunc(handler.HandleStatus))
+	defer srv.Close()
+
+	// The puller has not loaded the new key, and CI still signs with the old one.
+	validator.ValidateRequest([]byte("deploy"), deploy.NewHMACValidator(old.Secret).Sign([]byte("deploy")))
+	r, err := deploy.CheckHMACRotation(context.Background(), srv.URL, []deploy.HMACKey{old, next}, "new")
+	if err != nil {
+		t.Fatalf("CheckHMACRotation() error = %v", err)
+	}
+	if r.Loaded || len(r.InUse) != 1 || r.InUse[0].ID != "old" {
+		t.Errorf("unexpected report %+v", r)
+	}
+
+	validator.SetKeys([]deploy.HMACKey{old, next})
+	r, err = deploy.CheckHMACRotation(context.Background(), srv.URL, []deploy.HMACKey{old, next}, "new")
+	if err != nil || !r.Loaded {
+		t.Errorf("expected the new key loaded, got %+v, %v", r, err)
+	}
+}
@@ -0,0 +1,158 @@
+package deploy_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+func newIdempotencyHandler(t *testing.T) (*deploy.Handler, *MockDownloader, *MockProcessManager) {
+	t.Helper()
+	dir := t.TempDir()
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	dl, pm := NewMockDownloader(), NewMockProcessManager()
+	return &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: dir},
+			Apps: []deploy.AppConfig{{Name: "api", Executable: "api", Path: dir,
+				BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond}},
+		},
+		State:      &deploy.StateFile{Path: filepath.Join(dir, "state.json")},
+		Validator:  deploy.NewHMACValidator("secret"),
+		Downloader: dl,
+		Process:    pm,
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}, dl, pm
+}
+
+func postUpdate(h *deploy.Handler, body, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/update", bytes.NewBufferString(body))
+	req.Header.Set("X-Signature", deploy.NewHMACValidator("secret").Sign([]byte(body)))
+	if key != "" {
+		req.Header.Set(deploy.IdempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	h.HandleUpdate(w, req)
+	return w
+}
+
+func TestHandleUpdate_IdempotencyKey(t *testing.T) {
+	h, dl, pm := newIdempotencyHandler(t)
+	body := `{"executable":"api","tag":"v1.0.0"}`
+
+	first := postUpdate(h, body, "run-1")
+	if first.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", first.Code, first.Body)
+	}
+	retry := postUpdate(h, body, "run-1")
+	if retry.Code != http.StatusOK || retry.Body.String() != "Update successful" || retry.Header().Get(deploy.IdempotentReplayHeader) != "true" {
+		t.Errorf("retry: %d %q %v", retry.Code, retry.Body, retry.Header())
+	}
+	if id := retry.Header().Get("X-Deploy-Id"); id != first.Header().Get("X-Deploy-Id") {
+		t.Errorf("replayed deploy ID %q, want %q", id, first.Header().Get("X-Deploy-Id"))
+	}
+	if w := postUpdate(h, `{"executable":"api","tag":"v2.0.0"}`, "run-1"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another request: status %d", w.Code)
+	}
+	if len(dl.Downloaded) != 1 || len(pm.Stopped) != 1 {
+		t.Errorf("expected one deploy, got downloads %v, stops %v", dl.Downloaded, pm.Stopped)
+	}
+
+	// Server errors are not remembered: a retry deploys again.
+	dl.ShouldFail = true
+	if w := postUpdate(h, `{"executable":"api","tag":"v1.1.0"}`, "run-2"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failed download: status %d", w.Code)
+	}
+	dl.ShouldFail = false
+	if w := postUpdate(h, `{"executable":"api","tag":"v1.1.0"}`, "run-2"); w.Code != http.StatusOK || w.Header().Get(deploy.IdempotentReplayHeader) != "" {
+		t.Errorf("retry after a failure: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestHandleUpdate_InstalledVersion(t *testing.T) {
+	h, dl, pm := newIdempotencyHandler(t)
+	first := postUpdate(h, `{"executable":"api","tag":"v1.0.0"}`, "")
+
+	w := postUpdate(h, `{"executable":"api","tag":"v1.0.0"}`, "")
+	if w.Code != http.StatusOK || w.Body.String() != "Already up to date" {
+		t.Errorf("same tag: %d %q", w.Code, w.Body)
+	}
+	if id := w.Header().Get("X-Deploy-Id"); id != first.Header().Get("X-Deploy-Id") {
+		t.Errorf("deploy ID %q, want the installing deploy %q", id, first.Header().Get("X-Deploy-Id"))
+	}
+	sum := sha256.Sum256([]byte("mock downloaded content"))
+	if w := postUpdate(h, `{"executable":"api","tag":"v1.0.0","digest":"sha256:`+hex.EncodeToString(sum[:])+`"}`, ""); w.Code != http.StatusOK || w.Body.String() != "Already up to date" {
+		t.Errorf("same tag and digest: %d %q", w.Code, w.Body)
+	}
+	if len(dl.Downloaded) != 1 || len(pm.Stopped) != 1 {
+		t.Errorf("process touched: downloads %v, stops %v", dl.Downloaded, pm.Stopped)
+	}
+
+	// Another digest or force redeploy the tag.
+	if w := postUpdate(h, `{"executable":"api","tag":"v1.0.0","digest":"sha256:00"}`, ""); w.Code != http.StatusConflict {
+		t.Errorf("other digest: status %d, want a deploy refused for its digest", w.Code)
+	}
+	if w := postUpdate(h, `{"executable":"api","tag":"v1.0.0","force":true}`, ""); w.Code != http.StatusOK || w.Body.String() != "Update successful" {
+		t.Errorf("forced: %d %q", w.Code, w.Body)
+	}
+	if len(dl.Downloaded) != 3 {
+		t.Errorf("downloads %v", dl.Downloaded)
+	}
+}
+
+// gatedChecker holds every health check until gate is closed.
+type gatedChecker struct{ gate chan struct{} }
+
+func (c gatedChecker) Check(string) (*deploy.HealthStatus, error) {
+	<-c.gate
+	return &deploy.HealthStatus{Status: "ok", CanRestart: true}, nil
+}
+
+func TestHandleGitHub_Redelivery(t *testing.T) {
+	dl := NewMockDownloader()
+	h, _ := newGitHubHandler(t, dl)
+	gate := make(chan struct{})
+	h.Checker = gatedChecker{gate}
+	release := `{"action":"published","repository":{"full_name":"acme/api"},"release":{"tag_name":"v1.2.0","assets":[
+		{"name":"api-linux-amd64","url":"https://api.github.com/repos/acme/api/releases/assets/2"}]}}`
+	post := func(delivery string) []deploy.WebhookDeploy {
+		mac := hmac.New(sha256.New, []byte(githubSecret))
+		mac.Write([]byte(release))
+		req := httptest.NewRequest("POST", "/github", strings.NewReader(release))
+		req.Header.Set(deploy.GitHubEventHeader, "release")
+		req.Header.Set(deploy.GitHubSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		req.Header.Set(deploy.GitHubDeliveryHeader, delivery)
+		w := httptest.NewRecorder()
+		h.HandleGitHub(w, req)
+		var results []deploy.WebhookDeploy
+		json.NewDecoder(w.Body).Decode(&results)
+		return results
+	}
+
+	first := post("d1")
+	again := post("d1") // while the first deploy runs
+	close(gate)
+	h.Wait()
+	if len(first) != 1 || len(again) != 1 || !again[0].Duplicate || again[0].DeployID != first[0].DeployID {
+		t.Errorf("redelivery: %+v, first %+v", again, first)
+	}
+	if later := post("d2"); len(later) != 1 || !later[0].Unchanged || later[0].DeployID != first[0].DeployID {
+		t.Errorf("new delivery of the installed release: %+v", later)
+	}
+	h.Wait()
+	if len(dl.Downloaded) != 1 {
+		t.Errorf("downloads %v", dl.Downloaded)
+	}
+}
@@ -0,0 +1,125 @@
+package deploy_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tinywasm/deploy"
+	"gopkg.in/yaml.v3"
+)
+
+const interpolatedConfig = `
+updater:
+  port: ${DEPLOY_TEST_PORT:-9000}
+apps:
+  - name: api
+    executable: api
+    path: ${DEPLOY_TEST_DIR}/api
+    health_endpoint: http://localhost:${DEPLOY_TEST_APP_PORT:-8081}/health
+    version: v1
+notifications:
+  - type: slack
+    url: secret://SLACK_URL
+    template: "cost: $${amount}"
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadWithStore_Interpolation(t *testing.T) {
+	t.Setenv("DEPLOY_TEST_PORT", "9191")
+	t.Setenv("DEPLOY_TEST_DIR", "/srv")
+	store := NewMockStore()
+	store.Set("SLACK_URL", "https://hooks.example.com/T0/B0/xyz")
+
+	cfg, err := deploy.LoadWithStore(writeConfig(t, interpolatedConfig), store)
+	if err != nil {
+		t.Fatalf("LoadWithStore() error = %v", err)
+	}
+	if cfg.Updater.Port != 9191 {
+		t.Errorf("expected port from env, got %d", cfg.Updater.Port)
+	}
+	app := cfg.Apps[0]
+	if app.Path != "/srv/api" || app.HealthEndpoint != "http://localhost:8081/health" {
+		t.Errorf("unexpected interpolation: path=%q health=%q", app.Path, app.HealthEndpoint)
+	}
+	n := cfg.Notifications[0]
+	if n.URL != "https://hooks.example.com/T0/B0/xyz" || n.Template != "cost: ${amount}" {
+		t.Errorf("unexpected notifier: url=%q template=%q", n.URL, n.Template)
+	}
+	if got := cfg.SecretValues(); len(got) != 1 || got[0] != n.URL {
+		t.Errorf("SecretValues() = %v", got)
+	}
+}
+
+func TestLoadWithStore_UnresolvedReferences(t *testing.T) {
+	os.Unsetenv("DEPLOY_TEST_DIR")
+	_, err := deploy.LoadWithStore(writeConfig(t, interpolatedConfig), NewMockStore())
+	if err == nil {
+		t.Fatal("expected error for unresolved references")
+	}
+	for _, want := range []string{"apps[0].path", "DEPLOY_TEST_DIR", "notifications[0].url", "SLACK_URL"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	t.Setenv("DEPLOY_TEST_DIR", "/srv")
+	if _, err := deploy.Load(writeConfig(t, interpolatedConfig)); err == nil || !strings.Contains(err.Error(), "needs a Store") {
+		t.Errorf("expected Load without store to reject secret references, got %v", err)
+	}
+}
+
+func TestConfigMarshal_KeepsReferences(t *testing.T) {
+	t.Setenv("DEPLOY_TEST_DIR", "/srv")
+	store := NewMockStore()
+	store.Set("SLACK_URL", "https://hooks.example.com/T0/B0/xyz")
+	cfg, err := deploy.LoadWithStore(writeConfig(t, interpolatedConfig), store)
+	if err != nil {
+		t.Fatalf("LoadWithStore() error = %v", err)
+	}
+	cfg.Apps[0].Version = "v2"
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	out := string(data)
+	if strings.Contains(out, "hooks.example.com") {
+		t.Errorf("resolved secret written back:\n%s", out)
+	}
+	for _, want := range []string{"secret://SLACK_URL", "${DEPLOY_TEST_DIR}/api", "${DEPLOY_TEST_PORT:-9000}", "version: v2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in marshalled config:\n%s", want, out)
+		}
+	}
+
+	// The written file loads back to the same values.
+	again, err := deploy.LoadWithStore(writeConfig(t, out), store)
+	if err != nil {
+		t.Fatalf("reload error = %v", err)
+	}
+	if again.Apps[0].Path != "/srv/api" || again.Updater.Port != 9000 {
+		t.Errorf("unexpected reload: %+v", again.Updater)
+	}
+}
+
+func TestValidateBytes_EnvReferences(t *testing.T) {
+	os.Unsetenv("DEPLOY_TEST_DIR")
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(interpolatedConfig))
+	d := findDiag(ds, "apps[0].path")
+	if d == nil || d.Line != 7 || !strings.Contains(d.Message, "DEPLOY_TEST_DIR") {
+		t.Errorf("expected unset variable on line 7, got %v", ds)
+	}
+	if findDiag(ds, "notifications[0].url") != nil {
+		t.Errorf("secret references must not be diagnosed without a store: %v", ds)
+	}
+}
@@ -0,0 +1,168 @@
+package deploy_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+// hookRecorder collects lines sent through a SetLog-style hook.
+type hookRecorder struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (h *hookRecorder) Log(msgs ...any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lines = append(h.lines, strings.TrimSpace(fmt.Sprintln(msgs...)))
+}
+
+func (h *hookRecorder) Joined() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return strings.Join(h.lines, "\n")
+}
+
+func TestNewLogger_LevelFiltersHook(t *testing.T) {
+	rec := &hookRecorder{}
+	log, closer, err := deploy.NewLogger(deploy.ConfigUpdater{LogLevel: "warn"}, rec.Log)
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+	defer closer.Close()
+
+	log.Info("hidden")
+	log.Warn("shown", "app", "api")
+
+	out := rec.Joined()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("info record should be filtered at warn level: %q", out)
+	}
+	if !strings.Contains(out, "[WARN] shown app=api") {
+		t.Errorf("expected warn record with attrs, got %q", out)
+	}
+}
+
+func TestNewLogger_UnknownLevel(t *testing.T) {
+	if _, _, err := deploy.NewLogger(deploy.ConfigUpdater{LogLevel: "verbose"}, nil); err == nil {
+		t.Fatal("expected error for unknown log level")
+	}
+}
+
+func TestNewLogger_JSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "puller.log")
+	log, closer, err := deploy.NewLogger(deploy.ConfigUpdater{
+		LogLevel:  "debug",
+		LogFile:   path,
+		LogFormat: "json",
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+	log.With("deploy_id", "abc").Debug("downloading", "app", "api")
+	closer.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log file: %v", err)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(data), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v (%q)", err, data)
+	}
+	if entry["msg"] != "downloading" || entry["deploy_id"] != "abc" || entry["level"] != "DEBUG" {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+}
+
+func TestRotatingFile_Rotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "puller.log")
+	rf, err := deploy.NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("NewRotatingFile() error = %v", err)
+	}
+	for _, line := range []string{"first...\n", "second..\n", "third...\n", "fourth..\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	rf.Close()
+
+	want := map[string]string{
+		path:        "fourth..\n",
+		path + ".1": "third...\n",
+		path + ".2": "second..\n",
+	}
+	for p, content := range want {
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("read %s: %v", p, err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", p, got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups")
+	}
+}
+
+func TestHandleUpdate_LogsWithDeployID(t *testing.T) {
+	tmpDir := t.TempDir()
+	rec := &hookRecorder{}
+	log, _, _ := deploy.NewLogger(deploy.ConfigUpdater{}, rec.Log)
+
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{{
+				Name:              "app",
+				Executable:        "app.exe",
+				Path:              tmpDir,
+				BusyTimeout:       10 * time.Millisecond,
+				BusyRetryInterval: time.Millisecond,
+			}},
+		},
+		Validator:  deploy.NewHMACValidator("secret"),
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+		Log:        log,
+	}
+
+	payload := []byte(`{"executable":"app.exe","tag":"v1.2.0"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(payload)
+	req := httptest.NewRequest("POST", "/update", bytes.NewReader(payload))
+	req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+
+	handler.HandleUpdate(w, req)
+
+	id := w.Header().Get("X-Deploy-Id")
+	if id == "" {
+		t.Fatal("expected X-Deploy-Id response header")
+	}
+	out := rec.Joined()
+	for _, want := range []string{"deploy started", "deploy completed", "deploy_id=" + id} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in logs:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package deploy_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+func TestMetrics_TextFormat(t *testing.T) {
+	m := deploy.NewMetrics()
+	m.ObserveDeploy("api", deploy.OutcomeSuccess)
+	m.ObserveDeploy("api", deploy.OutcomeSuccess)
+	m.ObserveRollback("api")
+	m.ObserveHMACFailure()
+	m.ObservePhase("api", deploy.PhaseDownload, 700*time.Millisecond)
+	m.SetVersion("api", `v1.0.0"x`)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	out := w.Body.String()
+
+	for _, want := range []string{
+		`deploy_deploys_total{app="api",outcome="success"} 2`,
+		`deploy_rollbacks_total{app="api"} 1`,
+		`deploy_hmac_failures_total 1`,
+		`deploy_phase_duration_seconds_bucket{app="api",phase="download",le="0.5"} 0`,
+		`deploy_phase_duration_seconds_bucket{app="api",phase="download",le="1"} 1`,
+		`deploy_phase_duration_seconds_bucket{app="api",phase="download",le="+Inf"} 1`,
+		`deploy_phase_duration_seconds_count{app="api",phase="download"} 1`,
+		`deploy_app_version_info{app="api",version="v1.0.0\"x"} 1`,
+		`# TYPE deploy_phase_duration_seconds histogram`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+}
+
+func TestHandleUpdate_RecordsMetrics(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "app.exe"), []byte("old"), 0755)
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+
+	checker := NewMockHealthChecker()
+	checker.QueueResponses["http://localhost/health"] = []*deploy.HealthStatus{
+		{Status: "ok", CanRestart: true},
+		nil, // post-deploy health check fails
+	}
+	metrics := deploy.NewMetrics()
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{{
+				Name:              "app",
+				Executable:        "app.exe",
+				Path:              tmpDir,
+				HealthEndpoint:    "http://localhost/health",
+				BusyTimeout:       10 * time.Millisecond,
+				BusyRetryInterval: time.Millisecond,
+			}},
+		},
+		Validator:  deploy.NewHMACValidator("secret"),
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    checker,
+		Keys:       keys,
+		Metrics:    metrics,
+	}
+
+	// Bad signature first.
+	bad := httptest.NewRequest("POST", "/update", bytes.NewReader([]byte("{}")))
+	bad.Header.Set("X-Signature", "sha256=00")
+	handler.HandleUpdate(httptest.NewRecorder(), bad)
+
+	payload := []byte(`{"executable":"app.exe"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(payload)
+	req := httptest.NewRequest("POST", "/update", bytes.NewReader(payload))
+	req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	handler.HandleUpdate(httptest.NewRecorder(), req)
+
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		`deploy_hmac_failures_total 1`,
+		`deploy_deploys_total{app="app",outcome="health_failed"} 1`,
+		`deploy_rollbacks_total{app="app"} 1`,
+		`deploy_phase_duration_seconds_count{app="app",phase="download"} 1`,
+		`deploy_phase_duration_seconds_count{app="app",phase="health_check"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package deploy_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tinywasm/deploy"
+)
+
+const legacyConfig = `# Production puller
+updater:
+  port: 8080
+
+apps:
+  - name: api
+    version: "1.2.3" # set by deploys
+    executable: api
+    path: /srv/api
+  - name: web
+    version: "0.9.0"
+    executable: web
+    path: /srv/web
+`
+
+func TestMigrateConfig_V1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	os.WriteFile(path, []byte(legacyConfig), 0644)
+
+	// A version already in the state file wins over the one in deploy.yaml.
+	(&deploy.StateFile{Path: deploy.StatePath(path)}).Update(func(s *deploy.State) error {
+		s.Apps["web"] = deploy.AppState{Version: "1.0.0"}
+		return nil
+	})
+
+	hook := &hookRecorder{}
+	logger, _, _ := deploy.NewLogger(deploy.ConfigUpdater{}, hook.Log)
+	migrated, err := deploy.MigrateConfig(path, logger)
+	if err != nil || !migrated {
+		t.Fatalf("MigrateConfig() = %v, %v", migrated, err)
+	}
+
+	data, _ := os.ReadFile(path)
+	out := string(data)
+	if !strings.HasPrefix(out, "# Production puller\nversion: 2\n") {
+		t.Errorf("expected version key after the leading comment:\n%s", out)
+	}
+	if strings.Contains(out, "1.2.3") || strings.Contains(out, "0.9.0") {
+		t.Errorf("app versions left in deploy.yaml:\n%s", out)
+	}
+	if backup, _ := os.ReadFile(path + ".v1.bak"); string(backup) != legacyConfig {
+		t.Errorf("backup does not hold the original:\n%s", backup)
+	}
+	if !strings.Contains(hook.Joined(), "moved api version 1.2.3") {
+		t.Errorf("expected migration to log its changes, got:\n%s", hook.Joined())
+	}
+
+	cfg, err := deploy.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.SchemaVersion != deploy.ConfigVersion || cfg.Apps[0].Version != "1.2.3" || cfg.Apps[1].Version != "1.0.0" {
+		t.Errorf("unexpected migrated config: version=%d apps=%+v", cfg.SchemaVersion, cfg.Apps)
+	}
+
+	// Running again is a no-op.
+	if migrated, err := deploy.MigrateConfig(path, nil); err != nil || migrated {
+		t.Errorf("second MigrateConfig() = %v, %v", migrated, err)
+	}
+}
+
+func TestMigrateConfig_RejectsNewer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	os.WriteFile(path, []byte("version: 99\napps: []\n"), 0644)
+	if _, err := deploy.MigrateConfig(path, nil); err == nil {
+		t.Error("expected error for a config from a newer puller")
+	}
+}
+
+func TestMigrateStore(t *testing.T) {
+	store := NewMockStore()
+	store.Set("DEPLOY_METHOD", "edgeworker")
+	store.Set("CF_PROJECT", "site")
+	store.Set("CF_PAGES_TOKEN", "tok")
+
+	if err := deploy.MigrateStore(store, nil); err != nil {
+		t.Fatalf("MigrateStore() error = %v", err)
+	}
+	for key, want := range map[string]string{
+		"DEPLOY_METHOD":        "cloudflarePages",
+		"goflare/site":         "tok",
+		"CF_PAGES_TOKEN":       "",
+		"DEPLOY_STORE_VERSION": "3",
+	} {
+		if got, _ := store.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	// Migrated stores are left alone.
+	store.Set("DEPLOY_METHOD", "cloudflare")
+	deploy.MigrateStore(store, nil)
+	if got, _ := store.Get("DEPLOY_METHOD"); got != "cloudflare" {
+		t.Errorf("migration re-ran on an up-to-date store")
+	}
+}
+
+func TestMigrateStore_PagesTokenWithoutProject(t *testing.T) {
+	store := NewMockStore()
+	store.Set("DEPLOY_METHOD", "webhook")
+	store.Set("CF_PAGES_TOKEN", "tok")
+
+	// The puller still starts; the token waits for CF_PROJECT.
+	if err := deploy.MigrateStore(store, nil); err != nil {
+		t.Fatalf("MigrateStore() error = %v", err)
+	}
+	if got, _ := store.Get("CF_PAGES_TOKEN"); got != "tok" {
+		t.Errorf("CF_PAGES_TOKEN = %q, want it kept", got)
+	}
+	store.Set("CF_PROJECT", "site")
+	if err := deploy.MigrateStore(store, nil); err != nil {
+		t.Fatalf("MigrateStore() error = %v", err)
+	}
+	if got, _ := store.Get("goflare/site"); got != "tok" {
+		t.Errorf("goflare/site = %q after CF_PROJECT was set", got)
+	}
+}
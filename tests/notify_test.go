@@ -0,0 +1,238 @@
+package deploy_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+// captureServer records the JSON bodies posted to it.
+type captureServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []map[string]any
+}
+
+func newCaptureServer(t *testing.T) *captureServer {
+	c := &captureServer{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		c.mu.Lock()
+		c.bodies = append(c.bodies, body)
+		c.mu.Unlock()
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *captureServer) Bodies() []map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]map[string]any(nil), c.bodies...)
+}
+
+func TestNotifications_RoutingAndFormats(t *testing.T) {
+	generic := newCaptureServer(t)
+	slack := newCaptureServer(t)
+	discord := newCaptureServer(t)
+
+	n, err := deploy.NewNotifications([]deploy.NotifierConfig{
+		{Name: "generic", Type: "webhook", URL: generic.URL},
+		{Name: "slack", Type: "slack", URL: slack.URL, Events: []string{deploy.NotifyFailed}},
+		{Name: "discord", Type: "discord", URL: discord.URL, Apps: []string{"web"},
+			Template: "{{.App}} is {{.Kind}}"},
+	}, NewMockStore())
+	if err != nil {
+		t.Fatalf("NewNotifications() error = %v", err)
+	}
+
+	n.Emit(deploy.Notification{Kind: deploy.NotifyStarted, App: "api", Tag: "v1"})
+	n.Emit(deploy.Notification{Kind: deploy.NotifyFailed, App: "api", Tag: "v1", Error: "boom"})
+	n.Emit(deploy.Notification{Kind: deploy.NotifySucceeded, App: "web"})
+	n.Wait()
+
+	if got := len(generic.Bodies()); got != 3 {
+		t.Errorf("generic webhook: expected 3 events, got %d", got)
+	}
+	sb := slack.Bodies()
+	if len(sb) != 1 || sb[0]["text"] != "[api] deploy failed v1: boom" {
+		t.Errorf("slack: unexpected bodies %v", sb)
+	}
+	db := discord.Bodies()
+	if len(db) != 1 || db[0]["content"] != "web is succeeded" {
+		t.Errorf("discord: unexpected bodies %v", db)
+	}
+}
+
+func TestNotifications_InvalidConfig(t *testing.T) {
+	cases := []deploy.NotifierConfig{
+		{Type: "pager"},
+		{Type: "slack"},
+		{Type: "email", SMTPHost: "localhost:25"},
+		{Type: "webhook", URL: "http://x", Template: "{{.Broken"},
+	}
+	for _, c := range cases {
+		if _, err := deploy.NewNotifications([]deploy.NotifierConfig{c}, NewMockStore()); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+}
+
+// fakeSMTP is a minimal SMTP server that captures the DATA section.
+func fakeSMTP(t *testing.T) (addr string, messages <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		io.WriteString(conn, "220 localhost ESMTP\r\n")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					out <- data.String()
+					io.WriteString(conn, "250 OK\r\n")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				io.WriteString(conn, "250 localhost\r\n")
+			case strings.HasPrefix(cmd, "DATA"):
+				inData = true
+				io.WriteString(conn, "354 go ahead\r\n")
+			case strings.HasPrefix(cmd, "QUIT"):
+				io.WriteString(conn, "221 bye\r\n")
+				return
+			default:
+				io.WriteString(conn, "250 OK\r\n")
+			}
+		}
+	}()
+	return ln.Addr().String(), out
+}
+
+func TestNotifications_Email(t *testing.T) {
+	addr, messages := fakeSMTP(t)
+	n, err := deploy.NewNotifications([]deploy.NotifierConfig{{
+		Type:     "email",
+		SMTPHost: addr,
+		From:     "deploy@example.com",
+		To:       []string{"ops@example.com"},
+	}}, NewMockStore())
+	if err != nil {
+		t.Fatalf("NewNotifications() error = %v", err)
+	}
+
+	n.Emit(deploy.Notification{Kind: deploy.NotifyRolledBack, App: "api", Tag: "v3"})
+	n.Wait()
+
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "Subject: [api] deploy rolled_back v3") {
+			t.Errorf("unexpected message:\n%s", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no email received")
+	}
+}
+
+type recordingNotifier struct {
+	mu    sync.Mutex
+	kinds []string
+}
+
+func (r *recordingNotifier) Notify(n deploy.Notification, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.kinds = append(r.kinds, n.Kind)
+	return nil
+}
+
+func TestHandleUpdate_EmitsNotifications(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "app.exe"), []byte("old"), 0755)
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+
+	checker := NewMockHealthChecker()
+	checker.QueueResponses["http://localhost/health"] = []*deploy.HealthStatus{
+		{Status: "ok", CanRestart: true},
+		nil,
+	}
+	rec := &recordingNotifier{}
+	notify := &deploy.Notifications{}
+	notify.Add("rec", rec, nil, nil, nil)
+
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{{
+				Name:              "app",
+				Executable:        "app.exe",
+				Path:              tmpDir,
+				HealthEndpoint:    "http://localhost/health",
+				BusyTimeout:       10 * time.Millisecond,
+				BusyRetryInterval: time.Millisecond,
+			}},
+		},
+		Validator:  deploy.NewHMACValidator("secret"),
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    checker,
+		Keys:       keys,
+	}
+	handler.Subscribe(notify)
+
+	payload := []byte(`{"executable":"app.exe"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(payload)
+	req := httptest.NewRequest("POST", "/update", bytes.NewReader(payload))
+	req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	handler.HandleUpdate(httptest.NewRecorder(), req)
+	notify.Wait()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	got := strings.Join(rec.kinds, ",")
+	for _, want := range []string{deploy.NotifyStarted, deploy.NotifyRolledBack, deploy.NotifyFailed} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %s notification, got %s", want, got)
+		}
+	}
+	if strings.Contains(got, deploy.NotifySucceeded) {
+		t.Errorf("unexpected succeeded notification: %s", got)
+	}
+}
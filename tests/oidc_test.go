@@ -0,0 +1,225 @@
+package deploy_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+// jwksServer stands in for the GitHub Actions token issuer.
+type jwksServer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	kid     string
+	fetches atomic.Int32
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &jwksServer{key: key, kid: "k1"}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": s.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// token signs claims, filling iss, aud and exp unless given.
+func (s *jwksServer) token(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	full := map[string]any{"iss": s.URL, "aud": "deploy.test", "exp": time.Now().Add(5 * time.Minute).Unix()}
+	for k, v := range claims {
+		full[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": s.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(full)
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (s *jwksServer) verifier() *deploy.OIDCVerifier {
+	return deploy.NewOIDCVerifier(deploy.OIDCConfig{Issuer: s.URL, Audience: "deploy.test", JWKSURL: s.URL})
+}
+
+func TestOIDCVerifier_Verify(t *testing.T) {
+	issuer := newJWKSServer(t)
+	v := issuer.verifier()
+
+	claims, err := v.Verify(context.Background(), issuer.token(t, map[string]any{"repository": "acme/api", "ref": "refs/tags/v1.0.0"}))
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if claims.Repository != "acme/api" || claims.Ref != "refs/tags/v1.0.0" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	for name, tok := range map[string]string{
+		"expired":        issuer.token(t, map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}),
+		"wrong audience": issuer.token(t, map[string]any{"aud": []string{"other"}}),
+		"wrong issuer":   issuer.token(t, map[string]any{"iss": "https://evil.example.com"}),
+		"tampered":       strings.Replace(issuer.token(t, nil), ".", ".e30", 1),
+		"malformed":      "not-a-jwt",
+	} {
+		if _, err := v.Verify(context.Background(), tok); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if n := issuer.fetches.Load(); n != 1 {
+		t.Errorf("expected the JWKS to be cached, fetched %d times", n)
+	}
+
+	// Tokens naming an unknown key refetch the JWKS at most once a minute.
+	issuer.kid = "k2"
+	if _, err := v.Verify(context.Background(), issuer.token(t, nil)); err == nil {
+		t.Error("expected unknown key within the refetch interval")
+	}
+}
+
+func TestOIDCVerifier_SlowJWKS(t *testing.T) {
+	issuer := newJWKSServer(t)
+	gate := make(chan struct{})
+	var open sync.Once
+	release := func() { open.Do(func() { close(gate) }) }
+	time.AfterFunc(2*time.Second, release) // fail rather than hang if callers serialize
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-gate
+		issuer.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+	v := deploy.NewOIDCVerifier(deploy.OIDCConfig{Issuer: issuer.URL, Audience: "deploy.test", JWKSURL: slow.URL})
+	token := issuer.token(t, nil)
+
+	// Callers share one download, and a caller giving up is not held back by it.
+	errs := make(chan error, 3)
+	for range 3 {
+		go func() {
+			_, err := v.Verify(context.Background(), token)
+			errs <- err
+		}()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := v.Verify(ctx, token); err == nil || time.Since(start) > time.Second {
+		t.Errorf("Verify() with a deadline during a slow download: %v after %v", err, time.Since(start))
+	}
+	release()
+	for range 3 {
+		if err := <-errs; err != nil {
+			t.Errorf("Verify() error = %v", err)
+		}
+	}
+	if n := issuer.fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+
+	// Random key IDs do not trigger more downloads.
+	for i := range 5 {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"random-` + string(rune('a'+i)) + `"}`))
+		if _, err := v.Verify(context.Background(), header+".e30.c2ln"); err == nil {
+			t.Error("expected unknown key")
+		}
+	}
+	if n := issuer.fetches.Load(); n != 1 {
+		t.Errorf("unknown keys fetched the JWKS %d times, want 1", n)
+	}
+}
+
+func TestHandleUpdate_OIDC(t *testing.T) {
+	issuer := newJWKSServer(t)
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "api.exe"), []byte("old"), 0755)
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{
+				{Name: "api", Executable: "api.exe", Path: tmpDir, BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond,
+					OIDC: &deploy.OIDCClaims{Repository: "acme/api", Ref: "refs/tags/v*"}},
+				{Name: "web", Executable: "web.exe", Path: tmpDir, BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond},
+			},
+		},
+		OIDC:       issuer.verifier(),
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}
+
+	update := func(exe string, claims map[string]any) int {
+		body := `{"executable":"` + exe + `","tag":"v1.0.0"}`
+		req := httptest.NewRequest("POST", "/update", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+issuer.token(t, claims))
+		w := httptest.NewRecorder()
+		handler.HandleUpdate(w, req)
+		return w.Code
+	}
+
+	release := map[string]any{"repository": "acme/api", "ref": "refs/tags/v1.0.0"}
+	if code := update("api.exe", release); code != http.StatusOK {
+		t.Errorf("authorized token: status %d", code)
+	}
+	if code := update("api.exe", map[string]any{"repository": "acme/api", "ref": "refs/heads/feature"}); code != http.StatusForbidden {
+		t.Errorf("wrong ref: status %d, want 403", code)
+	}
+	if code := update("api.exe", map[string]any{"repository": "mallory/api", "ref": "refs/tags/v1.0.0"}); code != http.StatusForbidden {
+		t.Errorf("wrong repository: status %d, want 403", code)
+	}
+	if code := update("web.exe", release); code != http.StatusForbidden {
+		t.Errorf("app without oidc claims: status %d, want 403", code)
+	}
+
+	// Without an HMAC secret, signed requests are rejected.
+	req := httptest.NewRequest("POST", "/update", bytes.NewBufferString(`{"executable":"api.exe"}`))
+	req.Header.Set("X-Signature", "sha256=00")
+	w := httptest.NewRecorder()
+	handler.HandleUpdate(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("signed request without HMAC secret: status %d, want 401", w.Code)
+	}
+}
+
+func TestValidateBytes_OIDC(t *testing.T) {
+	data := "version: 2\nupdater:\n  oidc:\n    issuer: https://token.actions.githubusercontent.com\napps:\n  - name: api\n    executable: api\n    path: /srv\n    oidc:\n      ref: refs/tags/v*\n"
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(data))
+	if d := findDiag(ds, "updater.oidc.audience"); d == nil || d.Severity != deploy.SeverityError {
+		t.Errorf("expected missing audience error, got %v", ds)
+	}
+	if d := findDiag(ds, "apps[0].oidc.repository"); d == nil || d.Line != 10 {
+		t.Errorf("expected missing repository error at the oidc block, got %v", ds)
+	}
+}
@@ -0,0 +1,206 @@
+package deploy_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+// releaseAPI stands in for the GitHub releases API of acme/api.
+type releaseAPI struct {
+	*httptest.Server
+	mu       sync.Mutex
+	releases []string // JSON objects, newest first
+	requests int
+	notMod   int
+	limited  bool
+}
+
+func newReleaseAPI(t *testing.T) *releaseAPI {
+	t.Helper()
+	api := &releaseAPI{}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		api.requests++
+		if r.URL.Path != "/repos/acme/api/releases" || r.Header.Get("Authorization") != "Bearer token" {
+			http.NotFound(w, r)
+			return
+		}
+		if api.limited {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Hour).Unix()))
+			http.Error(w, "API rate limit exceeded", http.StatusForbidden)
+			return
+		}
+		etag := fmt.Sprintf(`"%d"`, len(api.releases))
+		if r.Header.Get("If-None-Match") == etag {
+			api.notMod++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte("[" + strings.Join(api.releases, ",") + "]"))
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+// publish adds a release with an asset named api, newest first.
+func (api *releaseAPI) publish(tag string, prerelease bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	rel := fmt.Sprintf(`{"tag_name":%q,"prerelease":%t,"assets":[{"name":"api","url":"%s/assets/%s"}]}`, tag, prerelease, api.URL, tag)
+	api.releases = append([]string{rel}, api.releases...)
+}
+
+func newPoller(t *testing.T, api *releaseAPI, dl *MockDownloader) *deploy.Poller {
+	t.Helper()
+	dir := t.TempDir()
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	return &deploy.Poller{Handler: &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: dir, Poll: &deploy.PollConfig{APIURL: api.URL}},
+			Apps: []deploy.AppConfig{
+				{Name: "api", Executable: "api", Path: dir, Repo: "acme/api", BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond,
+					Allow: &deploy.AppAllow{Tags: []string{"v*"}}},
+				{Name: "web", Executable: "web", Path: dir}, // no repo: not polled
+			},
+		},
+		Downloader: dl,
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}}
+}
+
+func TestPoller_Poll(t *testing.T) {
+	api := newReleaseAPI(t)
+	api.publish("v1.1.0", false)
+	api.publish("nightly", false)
+	api.publish("v1.2.0", false)
+	api.publish("v1.3.0-rc1", true)
+	dl := NewMockDownloader()
+	p := newPoller(t, api, dl)
+	ctx := context.Background()
+
+	if ids := p.Poll(ctx); len(ids) != 1 {
+		t.Fatalf("expected one deploy, got %v", ids)
+	}
+	if len(dl.Downloaded) != 1 || !strings.HasPrefix(dl.Downloaded[0], api.URL+"/assets/v1.2.0 ") {
+		t.Errorf("expected the newest stable release allowed, got %v", dl.Downloaded)
+	}
+
+	// Unchanged list: conditional request, nothing deployed.
+	if ids := p.Poll(ctx); len(ids) != 0 {
+		t.Errorf("redeployed %v", ids)
+	}
+	if api.notMod != 1 {
+		t.Errorf("expected a 304 for the unchanged list, got %d", api.notMod)
+	}
+
+	api.publish("v1.4.0", false)
+	if ids := p.Poll(ctx); len(ids) != 1 || !strings.HasPrefix(dl.Downloaded[1], api.URL+"/assets/v1.4.0 ") {
+		t.Errorf("expected v1.4.0 to be deployed, got %v", dl.Downloaded)
+	}
+}
+
+func TestPoller_FailedReleaseNotRetried(t *testing.T) {
+	api := newReleaseAPI(t)
+	api.publish("v1.0.0", false)
+	dl := NewMockDownloader()
+	dl.ShouldFail = true
+	p := newPoller(t, api, dl)
+	ctx := context.Background()
+
+	p.Poll(ctx)
+	if ids := p.Poll(ctx); len(ids) != 0 {
+		t.Errorf("failed release retried: %v", ids)
+	}
+	dl.ShouldFail = false
+	api.publish("v1.0.1", false)
+	if ids := p.Poll(ctx); len(ids) != 1 {
+		t.Errorf("expected the newer release to be deployed, got %v", ids)
+	}
+}
+
+func TestPoller_RateLimit(t *testing.T) {
+	api := newReleaseAPI(t)
+	api.publish("v1.0.0", false)
+	api.limited = true
+	p := newPoller(t, api, NewMockDownloader())
+	ctx := context.Background()
+
+	p.Poll(ctx)
+	api.limited = false
+	if ids := p.Poll(ctx); len(ids) != 0 {
+		t.Errorf("deployed while rate limited: %v", ids)
+	}
+	if api.requests != 1 {
+		t.Errorf("expected no request until the limit resets, got %d", api.requests)
+	}
+}
+
+func TestPoller_GitLab(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+		w.Write([]byte(`[{"tag_name":"v2.0.0","upcoming_release":true,"assets":{"links":[{"name":"api","direct_asset_url":"https://gitlab.example.com/next"}]}},
+			{"tag_name":"v1.0.0","assets":{"links":[{"name":"api","url":"https://gitlab.example.com/v1"}]}}]`))
+	}))
+	defer srv.Close()
+	dl := NewMockDownloader()
+	p := newPoller(t, &releaseAPI{Server: srv}, dl)
+	cfg := p.Handler.Config
+	cfg.Apps[0].Source, cfg.Apps[0].Repo = string(deploy.SourceGitLab), "group/sub/api"
+	p.Handler.Keys.Set(deploy.SourceGitLab.TokenKey(), "gl-token")
+
+	p.Poll(context.Background())
+	if path != "/projects/group%2Fsub%2Fapi/releases" {
+		t.Errorf("unexpected API path %q", path)
+	}
+	if len(dl.Downloaded) != 1 || !strings.HasPrefix(dl.Downloaded[0], "https://gitlab.example.com/v1 ") {
+		t.Errorf("expected the released v1.0.0, got %v", dl.Downloaded)
+	}
+}
+
+func TestPoller_RepoStaysInPath(t *testing.T) {
+	api := newReleaseAPI(t)
+	api.publish("v1.0.0", false)
+	dl := NewMockDownloader()
+	p := newPoller(t, api, dl)
+	for _, repo := range []string{"acme/api?x=", "acme/api#", "acme/../acme/api", "acme/api/releases/1"} {
+		p.Handler.Config.Apps[0].Repo = repo
+		if ids := p.Poll(context.Background()); len(ids) != 0 {
+			t.Errorf("repo %q deployed %v", repo, ids)
+		}
+	}
+	if api.requests != 0 {
+		t.Errorf("%d requests for invalid repos", api.requests)
+	}
+
+	data := "version: 2\napps:\n  - name: api\n    executable: api\n    path: /srv\n    repo: acme/api?x=\n" +
+		"  - name: lab\n    executable: lab\n    path: /srv\n    repo: group/sub/lab\n    source: gitlab\n"
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(data))
+	if findDiag(ds, "apps[0].repo") == nil || findDiag(ds, "apps[1].repo") != nil {
+		t.Errorf("unexpected repo diagnostics %v", ds)
+	}
+}
+
+func TestValidateBytes_Poll(t *testing.T) {
+	data := "version: 2\nupdater:\n  poll:\n    interval: -1m\napps:\n  - name: api\n    executable: api\n    path: /srv\n    repo: api\n  - name: web\n    executable: web\n    path: /srv\n    repo: acme/web\n    source: gitea\n"
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(data))
+	for _, want := range []string{"updater.poll.interval", "apps[0].repo", "apps[1].repo"} {
+		if findDiag(ds, want) == nil {
+			t.Errorf("expected a diagnostic for %s, got %v", want, ds)
+		}
+	}
+}
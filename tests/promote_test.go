@@ -0,0 +1,161 @@
+package deploy_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+// contentDownloader writes Content to every destination.
+type contentDownloader struct{ Content string }
+
+func (d *contentDownloader) Download(url, dest, token string) error {
+	os.MkdirAll(filepath.Dir(dest), 0755)
+	return os.WriteFile(dest, []byte(d.Content), 0644)
+}
+
+// startPuller serves the update and status endpoints of a puller for one
+// environment and registers it in cfg and keys.
+func startPuller(t *testing.T, cfg *deploy.Config, keys deploy.Store, env string, dl deploy.Downloader) *deploy.Handler {
+	t.Helper()
+	dir := t.TempDir()
+	h := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: dir},
+			Apps: []deploy.AppConfig{{
+				Name:              "api",
+				Executable:        "api.exe",
+				Path:              dir,
+				BusyTimeout:       10 * time.Millisecond,
+				BusyRetryInterval: time.Millisecond,
+			}},
+		},
+		Validator:  deploy.NewHMACValidator(env + "-secret"),
+		Downloader: dl,
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+		State:      &deploy.StateFile{Path: filepath.Join(dir, "deploy.state.json")},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/update", h.HandleUpdate)
+	mux.HandleFunc("/status", h.HandleStatus)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	cfg.Environments[env] = deploy.Environment{Host: strings.TrimPrefix(srv.URL, "http://")}
+	keys.Set(deploy.EnvKey(env, "DEPLOY_HMAC_SECRET"), env+"-secret")
+	return h
+}
+
+func deployTo(t *testing.T, h *deploy.Handler, secret, body string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/update", bytes.NewBufferString(body))
+	req.Header.Set("X-Signature", deploy.NewHMACValidator(secret).Sign([]byte(body)))
+	w := httptest.NewRecorder()
+	h.HandleUpdate(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("deploy failed: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPromote_SameDigest(t *testing.T) {
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	cfg := &deploy.Config{Environments: map[string]deploy.Environment{}}
+	staging := startPuller(t, cfg, keys, "staging", &contentDownloader{Content: "build-42"})
+	production := startPuller(t, cfg, keys, "production", &contentDownloader{Content: "build-42"})
+
+	deployTo(t, staging, "staging-secret", `{"executable":"api.exe","tag":"v1.2.0","download_url":"https://example.com/api"}`)
+	src, _ := staging.State.Read()
+
+	res, err := deploy.Promote(context.Background(), cfg, keys, "api", "staging", "production")
+	if err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	if res.Unchanged || res.DeployID == "" || res.Version != "v1.2.0" {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	st, _ := production.State.Read()
+	got := st.Apps["api"]
+	if got.Digest != src.Apps["api"].Digest || !strings.HasPrefix(got.Digest, "sha256:") || got.Version != "v1.2.0" {
+		t.Errorf("production runs %+v, staging ran %+v", got, src.Apps["api"])
+	}
+	if len(got.PromotedFrom) != 1 || got.PromotedFrom[0].Env != "staging" || got.PromotedFrom[0].DeployID != src.Apps["api"].DeployID {
+		t.Errorf("unexpected promotion chain %+v", got.PromotedFrom)
+	}
+	if len(got.History) != 1 || got.History[0].DeployID != res.DeployID {
+		t.Errorf("expected promotion in history, got %+v", got.History)
+	}
+
+	// Promoting the same artifact again is a no-op.
+	res, err = deploy.Promote(context.Background(), cfg, keys, "api", "staging", "production")
+	if err != nil || !res.Unchanged {
+		t.Errorf("second Promote() = %+v, %v", res, err)
+	}
+}
+
+func TestPromote_RefusesDifferentDigest(t *testing.T) {
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	cfg := &deploy.Config{Environments: map[string]deploy.Environment{}}
+	staging := startPuller(t, cfg, keys, "staging", &contentDownloader{Content: "build-42"})
+	production := startPuller(t, cfg, keys, "production", &contentDownloader{Content: "rebuilt"})
+
+	deployTo(t, staging, "staging-secret", `{"executable":"api.exe","tag":"v1.2.0","download_url":"https://example.com/api"}`)
+
+	_, err := deploy.Promote(context.Background(), cfg, keys, "api", "staging", "production")
+	if err == nil || !strings.Contains(err.Error(), "409") {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+	if st, _ := production.State.Read(); len(st.Apps) != 0 {
+		t.Errorf("refused promotion recorded state: %+v", st.Apps)
+	}
+	if _, err := os.Stat(filepath.Join(production.Config.Apps[0].Path, "api.exe")); err == nil {
+		t.Error("refused artifact was installed")
+	}
+}
+
+func TestPromote_RequiresRecordedArtifact(t *testing.T) {
+	keys := NewMockStore()
+	cfg := &deploy.Config{Environments: map[string]deploy.Environment{}}
+	startPuller(t, cfg, keys, "staging", &contentDownloader{})
+	startPuller(t, cfg, keys, "production", &contentDownloader{})
+
+	if _, err := deploy.Promote(context.Background(), cfg, keys, "api", "staging", "production"); err == nil || !strings.Contains(err.Error(), "no recorded artifact") {
+		t.Errorf("expected missing artifact error, got %v", err)
+	}
+	if _, err := deploy.Promote(context.Background(), cfg, keys, "api", "staging", "qa"); err == nil {
+		t.Error("expected unknown environment error")
+	}
+}
+
+func TestPromote_AllowRules(t *testing.T) {
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	cfg := &deploy.Config{Environments: map[string]deploy.Environment{}}
+	staging := startPuller(t, cfg, keys, "staging", &contentDownloader{Content: "build-42"})
+	production := startPuller(t, cfg, keys, "production", &contentDownloader{Content: "build-42"})
+	production.Config.Apps[0].Allow = &deploy.AppAllow{Repos: []string{"acme/api"}, Secret: true}
+	keys.Set(deploy.AppSecretKey("api"), "api-secret")
+	keys.Set(deploy.EnvKey("production", deploy.AppSecretKey("api")), "api-secret")
+
+	deployTo(t, staging, "staging-secret", `{"repo":"acme/api","executable":"api.exe","tag":"v1.2.0","download_url":"https://example.com/api"}`)
+	res, err := deploy.Promote(context.Background(), cfg, keys, "api", "staging", "production")
+	if err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	st, _ := production.State.Read()
+	if got := st.Apps["api"]; got.DeployID != res.DeployID || got.Repo != "acme/api" {
+		t.Errorf("production runs %+v", got)
+	}
+}
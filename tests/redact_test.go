@@ -0,0 +1,145 @@
+package deploy_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+	"github.com/zalando/go-keyring"
+)
+
+func TestRedactor_MasksLongestFirst(t *testing.T) {
+	r := deploy.NewRedactor()
+	r.Add("abcd", "abcdefgh", "", "xy")
+
+	got := r.Redact("token=abcdefgh short=abcd xy")
+	want := "token=[REDACTED] short=[REDACTED] xy"
+	if got != want {
+		t.Errorf("Redact() = %q, want %q", got, want)
+	}
+
+	err := r.Error(fmt.Errorf("auth failed for abcdefgh"))
+	if strings.Contains(err.Error(), "abcdefgh") {
+		t.Errorf("error not redacted: %v", err)
+	}
+}
+
+func TestRedactor_Handler(t *testing.T) {
+	r := deploy.NewRedactor()
+	r.Add("s3cr3t-value")
+
+	var buf bytes.Buffer
+	log := slog.New(r.Handler(slog.NewTextHandler(&buf, nil)))
+	log.With("header", "Bearer s3cr3t-value").Info("calling s3cr3t-value", "err", fmt.Errorf("x s3cr3t-value"))
+
+	if strings.Contains(buf.String(), "s3cr3t-value") {
+		t.Errorf("secret leaked into log: %s", buf.String())
+	}
+}
+
+func TestSecureStore_RedactorLearnsGoflareTokens(t *testing.T) {
+	keyring.MockInit()
+	store := deploy.NewSecureStore(NewMockStore())
+	if err := store.Set("goflare/myproject", "cf-token-123456"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got := store.Redactor().Redact("using cf-token-123456"); got != "using [REDACTED]" {
+		t.Errorf("Redact() = %q", got)
+	}
+}
+
+func TestSecureStore_RedactorPreloadsGoflareToken(t *testing.T) {
+	keyring.MockInit()
+	keyring.Set(deploy.KeyringServiceName, "goflare/site", "cf-token-stored-earlier")
+	base := NewMockStore()
+	base.Set("CF_PROJECT", "site")
+
+	// A fresh store, as after a restart: the token has not been fetched yet.
+	store := deploy.NewSecureStore(base)
+	if got := store.Redactor().Redact("using cf-token-stored-earlier"); got != "using [REDACTED]" {
+		t.Errorf("Redact() = %q", got)
+	}
+}
+
+func TestSSHPusher_RedactsPATInScript(t *testing.T) {
+	keyring.MockInit()
+	keyring.Set(deploy.KeyringServiceName, "DEPLOY_GITHUB_PAT", "ghp_supersecret")
+	plain := NewMockStore()
+	plain.Set("DEPLOY_GITHUB_PAT", "ghp_supersecret")
+
+	for name, store := range map[string]deploy.Store{"secure": deploy.NewSecureStore(NewMockStore()), "plain": plain} {
+		rec := &hookRecorder{}
+		p := &deploy.Puller{Store: store}
+		p.SetLog(rec.Log)
+
+		strat, err := deploy.GetPusher("ssh")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &deploy.Config{Apps: []deploy.AppConfig{{Name: "app", Executable: "app", Path: "/srv/app"}}}
+		if err := strat.Run(cfg, p); err != nil {
+			t.Fatalf("%s: Run() error = %v", name, err)
+		}
+
+		out := rec.Joined()
+		if strings.Contains(out, "ghp_supersecret") {
+			t.Errorf("%s: PAT leaked into script output:\n%s", name, out)
+		}
+		if !strings.Contains(out, "Bearer "+deploy.RedactedMask) {
+			t.Errorf("%s: expected masked Authorization header in script:\n%s", name, out)
+		}
+	}
+}
+
+// leakyDownloader fails with an error that echoes the token.
+type leakyDownloader struct{}
+
+func (leakyDownloader) Download(url, dest, token string) error {
+	return fmt.Errorf("401 for token %s", token)
+}
+
+func TestHandleUpdate_RedactsErrorBody(t *testing.T) {
+	tmpDir := t.TempDir()
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "ghp_leakedtoken")
+
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{{
+				Name:              "app",
+				Executable:        "app.exe",
+				Path:              tmpDir,
+				BusyTimeout:       10 * time.Millisecond,
+				BusyRetryInterval: time.Millisecond,
+			}},
+		},
+		Validator:  deploy.NewHMACValidator("secret"),
+		Downloader: leakyDownloader{},
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+		Redact:     deploy.NewRedactor(),
+	}
+
+	payload := []byte(`{"executable":"app.exe"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(payload)
+	req := httptest.NewRequest("POST", "/update", bytes.NewReader(payload))
+	req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+
+	handler.HandleUpdate(w, req)
+
+	if strings.Contains(w.Body.String(), "ghp_leakedtoken") {
+		t.Errorf("token leaked into response body: %q", w.Body.String())
+	}
+}
@@ -0,0 +1,240 @@
+package deploy_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+// connectRelay starts a relay and a client serving handler as puller id,
+// and waits until the relay sees the client.
+func connectRelay(t *testing.T, id string, handler http.Handler) *httptest.Server {
+	t.Helper()
+	keys := NewMockStore()
+	keys.Set(deploy.RelayTokenKey(id), "token-"+id)
+	relay := httptest.NewServer(&deploy.Relay{Keys: keys})
+	t.Cleanup(relay.Close)
+
+	if resp, err := http.Post(relay.URL+"/r/"+id+"/update", "application/json", nil); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before the puller connects, got %v %v", resp, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	client := &deploy.RelayClient{URL: relay.URL, ID: id, Token: "token-" + id, Handler: handler}
+	go client.Run(ctx)
+
+	// The relay knows the puller once its first poll arrives.
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(relay.URL + "/r/" + id + "/health")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusServiceUnavailable {
+				return relay
+			}
+		}
+	}
+	t.Fatal("puller never connected to the relay")
+	return nil
+}
+
+func TestRelay_ForwardsSignedUpdates(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "app.exe"), []byte("old"), 0755)
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	validator := deploy.NewHMACValidator(githubSecret)
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{{Name: "app", Executable: "app.exe", Path: tmpDir,
+				BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond}},
+		},
+		Validator:  validator,
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/update", handler.HandleUpdate)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) })
+	relay := connectRelay(t, "web1", mux)
+
+	update := func(signature string) *http.Response {
+		body := []byte(`{"executable":"app.exe","tag":"v1.0.0"}`)
+		req, _ := http.NewRequest("POST", relay.URL+"/r/web1/update", bytes.NewReader(body))
+		req.Header.Set("X-Signature", signature)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := update(validator.Sign([]byte(`{"executable":"app.exe","tag":"v1.0.0"}`)))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "Update successful" {
+		t.Errorf("signed update: %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Deploy-Id") == "" {
+		t.Error("expected the deploy ID to be forwarded")
+	}
+	// The puller, not the relay, checks signatures.
+	if resp := update("sha256=00"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("bad signature: status %d, want 401", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", relay.URL+"/r/web1/poll", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	if resp, _ := http.DefaultClient.Do(req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("poll with a wrong token: status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestRelay_StreamsResponses(t *testing.T) {
+	release := make(chan struct{})
+	relay := connectRelay(t, "web2", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		w.Write([]byte("downloading\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("done\n"))
+	}))
+
+	resp, err := http.Post(relay.URL+"/r/web2/update", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewReader(resp.Body)
+	if line, _ := lines.ReadString('\n'); line != "downloading\n" {
+		t.Errorf("first line %q", line)
+	}
+	close(release)
+	if line, _ := lines.ReadString('\n'); line != "done\n" {
+		t.Errorf("last line %q", line)
+	}
+}
+
+func TestRelay_RefusesOIDCTokens(t *testing.T) {
+	issuer := newJWKSServer(t)
+	tmpDir := t.TempDir()
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	dl := NewMockDownloader()
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{{Name: "api", Executable: "api.exe", Path: tmpDir, BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond,
+				OIDC: &deploy.OIDCClaims{Repository: "acme/api"}}},
+		},
+		OIDC:       issuer.verifier(),
+		Downloader: dl,
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/update", handler.HandleUpdate)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	relay := connectRelay(t, "web5", mux)
+
+	// The token is valid, but it does not cover the body the relay passes on.
+	req, _ := http.NewRequest("POST", relay.URL+"/r/web5/update", strings.NewReader(`{"executable":"api.exe","tag":"v1.0.0"}`))
+	req.Header.Set("Authorization", "Bearer "+issuer.token(t, map[string]any{"repository": "acme/api"}))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || len(dl.Downloaded) != 0 {
+		t.Errorf("relayed OIDC request: status %d, downloads %v", resp.StatusCode, dl.Downloaded)
+	}
+}
+
+func TestRelay_UnknownPullers(t *testing.T) {
+	keys := NewMockStore()
+	keys.Set(deploy.RelayTokenKey("ghost"), "ghost-token")
+	keys.Set(deploy.RelayTokenKey("other"), "other-token")
+	relay := httptest.NewServer(&deploy.Relay{Keys: keys})
+	defer relay.Close()
+	do := func(method, path, token string) int {
+		req, _ := http.NewRequest(method, relay.URL+path, strings.NewReader("{}"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Neither CI requests nor rejected polls register a puller.
+	for i := 0; i < 3; i++ {
+		if code := do("POST", "/r/ghost/update", ""); code != http.StatusServiceUnavailable {
+			t.Errorf("request for an unknown puller: status %d, want 503", code)
+		}
+	}
+	if code := do("GET", "/r/ghost/poll", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("poll with a wrong token: status %d", code)
+	}
+	if code := do("POST", "/r/ghost/result/job", "ghost-token"); code != http.StatusGone {
+		t.Errorf("result for an unknown puller: status %d, want 410", code)
+	}
+
+	// A token only opens the endpoints of its own puller.
+	if code := do("GET", "/r/ghost/poll", "other-token"); code != http.StatusUnauthorized {
+		t.Errorf("poll with another puller's token: status %d, want 401", code)
+	}
+	if code := do("POST", "/r/ghost/result/job", "other-token"); code != http.StatusUnauthorized {
+		t.Errorf("result with another puller's token: status %d, want 401", code)
+	}
+	if code := do("GET", "/r/nobody/poll", ""); code != http.StatusUnauthorized {
+		t.Errorf("poll for a puller without a token: status %d, want 401", code)
+	}
+	if code := do("POST", "/r/ghost/update", ""); code != http.StatusServiceUnavailable {
+		t.Errorf("request after a rejected poll: status %d, want 503", code)
+	}
+
+	// Oversized bodies are refused instead of reaching the puller truncated.
+	resp, err := http.Post(relay.URL+"/r/ghost/update", "application/json", io.LimitReader(zeros{}, 25<<20+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d, want 413", resp.StatusCode)
+	}
+}
+
+// zeros is an endless stream of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestValidateBytes_Relay(t *testing.T) {
+	ds := deploy.ValidateBytes("deploy.yaml", []byte("version: 2\nupdater:\n  relay:\n    url: relay.example.com\napps: []\n"))
+	for _, want := range []string{"updater.relay.url", "updater.relay.id"} {
+		if findDiag(ds, want) == nil {
+			t.Errorf("expected a diagnostic for %s, got %v", want, ds)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package deploy_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+func TestConfigWatcher_AppliesValidEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	os.WriteFile(path, []byte("apps:\n  - name: api\n    executable: api\n    path: /tmp\n"), 0644)
+
+	var mu sync.Mutex
+	var applied []*deploy.Config
+	w := &deploy.ConfigWatcher{
+		Path:     path,
+		Interval: 5 * time.Millisecond,
+		Apply: func(c *deploy.Config) error {
+			mu.Lock()
+			defer mu.Unlock()
+			applied = append(applied, c)
+			return nil
+		},
+	}
+	w.Prime()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	os.WriteFile(path, []byte("apps:\n  - name: api\n    executable: api\n    path: /tmp\n  - name: web\n    executable: web\n    path: /tmp\n"), 0644)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(applied)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("edit was not applied")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(applied) != 1 || len(applied[0].Apps) != 2 {
+		t.Errorf("expected one reload with 2 apps, got %d reloads", len(applied))
+	}
+}
+
+func TestConfigWatcher_RejectsInvalidEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	applied := 0
+	w := &deploy.ConfigWatcher{
+		Path:  path,
+		Apply: func(*deploy.Config) error { applied++; return nil },
+	}
+
+	invalid := []string{
+		"apps: [",
+		"apps:\n  - name: a\n    executable: same\n  - name: b\n    executable: same\n",
+		"apps:\n  - name: a\n",
+	}
+	for _, content := range invalid {
+		os.WriteFile(path, []byte(content), 0644)
+		if err := w.Reload(); err == nil {
+			t.Errorf("expected reload of %q to fail", content)
+		}
+	}
+	if applied != 0 {
+		t.Errorf("invalid configs must not be applied, got %d", applied)
+	}
+}
+
+func TestHandler_SetConfigServesNewApps(t *testing.T) {
+	h := newEventsHandler(t, NewMockHealthChecker())
+	tmpDir := h.Config.Apps[0].Path
+	h.ConfigPath = filepath.Join(tmpDir, "deploy.yaml")
+
+	next := *h.Config
+	next.Apps = append([]deploy.AppConfig{}, h.Config.Apps...)
+	next.Apps = append(next.Apps, deploy.AppConfig{
+		Name:              "web",
+		Executable:        "web.exe",
+		Path:              tmpDir,
+		BusyTimeout:       10 * time.Millisecond,
+		BusyRetryInterval: time.Millisecond,
+	})
+	h.SetConfig(&next)
+
+	w := httptest.NewRecorder()
+	payload := `{"executable":"web.exe","tag":"v9"}`
+	sendUpdateTo(h, w, payload)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected new app to deploy, got %d: %s", w.Code, w.Body.String())
+	}
+
+	state, err := (&deploy.StateFile{Path: deploy.StatePath(h.ConfigPath)}).Read()
+	if err != nil {
+		t.Fatalf("read state: %v", err)
+	}
+	if got := state.Apps["web"].Version; got != "v9" {
+		t.Errorf("expected state to record web@v9, got %q", got)
+	}
+}
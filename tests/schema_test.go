@@ -0,0 +1,83 @@
+package deploy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tinywasm/deploy"
+)
+
+// TestSchema_MatchesStructs walks the config types and checks every yaml key
+// has a described property in the schema, and no property is left over.
+func TestSchema_MatchesStructs(t *testing.T) {
+	data, err := deploy.Schema()
+	if err != nil {
+		t.Fatalf("Schema() error = %v", err)
+	}
+	var schema struct {
+		Properties map[string]map[string]any `json:"properties"`
+		Defs       map[string]struct {
+			Properties map[string]map[string]any `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+
+	types := map[string]reflect.Type{"Config": reflect.TypeOf(deploy.Config{})}
+	for _, v := range []any{deploy.ConfigUpdater{}, deploy.RetryConfig{}, deploy.AppConfig{}, deploy.RollbackConfig{}, deploy.NotifierConfig{}} {
+		types[reflect.TypeOf(v).Name()] = reflect.TypeOf(v)
+	}
+	for name, typ := range types {
+		props := schema.Properties
+		if name != "Config" {
+			props = schema.Defs[name].Properties
+		}
+		keys := map[string]bool{}
+		for i := 0; i < typ.NumField(); i++ {
+			if !typ.Field(i).IsExported() {
+				continue
+			}
+			key, _, _ := strings.Cut(typ.Field(i).Tag.Get("yaml"), ",")
+			keys[key] = true
+			p, ok := props[key]
+			if !ok {
+				t.Errorf("%s.%s missing from schema", name, key)
+				continue
+			}
+			if d, _ := p["description"].(string); d == "" {
+				t.Errorf("%s.%s has no description", name, key)
+			}
+		}
+		for key := range props {
+			if !keys[key] {
+				t.Errorf("schema property %s.%s has no struct field", name, key)
+			}
+		}
+	}
+
+	bi := schema.Defs["AppConfig"].Properties["busy_timeout"]
+	if bi["default"] != "5m" {
+		t.Errorf("expected busy_timeout default 5m, got %v", bi["default"])
+	}
+}
+
+// TestSchema_CommittedFileUpToDate keeps deploy.schema.json in the repository
+// in sync; regenerate it with `puller schema -o deploy.schema.json`.
+func TestSchema_CommittedFileUpToDate(t *testing.T) {
+	committed, err := os.ReadFile("../deploy.schema.json")
+	if err != nil {
+		t.Fatalf("read committed schema: %v", err)
+	}
+	data, err := deploy.Schema()
+	if err != nil {
+		t.Fatalf("Schema() error = %v", err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(committed), bytes.TrimSpace(data)) {
+		t.Error("deploy.schema.json is out of date; run `puller schema -o deploy.schema.json`")
+	}
+}
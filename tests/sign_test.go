@@ -0,0 +1,139 @@
+package deploy_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	twctx "github.com/tinywasm/context"
+	"github.com/tinywasm/deploy"
+	"github.com/zalando/go-keyring"
+)
+
+func TestHandleUpdate_Ed25519(t *testing.T) {
+	privPEM, pub, err := deploy.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := deploy.ParseSigningKey(privPEM)
+	if err != nil {
+		t.Fatalf("ParseSigningKey() error = %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "app.exe"), []byte("old"), 0755)
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir, SigningKeys: map[string]string{"ci": pub}},
+			Apps: []deploy.AppConfig{{Name: "app", Executable: "app.exe", Path: tmpDir,
+				BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond}},
+		},
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}
+
+	payload := []byte(`{"executable":"app.exe","tag":"v1.0.0"}`)
+	post := func(body []byte, sig, keyID string) int {
+		req := httptest.NewRequest("POST", "/update", bytes.NewReader(body))
+		req.Header.Set("X-Signature", sig)
+		req.Header.Set(deploy.SignatureKeyIDHeader, keyID)
+		w := httptest.NewRecorder()
+		handler.HandleUpdate(w, req)
+		return w.Code
+	}
+
+	sig := deploy.SignEd25519(key, payload)
+	if !strings.HasPrefix(sig, "ed25519=") {
+		t.Errorf("unexpected signature format %q", sig)
+	}
+	if code := post(payload, sig, "ci"); code != http.StatusOK {
+		t.Errorf("signed request: status %d", code)
+	}
+	if code := post([]byte(`{"executable":"app.exe","tag":"v6.6.6"}`), sig, "ci"); code != http.StatusUnauthorized {
+		t.Errorf("tampered payload: status %d, want 401", code)
+	}
+	if code := post(payload, sig, "old"); code != http.StatusUnauthorized {
+		t.Errorf("unknown key ID: status %d, want 401", code)
+	}
+	if code := post(payload, sig, ""); code != http.StatusUnauthorized {
+		t.Errorf("missing key ID: status %d, want 401", code)
+	}
+}
+
+func TestParsePublicKey_DER(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	got, err := deploy.ParsePublicKey(base64.StdEncoding.EncodeToString(der))
+	if err != nil || !got.Equal(pub) {
+		t.Errorf("ParsePublicKey(DER) = %v, %v", got, err)
+	}
+	if _, err := deploy.ParsePublicKey("not base64!"); err == nil {
+		t.Error("expected error for invalid key")
+	}
+}
+
+func TestValidateBytes_SigningKeys(t *testing.T) {
+	ds := deploy.ValidateBytes("deploy.yaml", []byte("version: 2\nupdater:\n  signing_keys:\n    ci: bm9wZQ==\napps: []\n"))
+	if d := findDiag(ds, "updater.signing_keys.ci"); d == nil || d.Line != 4 {
+		t.Errorf("expected invalid key on line 4, got %v", ds)
+	}
+}
+
+func TestStrategy_WebhookSetup_Ed25519(t *testing.T) {
+	keyring.MockInit()
+	store := deploy.NewSecureStore(NewMockStore())
+	steps := (&deploy.Puller{Store: store}).GetSteps()
+	ctx := twctx.Background()
+
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	os.Chdir(tmpDir)
+	defer os.Chdir(oldWd)
+
+	for i, input := range []string{"webhook", "myserver.com:9000", "", "ghp_testtoken123"} {
+		if ok, err := steps[i].OnInput(input, ctx); err != nil || !ok {
+			t.Fatalf("step %d failed: %v", i, err)
+		}
+	}
+
+	if _, err := store.Get("DEPLOY_HMAC_SECRET"); err == nil {
+		t.Error("expected no HMAC secret")
+	}
+	privPEM, err := os.ReadFile("deploy-signing-key.pem")
+	if err != nil {
+		t.Fatalf("expected private key file: %v", err)
+	}
+	if info, _ := os.Stat("deploy-signing-key.pem"); info.Mode().Perm()&0077 != 0 {
+		t.Errorf("private key readable by others: %v", info.Mode())
+	}
+	cfg, err := deploy.Load("deploy.yaml")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	key, _ := deploy.ParseSigningKey(privPEM)
+	pub, err := deploy.ParsePublicKey(cfg.Updater.SigningKeys["ci"])
+	if err != nil || !pub.Equal(key.Public()) {
+		t.Errorf("deploy.yaml does not hold the public key of the generated pair: %v", err)
+	}
+	workflow, _ := os.ReadFile(filepath.Join(".github", "workflows", "deploy.yml"))
+	for _, want := range []string{"secrets.DEPLOY_SIGNING_KEY", "X-Signature: ed25519=$SIG", "X-Signature-Key-Id: ci", "--data-binary @release.json"} {
+		if !strings.Contains(string(workflow), want) {
+			t.Errorf("workflow lacks %q:\n%s", want, workflow)
+		}
+	}
+	if strings.Contains(string(workflow), "HMAC") {
+		t.Errorf("workflow still uses the HMAC secret:\n%s", workflow)
+	}
+}
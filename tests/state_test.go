@@ -0,0 +1,123 @@
+package deploy_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tinywasm/deploy"
+)
+
+func TestStatePath(t *testing.T) {
+	if got := deploy.StatePath("/etc/deploy/deploy.yaml"); got != "/etc/deploy/deploy.state.json" {
+		t.Errorf("StatePath() = %q", got)
+	}
+}
+
+func TestStateFile_ConcurrentUpdates(t *testing.T) {
+	dir := t.TempDir()
+	f := &deploy.StateFile{Path: filepath.Join(dir, "deploy.state.json")}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := f.Update(func(s *deploy.State) error {
+				s.Apps[fmt.Sprintf("app%d", i)] = deploy.AppState{Version: fmt.Sprintf("v%d", i)}
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Update() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	s, err := f.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(s.Apps) != 20 {
+		t.Errorf("expected 20 apps recorded, got %d", len(s.Apps))
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Errorf("temporary file left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestStateFile_UpdateErrorWritesNothing(t *testing.T) {
+	f := &deploy.StateFile{Path: filepath.Join(t.TempDir(), "deploy.state.json")}
+	err := f.Update(func(s *deploy.State) error {
+		s.Apps["api"] = deploy.AppState{Version: "v1"}
+		return fmt.Errorf("abort")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := os.Stat(f.Path); !os.IsNotExist(err) {
+		t.Errorf("state file written despite error: %v", err)
+	}
+}
+
+const commentedConfig = `# Production puller
+updater:
+  port: 8080 # behind the proxy
+
+apps:
+  # The public API
+  - name: api
+    executable: api
+    path: /srv/api
+    custom_key: kept
+`
+
+func TestEditConfig_PreservesComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	os.WriteFile(path, []byte(commentedConfig), 0600)
+
+	err := deploy.EditConfig(path, func(doc *deploy.ConfigDoc) error {
+		if err := doc.Set("updater.port", 9090); err != nil {
+			return err
+		}
+		if err := doc.Set("apps[0].rollback.enabled", true); err != nil {
+			return err
+		}
+		if !doc.Delete("apps[0].custom_key") {
+			t.Error("Delete() reported missing key")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EditConfig() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	out := string(data)
+	for _, want := range []string{"# Production puller", "port: 9090 # behind the proxy", "# The public API", "enabled: true"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in edited config:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "custom_key") {
+		t.Errorf("deleted key still present:\n%s", out)
+	}
+	if strings.Index(out, "updater:") > strings.Index(out, "apps:") {
+		t.Errorf("key order changed:\n%s", out)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("expected permissions kept, got %v", info.Mode().Perm())
+	}
+
+	if err := deploy.EditConfig(path, func(doc *deploy.ConfigDoc) error {
+		return doc.Set("apps[3].name", "x")
+	}); err == nil {
+		t.Error("expected error for out of range index")
+	}
+}
@@ -0,0 +1,141 @@
+package deploy_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+func signStatus(t *testing.T, secret string, ts time.Time) *http.Request {
+	t.Helper()
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(deploy.StatusSigningPayload(stamp))
+	req := httptest.NewRequest("GET", "/status", nil)
+	req.Header.Set("X-Timestamp", stamp)
+	req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestHandleStatus_ReportsDeploys(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "app.exe"), []byte("old"), 0755)
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{{
+				Name:              "app",
+				Executable:        "app.exe",
+				Path:              tmpDir,
+				BusyTimeout:       10 * time.Millisecond,
+				BusyRetryInterval: time.Millisecond,
+			}},
+		},
+		Validator:  deploy.NewHMACValidator("secret"),
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+		StartedAt:  time.Now().Add(-time.Minute),
+	}
+
+	payload := []byte(`{"executable":"app.exe","tag":"v2.0.0"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(payload)
+	upd := httptest.NewRequest("POST", "/update", bytes.NewReader(payload))
+	upd.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	handler.HandleUpdate(httptest.NewRecorder(), upd)
+
+	w := httptest.NewRecorder()
+	handler.HandleStatus(w, signStatus(t, "secret", time.Now()))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var report deploy.StatusReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.Puller.Version != deploy.Version || report.Puller.UptimeSeconds < 59 {
+		t.Errorf("unexpected puller status: %+v", report.Puller)
+	}
+	if len(report.Apps) != 1 {
+		t.Fatalf("expected 1 app, got %d", len(report.Apps))
+	}
+	app := report.Apps[0]
+	if app.Version != "v2.0.0" || app.Restarts != 1 {
+		t.Errorf("unexpected app status: %+v", app)
+	}
+	if app.LastDeploy == nil || app.LastDeploy.Outcome != deploy.OutcomeSuccess || app.LastDeploy.Tag != "v2.0.0" {
+		t.Errorf("unexpected last deploy: %+v", app.LastDeploy)
+	}
+}
+
+func TestHandleStatus_RequiresFreshSignature(t *testing.T) {
+	handler := &deploy.Handler{
+		Config:    &deploy.Config{},
+		Validator: deploy.NewHMACValidator("secret"),
+	}
+
+	cases := map[string]*http.Request{
+		"unsigned":  httptest.NewRequest("GET", "/status", nil),
+		"wrong key": signStatus(t, "other", time.Now()),
+		"stale":     signStatus(t, "secret", time.Now().Add(-time.Hour)),
+		"future":    signStatus(t, "secret", time.Now().Add(time.Hour)),
+	}
+	for name, req := range cases {
+		w := httptest.NewRecorder()
+		handler.HandleStatus(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", name, w.Code)
+		}
+	}
+}
+
+func TestStatus_ReadsProcessUsage(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process inspection uses /proc")
+	}
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not available")
+	}
+	cmd := exec.Command(sleepPath, "5")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	handler := &deploy.Handler{Config: &deploy.Config{
+		Apps: []deploy.AppConfig{
+			{Name: "sleeper", Executable: filepath.Base(sleepPath), Path: filepath.Dir(sleepPath)},
+			{Name: "namesake", Executable: filepath.Base(sleepPath), Path: t.TempDir()},
+		},
+	}}
+	apps := handler.Status().Apps
+	if st := apps[0]; !st.Running || st.PID == 0 || st.RSSBytes == 0 {
+		t.Errorf("expected running process with usage, got %+v", st)
+	}
+	if apps[1].Running {
+		t.Errorf("a binary of the same name elsewhere was reported: %+v", apps[1])
+	}
+}
@@ -0,0 +1,117 @@
+package deploy_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tinywasm/deploy"
+)
+
+func findDiag(ds deploy.Diagnostics, path string) *deploy.Diagnostic {
+	for i := range ds {
+		if ds[i].Path == path {
+			return &ds[i]
+		}
+	}
+	return nil
+}
+
+func TestValidateBytes_UnknownFieldPosition(t *testing.T) {
+	dir := t.TempDir()
+	data := "updater:\n  port: 8080\napps:\n  - name: api\n    executable: api\n    path: " + dir + "\n    helth_endpoint: http://localhost/h\n"
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(data))
+
+	d := findDiag(ds, "apps[0].helth_endpoint")
+	if d == nil {
+		t.Fatalf("expected unknown field diagnostic, got %v", ds)
+	}
+	if d.Severity != deploy.SeverityError || d.Line != 7 || d.Column != 5 {
+		t.Errorf("unexpected diagnostic %s", d)
+	}
+	if want := "deploy.yaml:7:5: error: apps[0].helth_endpoint: unknown field"; !strings.HasPrefix(d.String(), want) {
+		t.Errorf("String() = %q, want prefix %q", d.String(), want)
+	}
+	if !ds.HasErrors() || ds.Err() == nil {
+		t.Error("expected errors")
+	}
+}
+
+func TestValidateBytes_DuplicateExecutable(t *testing.T) {
+	dir := t.TempDir()
+	data := "apps:\n" +
+		"  - name: a\n    executable: same\n    path: " + dir + "\n    health_endpoint: http://localhost/a\n" +
+		"  - name: b\n    executable: same\n    path: " + dir + "\n    health_endpoint: http://localhost/b\n"
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(data))
+
+	d := findDiag(ds, "apps[1].executable")
+	if d == nil {
+		t.Fatalf("expected duplicate diagnostic, got %v", ds)
+	}
+	if d.Line != 7 || !strings.Contains(d.Message, "also at deploy.yaml:3:17") {
+		t.Errorf("unexpected diagnostic %s", d)
+	}
+}
+
+func TestValidateBytes_SemanticErrors(t *testing.T) {
+	dir := t.TempDir()
+	data := "updater:\n  port: 0\n  log_level: loud\napps:\n  - name: api\n    executable: api\n    path: " + dir +
+		"\n    health_endpoint: http://localhost/h\n    health_timeout: -1s\n"
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(data))
+
+	for path, line := range map[string]int{
+		"updater.port":           2,
+		"updater.log_level":      3,
+		"apps[0].health_timeout": 9,
+	} {
+		d := findDiag(ds, path)
+		if d == nil {
+			t.Errorf("missing diagnostic for %s in %v", path, ds)
+			continue
+		}
+		if d.Severity != deploy.SeverityError || d.Line != line {
+			t.Errorf("unexpected diagnostic %s", d)
+		}
+	}
+}
+
+func TestValidateBytes_TypeErrorLine(t *testing.T) {
+	ds := deploy.ValidateBytes("deploy.yaml", []byte("updater:\n  port: eighty\n"))
+	if len(ds) == 0 || ds[0].Line != 2 || ds[0].Severity != deploy.SeverityError {
+		t.Errorf("expected type error on line 2, got %v", ds)
+	}
+}
+
+func TestValidateBytes_WarningsOnly(t *testing.T) {
+	data := "apps:\n  - name: api\n    executable: api\n    path: /does/not/exist\n"
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(data))
+	if ds.HasErrors() {
+		t.Fatalf("expected only warnings, got %v", ds)
+	}
+	if findDiag(ds, "apps[0].path") == nil || findDiag(ds, "apps[0]") == nil {
+		t.Errorf("expected path and health_endpoint warnings, got %v", ds)
+	}
+}
+
+func TestValidateFile_Clean(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "deploy.yaml")
+	os.WriteFile(path, []byte("version: 2\napps:\n  - name: api\n    executable: api\n    path: "+dir+"\n    health_endpoint: http://localhost/h\n"), 0644)
+
+	ds, err := deploy.ValidateFile(path)
+	if err != nil || len(ds) != 0 {
+		t.Errorf("ValidateFile() = %v, %v; want no diagnostics", ds, err)
+	}
+}
+
+func TestValidateBytes_RelativeHealthEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	app := "version: 2\napps:\n  - name: api\n    executable: api\n    path: " + dir + "\n    health_endpoint: /health\n"
+	if ds := deploy.ValidateBytes("deploy.yaml", []byte(app+"    port: 1200\n")); len(ds) != 0 {
+		t.Errorf("path with a port: %v", ds)
+	}
+	if d := findDiag(deploy.ValidateBytes("deploy.yaml", []byte(app)), "apps[0].health_endpoint"); d == nil || d.Severity != deploy.SeverityError {
+		t.Errorf("path without a port: %v", d)
+	}
+}
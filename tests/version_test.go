@@ -0,0 +1,95 @@
+package deploy_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinywasm/deploy"
+)
+
+func TestHandleUpdate_VersionPolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+	keys := NewMockStore()
+	keys.Set("DEPLOY_GITHUB_PAT", "token")
+	app := func(name, channel, version string, policy *deploy.VersionPolicy) deploy.AppConfig {
+		return deploy.AppConfig{Name: name, Executable: name, Path: tmpDir, Version: version, Channel: channel, VersionPolicy: policy,
+			BusyTimeout: time.Second, BusyRetryInterval: time.Millisecond}
+	}
+	handler := &deploy.Handler{
+		Config: &deploy.Config{
+			Updater: deploy.ConfigUpdater{TempDir: tmpDir},
+			Apps: []deploy.AppConfig{
+				app("api", deploy.ChannelStable, "v1.2.0", &deploy.VersionPolicy{Constraint: ">=1.0, <2"}),
+				app("qa", "beta", "", nil),
+			},
+		},
+		Validator:  deploy.NewHMACValidator("secret"),
+		Downloader: NewMockDownloader(),
+		Process:    NewMockProcessManager(),
+		Checker:    NewMockHealthChecker(),
+		Keys:       keys,
+	}
+
+	// Cases run in order; each successful deploy moves the installed version.
+	for _, tc := range []struct {
+		body   string
+		want   int
+		reason string
+	}{
+		{`{"executable":"api","tag":"v1.3.0"}`, http.StatusOK, ""},
+		{`{"executable":"api","tag":"v1.4.0-beta.1"}`, http.StatusConflict, "not on channel stable"},
+		{`{"executable":"api","tag":"v2.0.0"}`, http.StatusConflict, `does not satisfy ">=1.0, <2"`},
+		{`{"executable":"api","tag":"v1.1.0"}`, http.StatusConflict, "would downgrade v1.3.0"},
+		{`{"executable":"api","tag":"v1.1.0","force":true}`, http.StatusOK, ""},
+		{`{"executable":"api","tag":"nightly"}`, http.StatusConflict, "not a semantic version"},
+		{`{"executable":"qa","tag":"v1.0.0-beta.2"}`, http.StatusOK, ""},
+		{`{"executable":"qa","tag":"v1.0.0-rc.1"}`, http.StatusConflict, "not on channel beta"},
+		{`{"executable":"qa","tag":"v1.0.0"}`, http.StatusOK, ""},
+		{`{"executable":"qa","tag":"v1.0.0-beta.3"}`, http.StatusConflict, "would downgrade"},
+	} {
+		req := httptest.NewRequest("POST", "/update", bytes.NewBufferString(tc.body))
+		req.Header.Set("X-Signature", deploy.NewHMACValidator("secret").Sign([]byte(tc.body)))
+		w := httptest.NewRecorder()
+		handler.HandleUpdate(w, req)
+		if w.Code != tc.want || !strings.Contains(w.Body.String(), tc.reason) {
+			t.Errorf("%s: %d %q, want %d %q", tc.body, w.Code, w.Body, tc.want, tc.reason)
+		}
+	}
+}
+
+func TestPoller_Channel(t *testing.T) {
+	api := newReleaseAPI(t)
+	api.publish("v1.2.0", false)
+	api.publish("v1.3.0-rc.1", true)
+	api.publish("v1.3.0-beta.1", true)
+	dl := NewMockDownloader()
+	p := newPoller(t, api, dl)
+	p.Handler.Config.Apps[0].Channel = "beta"
+
+	if ids := p.Poll(context.Background()); len(ids) != 1 || !strings.HasPrefix(dl.Downloaded[0], api.URL+"/assets/v1.3.0-beta.1 ") {
+		t.Errorf("expected the beta prerelease, got %v", dl.Downloaded)
+	}
+
+	// Stable servers never go back to the newest release below their version.
+	p = newPoller(t, api, dl)
+	p.Handler.Config.Apps[0].Channel = deploy.ChannelStable
+	p.Handler.Config.Apps[0].Version = "v1.2.1"
+	if ids := p.Poll(context.Background()); len(ids) != 0 {
+		t.Errorf("downgraded to %v", dl.Downloaded)
+	}
+}
+
+func TestValidateBytes_VersionPolicy(t *testing.T) {
+	data := "version: 2\napps:\n  - name: api\n    executable: api\n    path: /srv\n    channel: beta.1\n    version_policy:\n      constraint: \">>1\"\n"
+	ds := deploy.ValidateBytes("deploy.yaml", []byte(data))
+	for _, want := range []string{"apps[0].channel", "apps[0].version_policy.constraint"} {
+		if findDiag(ds, want) == nil {
+			t.Errorf("expected a diagnostic for %s, got %v", want, ds)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severity of a configuration diagnostic.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is one finding about a configuration file. Line and Column are
+// 1-based and zero when the position is unknown.
+type Diagnostic struct {
+	Severity Severity
+	File     string
+	Line     int
+	Column   int
+	Path     string // e.g. apps[1].port
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	b.WriteString(d.File)
+	if d.Line > 0 {
+		fmt.Fprintf(&b, ":%d:%d", d.Line, d.Column)
+	}
+	fmt.Fprintf(&b, ": %s: ", d.Severity)
+	if d.Path != "" {
+		b.WriteString(d.Path + ": ")
+	}
+	b.WriteString(d.Message)
+	return b.String()
+}
+
+// Diagnostics is the result of validating a configuration file.
+type Diagnostics []Diagnostic
+
+// HasErrors reports whether any diagnostic is an error.
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Err joins all error diagnostics, or returns nil when there are none.
+func (ds Diagnostics) Err() error {
+	var errs []error
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			errs = append(errs, errors.New(d.String()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateFile validates the configuration at path together with its apps.d
+// fragments. The error is only for failing to read deploy.yaml; problems in
+// the content of any file are diagnostics.
+func ValidateFile(path string) (Diagnostics, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v := newValidator(path)
+	cfg := v.main(data)
+	if cfg == nil {
+		return v.sorted(), nil
+	}
+	files, err := fragmentFiles(cfg.appsDir(path))
+	if err != nil {
+		v.add(SeverityError, "apps_dir", err.Error())
+	}
+	for _, file := range files {
+		v.fragment(file, cfg)
+	}
+	checkConfig(cfg, v.add)
+	return v.sorted(), nil
+}
+
+// yamlLineRe extracts the line number from yaml.v3 error messages.
+var yamlLineRe = regexp.MustCompile(`line (\d+)`)
+
+// ValidateBytes validates configuration content. name is used as the file
+// name in diagnostics. Unknown keys and unset environment references are
+// errors, as are the semantic problems reported by Config.Validate;
+// questionable but workable values are warnings.
+func ValidateBytes(name string, data []byte) Diagnostics {
+	v := newValidator(name)
+	if cfg := v.main(data); cfg != nil {
+		checkConfig(cfg, v.add)
+	}
+	return v.sorted()
+}
+
+type validator struct {
+	file  string // file being read; diagnostics carry the file of their node
+	nodes map[string]posNode
+	diags Diagnostics
+}
+
+// posNode is a value node and the file it was read from.
+type posNode struct {
+	*yaml.Node
+	file string
+}
+
+func newValidator(file string) *validator {
+	return &validator{file: file, nodes: make(map[string]posNode)}
+}
+
+// main checks deploy.yaml content and returns the decoded configuration, or
+// nil when it cannot be decoded.
+func (v *validator) main(data []byte) *Config {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		v.yamlError(err)
+		return nil
+	}
+	if len(doc.Content) == 0 {
+		v.add(SeverityWarning, "", "configuration is empty")
+		return nil
+	}
+	root := doc.Content[0]
+	v.walk(root, reflect.TypeOf(Config{}), "")
+	v.interpolate(root, "")
+
+	var cfg Config
+	if err := root.Decode(&cfg); err != nil {
+		v.yamlError(err)
+		return nil
+	}
+	cfg.mainApps = len(cfg.Apps)
+	return &cfg
+}
+
+// fragment checks one apps.d file and appends its apps to cfg.
+func (v *validator) fragment(file string, cfg *Config) {
+	prev := v.file
+	v.file = file
+	defer func() { v.file = prev }()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		v.diags = append(v.diags, Diagnostic{Severity: SeverityError, File: file, Message: err.Error()})
+		return
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		v.yamlError(err)
+		return
+	}
+	for _, n := range fragmentNodes(&doc) {
+		path := fmt.Sprintf("apps[%d]", len(cfg.Apps))
+		v.walk(n, reflect.TypeOf(AppConfig{}), path)
+		v.interpolate(n, path)
+		var app AppConfig
+		if err := n.Decode(&app); err != nil {
+			v.yamlError(err)
+			continue
+		}
+		cfg.Apps = append(cfg.Apps, app)
+	}
+}
+
+// interpolate expands environment references as Load would. Secrets are left
+// as written: the validator has no Store, and need not see their values.
+func (v *validator) interpolate(n *yaml.Node, path string) {
+	ip := &interpolator{
+		lookupEnv: os.LookupEnv,
+		secret:    func(key string) (string, error) { return SecretScheme + key, nil },
+		report: func(path string, n *yaml.Node, err error) {
+			v.diags = append(v.diags, Diagnostic{Severity: SeverityError, File: v.file,
+				Line: n.Line, Column: n.Column, Path: path, Message: err.Error()})
+		},
+	}
+	eachScalar(n, path, ip.scalar)
+}
+
+// add records a diagnostic at path. related names other entries involved
+// (e.g. the first of two duplicates); their positions are appended to the
+// message.
+func (v *validator) add(sev Severity, path, msg string, related ...string) {
+	d := Diagnostic{Severity: sev, File: v.file, Path: path, Message: msg}
+	if n := v.node(path); n.Node != nil {
+		d.File, d.Line, d.Column = n.file, n.Line, n.Column
+	}
+	for _, r := range related {
+		if n := v.node(r); n.Node != nil {
+			d.Message += fmt.Sprintf(" (also at %s:%d:%d)", n.file, n.Line, n.Column)
+		}
+	}
+	v.diags = append(v.diags, d)
+}
+
+// node returns the node at path, falling back to the closest ancestor.
+func (v *validator) node(path string) posNode {
+	for p := path; ; p = parentPath(p) {
+		if n := v.nodes[p]; n.Node != nil {
+			return n
+		}
+		if p == "" {
+			return posNode{}
+		}
+	}
+}
+
+func (v *validator) yamlError(err error) {
+	msgs := []string{err.Error()}
+	var te *yaml.TypeError
+	if errors.As(err, &te) {
+		msgs = te.Errors
+	}
+	for _, m := range msgs {
+		d := Diagnostic{Severity: SeverityError, File: v.file, Message: strings.TrimPrefix(m, "yaml: ")}
+		if match := yamlLineRe.FindStringSubmatch(m); match != nil {
+			d.Line, _ = strconv.Atoi(match[1])
+		}
+		v.diags = append(v.diags, d)
+	}
+}
+
+func (v *validator) sorted() Diagnostics {
+	sort.SliceStable(v.diags, func(i, j int) bool { return v.diags[i].Line < v.diags[j].Line })
+	return v.diags
+}
+
+// walk records node positions by path and reports keys that do not map to
+// a field of t, and explicit zero ports (which Load would silently replace).
+func (v *validator) walk(n *yaml.Node, t reflect.Type, path string) {
+	v.nodes[path] = posNode{n, v.file}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return // type errors are reported by Decode
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			child := joinPath(path, key.Value)
+			f, ok := fields[key.Value]
+			if !ok {
+				v.nodes[child] = posNode{key, v.file}
+				v.add(SeverityError, child, fmt.Sprintf("unknown field %q", key.Value))
+				continue
+			}
+			if key.Value == "port" && val.Kind == yaml.ScalarNode && val.Value == "0" {
+				v.nodes[child] = posNode{val, v.file}
+				v.add(SeverityError, child, "port must be between 1 and 65535")
+			}
+			v.walk(val, f.Type, child)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range n.Content {
+			v.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v.walk(n.Content[i+1], t.Elem(), joinPath(path, n.Content[i].Value))
+		}
+	}
+}
+
+// yamlFields maps yaml keys to the struct fields they decode into.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func parentPath(p string) string {
+	if i := strings.LastIndexAny(p, ".["); i >= 0 {
+		return p[:i]
+	}
+	return ""
+}
+
+// checkConfig runs the semantic checks shared by Config.Validate and the
+// position-aware validator.
+func checkConfig(c *Config, report func(sev Severity, path, msg string, related ...string)) {
+	switch {
+	case c.SchemaVersion > ConfigVersion:
+		report(SeverityError, "version", fmt.Sprintf("version %d is newer than this puller supports (%d)", c.SchemaVersion, ConfigVersion))
+	case c.SchemaVersion < ConfigVersion:
+		report(SeverityWarning, "version", fmt.Sprintf("config version %d is outdated; run `puller migrate` to upgrade to %d", max(c.SchemaVersion, 1), ConfigVersion))
+	}
+
+	u := c.Updater
+	if u.Port < 0 || u.Port > 65535 {
+		report(SeverityError, "updater.port", fmt.Sprintf("port %d out of range", u.Port))
+	}
+	if _, err := ParseLogLevel(u.LogLevel); err != nil {
+		report(SeverityError, "updater.log_level", fmt.Sprintf("unknown level %q (want debug, info, warn or error)", u.LogLevel))
+	}
+	switch strings.ToLower(u.LogFormat) {
+	case "", "text", "json":
+	default:
+		report(SeverityError, "updater.log_format", fmt.Sprintf("unknown format %q (want text or json)", u.LogFormat))
+	}
+	if u.LogMaxSize < 0 {
+		report(SeverityError, "updater.log_max_size", "must not be negative")
+	}
+	if u.LogMaxBackups < 0 {
+		report(SeverityError, "updater.log_max_backups", "must not be negative")
+	}
+	if u.Retry.MaxAttempts < 0 {
+		report(SeverityError, "updater.retry.max_attempts", "must not be negative")
+	}
+	if u.Retry.Delay < 0 {
+		report(SeverityError, "updater.retry.delay", "must not be negative")
+	}
+	for _, id := range sortedKeys(u.SigningKeys) {
+		if _, err := ParsePublicKey(u.SigningKeys[id]); err != nil {
+			report(SeverityError, "updater.signing_keys."+id, strings.TrimPrefix(err.Error(), "deploy: "))
+		}
+	}
+	if u.OIDC != nil {
+		if u.OIDC.Audience == "" {
+			report(SeverityError, "updater.oidc.audience", "is required; any token of the issuer would be accepted otherwise")
+		}
+		if u.OIDC.CacheTTL < 0 {
+			report(SeverityError, "updater.oidc.jwks_cache_ttl", "duration must not be negative")
+		}
+	}
+	if r := u.Relay; r != nil {
+		if ru, err := url.Parse(r.URL); err != nil || ru.Scheme == "" || ru.Host == "" {
+			report(SeverityError, "updater.relay.url", fmt.Sprintf("invalid URL %q", r.URL))
+		}
+		if r.ID == "" || strings.ContainsAny(r.ID, "/?#") {
+			report(SeverityError, "updater.relay.id", fmt.Sprintf("invalid puller ID %q", r.ID))
+		}
+	}
+	if u.Poll != nil {
+		if u.Poll.Interval < 0 {
+			report(SeverityError, "updater.poll.interval", "duration must not be negative")
+		}
+		if pu, err := url.Parse(u.Poll.APIURL); u.Poll.APIURL != "" && (err != nil || pu.Scheme == "" || pu.Host == "") {
+			report(SeverityError, "updater.poll.api_url", fmt.Sprintf("invalid URL %q", u.Poll.APIURL))
+		}
+	}
+
+	// at names the file an app was loaded from, when known, so duplicates
+	// across apps.d fragments point at both files.
+	at := func(i int) string {
+		if o := c.Apps[i].origin; o != "" {
+			return " at " + o
+		}
+		return ""
+	}
+	names := make(map[string]int)
+	exes := make(map[string]int)
+	for i, app := range c.Apps {
+		p := fmt.Sprintf("apps[%d]", i)
+		if app.Name == "" {
+			report(SeverityWarning, p, "name is empty; logs and metrics will not identify this app")
+		} else if first, ok := names[app.Name]; ok {
+			report(SeverityError, p+".name", fmt.Sprintf("duplicate name %q%s, first used by apps[%d]%s", app.Name, at(i), first, at(first)),
+				fmt.Sprintf("apps[%d].name", first))
+		} else {
+			names[app.Name] = i
+		}
+		if app.Executable == "" {
+			report(SeverityError, p, "executable is required")
+		} else if first, ok := exes[app.Executable]; ok {
+			report(SeverityError, p+".executable", fmt.Sprintf("duplicate executable %q%s, first used by apps[%d]%s", app.Executable, at(i), first, at(first)),
+				fmt.Sprintf("apps[%d].executable", first))
+		} else {
+			exes[app.Executable] = i
+		}
+		if app.Version != "" && c.SchemaVersion >= 2 {
+			report(SeverityWarning, p+".version", "deployed versions are kept in the state file since config version 2")
+		}
+		if app.Path == "" {
+			report(SeverityError, p, "path is required")
+		} else if _, err := os.Stat(app.Path); err != nil {
+			report(SeverityWarning, p+".path", fmt.Sprintf("directory %q does not exist on this machine", app.Path))
+		}
+		if app.Port < 0 || app.Port > 65535 {
+			report(SeverityError, p+".port", fmt.Sprintf("port %d out of range", app.Port))
+		}
+		if app.HealthEndpoint == "" {
+			report(SeverityWarning, p, "no health_endpoint; post-deploy health checks will fail")
+		} else if strings.HasPrefix(app.HealthEndpoint, "/") {
+			if app.Port == 0 {
+				report(SeverityError, p+".health_endpoint", fmt.Sprintf("path %q needs a port to resolve against localhost", app.HealthEndpoint))
+			}
+		} else if u, err := url.Parse(app.HealthEndpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			report(SeverityError, p+".health_endpoint", fmt.Sprintf("invalid URL %q", app.HealthEndpoint))
+		}
+		for _, d := range []struct {
+			field string
+			value time.Duration
+		}{
+			{"health_timeout", app.HealthTimeout},
+			{"startup_delay", app.StartupDelay},
+			{"busy_retry_interval", app.BusyRetryInterval},
+			{"busy_timeout", app.BusyTimeout},
+		} {
+			if d.value < 0 {
+				report(SeverityError, p+"."+d.field, "duration must not be negative")
+			}
+		}
+		if app.Rollback.KeepVersions < 0 {
+			report(SeverityError, p+".rollback.keep_versions", "must not be negative")
+		}
+		if a := app.Allow; a != nil {
+			for _, l := range []struct {
+				field    string
+				patterns []string
+			}{{"repos", a.Repos}, {"tags", a.Tags}, {"url_hosts", a.URLHosts}} {
+				for j, pat := range l.patterns {
+					if _, err := path.Match(pat, ""); err != nil {
+						report(SeverityError, fmt.Sprintf("%s.allow.%s[%d]", p, l.field, j), fmt.Sprintf("invalid pattern %q", pat))
+					}
+				}
+			}
+			if a.Secret && app.Name == "" {
+				report(SeverityError, p+".allow.secret", "requires a name, which selects the app's secret")
+			}
+			for j, id := range a.SigningKeys {
+				if _, ok := u.SigningKeys[id]; !ok {
+					report(SeverityError, fmt.Sprintf("%s.allow.signing_keys[%d]", p, j), fmt.Sprintf("unknown signing key %q; add it to updater.signing_keys", id))
+				}
+			}
+		}
+		switch Source(app.Source) {
+		case "", SourceGitHub, SourceGitLab, SourceGitea:
+		default:
+			report(SeverityError, p+".source", fmt.Sprintf("unknown source %q (want github, gitlab or gitea)", app.Source))
+		}
+		if app.Repo != "" && !validRepo(app.source(), app.Repo) {
+			report(SeverityError, p+".repo", fmt.Sprintf("%q is not owner/name", app.Repo))
+		}
+		if app.Repo != "" && app.source() == SourceGitea && (u.Poll == nil || u.Poll.APIURL == "") {
+			report(SeverityError, p+".repo", "polling gitea releases requires updater.poll.api_url")
+		}
+		if _, err := path.Match(app.Asset, ""); err != nil {
+			report(SeverityError, p+".asset", fmt.Sprintf("invalid pattern %q", app.Asset))
+		}
+		if app.Channel != "" && !validChannel(app.Channel) {
+			report(SeverityError, p+".channel", fmt.Sprintf("invalid channel %q; use a prerelease name such as beta, or stable", app.Channel))
+		}
+		if vp := app.VersionPolicy; vp != nil && vp.Constraint != "" {
+			if _, err := parseConstraint(vp.Constraint); err != nil {
+				report(SeverityError, p+".version_policy.constraint", err.Error())
+			}
+		}
+		if app.OIDC != nil {
+			if app.OIDC.Repository == "" {
+				report(SeverityError, p+".oidc.repository", "is required; tokens of any repository would be accepted otherwise")
+			}
+			if u.OIDC == nil {
+				report(SeverityWarning, p+".oidc", "has no effect while updater.oidc is not configured")
+			}
+			for _, pat := range []string{app.OIDC.Repository, app.OIDC.Ref, app.OIDC.Environment, app.OIDC.Workflow} {
+				if _, err := path.Match(pat, ""); err != nil {
+					report(SeverityError, p+".oidc", fmt.Sprintf("invalid pattern %q", pat))
+				}
+			}
+		}
+		if app.Rollback.AutoRollbackOnFailure && !app.Rollback.Enabled {
+			report(SeverityWarning, p+".rollback", "auto_rollback_on_failure has no effect while enabled is false")
+		}
+	}
+
+	for _, name := range sortedKeys(c.Environments) {
+		env := c.Environments[name]
+		p := "environments." + name
+		if env.Method != "" {
+			if _, err := GetPusher(env.Method); err != nil {
+				methods := AvailablePushers()
+				sort.Strings(methods)
+				report(SeverityError, p+".method", fmt.Sprintf("unknown deploy method %q (want %s)", env.Method, strings.Join(methods, ", ")))
+			}
+		}
+		for i, app := range env.Apps {
+			if app.Name == "" {
+				report(SeverityError, fmt.Sprintf("%s.apps[%d]", p, i), "name is required to select the app to override")
+			}
+		}
+	}
+
+	for i, n := range c.Notifications {
+		if err := n.check(); err != nil {
+			report(SeverityError, fmt.Sprintf("notifications[%d]", i), err.Error())
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package deploy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ChannelStable is the channel of apps deploying releases only.
+const ChannelStable = "stable"
+
+// VersionPolicy restricts the versions an app may be updated to. Setting it
+// or a channel makes the puller require semantic version tags and refuse
+// downgrades:
+//
+//	apps:
+//	  - name: api
+//	    channel: beta        # stable releases and -beta prereleases
+//	    version_policy:
+//	      constraint: ">=1.4, <2"
+type VersionPolicy struct {
+	Constraint     string `yaml:"constraint"`      // e.g. ">=1.4.0, <2" or "^1.4 || ^2"
+	Prerelease     bool   `yaml:"prerelease"`      // accept prereleases of any channel
+	AllowDowngrade bool   `yaml:"allow_downgrade"` // deploy older versions without force
+}
+
+// semver is a parsed semantic version; build metadata is dropped.
+type semver struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+// parseSemver parses tag as a semantic version with an optional "v"
+// prefix. Missing minor and patch numbers are zero.
+func parseSemver(tag string) (semver, bool) {
+	s := strings.TrimPrefix(strings.TrimPrefix(tag, "v"), "V")
+	s, _, _ = strings.Cut(s, "+")
+	s, pre, hasPre := strings.Cut(s, "-")
+	var v semver
+	nums := strings.Split(s, ".")
+	if len(nums) > 3 {
+		return v, false
+	}
+	for i, n := range nums {
+		if n == "" || strings.Trim(n, "0123456789") != "" {
+			return v, false
+		}
+		x, err := strconv.Atoi(n)
+		if err != nil {
+			return v, false
+		}
+		switch i {
+		case 0:
+			v.major = x
+		case 1:
+			v.minor = x
+		case 2:
+			v.patch = x
+		}
+	}
+	if hasPre {
+		if pre == "" {
+			return v, false
+		}
+		v.pre = strings.Split(pre, ".")
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 as v orders before, with or after w, a
+// prerelease ordering before its release.
+func (v semver) compare(w semver) int {
+	for _, d := range [][2]int{{v.major, w.major}, {v.minor, w.minor}, {v.patch, w.patch}} {
+		if d[0] != d[1] {
+			return cmpInt(d[0], d[1])
+		}
+	}
+	switch {
+	case len(v.pre) == 0 && len(w.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(w.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(w.pre); i++ {
+		a, b := v.pre[i], w.pre[i]
+		if a == b {
+			continue
+		}
+		x, errA := strconv.Atoi(a)
+		y, errB := strconv.Atoi(b)
+		switch {
+		case errA == nil && errB == nil:
+			return cmpInt(x, y)
+		case errA == nil: // numeric identifiers order first
+			return -1
+		case errB == nil:
+			return 1
+		}
+		return strings.Compare(a, b)
+	}
+	return cmpInt(len(v.pre), len(w.pre))
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// channel returns the name of the prerelease channel of v ("beta" for
+// 1.2.0-beta.3 and 1.2.0-beta3), or ChannelStable for a release.
+func (v semver) channel() string {
+	if len(v.pre) == 0 {
+		return ChannelStable
+	}
+	return strings.TrimRight(v.pre[0], "0123456789")
+}
+
+// validChannel reports whether name can be the channel of a prerelease:
+// letters and hyphens, as in 1.2.0-beta.1.
+func validChannel(name string) bool {
+	return name != "" && strings.Trim(name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-") == ""
+}
+
+// versionConstraint is a set of alternatives, each a list of comparisons
+// that must all hold.
+type versionConstraint [][]versionComparison
+
+type versionComparison struct {
+	op string
+	v  semver
+}
+
+// parseConstraint parses comparisons joined by "," (and) and "||" (or).
+// Operators are =, !=, >, >=, <, <=, ~ (same minor) and ^ (same major, or
+// same minor below 1.0).
+func parseConstraint(s string) (versionConstraint, error) {
+	var c versionConstraint
+	for _, alt := range strings.Split(s, "||") {
+		var all []versionComparison
+		for _, term := range strings.Split(alt, ",") {
+			term = strings.TrimSpace(term)
+			op := ""
+			for _, o := range []string{">=", "<=", "!=", ">", "<", "=", "~", "^"} {
+				if strings.HasPrefix(term, o) {
+					op = o
+					break
+				}
+			}
+			v, ok := parseSemver(strings.TrimSpace(term[len(op):]))
+			if !ok {
+				return nil, fmt.Errorf("invalid version comparison %q", term)
+			}
+			if op == "" {
+				op = "="
+			}
+			switch op {
+			case "~":
+				all = append(all, versionComparison{">=", v}, versionComparison{"<", semver{major: v.major, minor: v.minor + 1}})
+			case "^":
+				upper := semver{major: v.major + 1}
+				if v.major == 0 {
+					upper = semver{minor: v.minor + 1}
+				}
+				all = append(all, versionComparison{">=", v}, versionComparison{"<", upper})
+			default:
+				all = append(all, versionComparison{op, v})
+			}
+		}
+		c = append(c, all)
+	}
+	return c, nil
+}
+
+// allows reports whether v satisfies c.
+func (c versionConstraint) allows(v semver) bool {
+	for _, all := range c {
+		ok := true
+		for _, cmp := range all {
+			d := v.compare(cmp.v)
+			switch cmp.op {
+			case "=":
+				ok = d == 0
+			case "!=":
+				ok = d != 0
+			case ">":
+				ok = d > 0
+			case ">=":
+				ok = d >= 0
+			case "<":
+				ok = d < 0
+			case "<=":
+				ok = d <= 0
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVersionPolicy reports whether app restricts the versions it deploys.
+func (app *AppConfig) hasVersionPolicy() bool {
+	return app.Channel != "" || app.VersionPolicy != nil
+}
+
+// acceptsPrereleases reports whether app may deploy some prereleases, so
+// pollers must not skip releases marked as such.
+func (app *AppConfig) acceptsPrereleases() bool {
+	if !app.hasVersionPolicy() {
+		return false
+	}
+	return (app.Channel != "" && app.Channel != ChannelStable) || (app.VersionPolicy != nil && app.VersionPolicy.Prerelease)
+}
+
+// checkVersion checks the tag of req against the channel and version
+// policy of app, and refuses a downgrade of the installed version unless
+// the policy allows it or req is forced.
+func checkVersion(app *AppConfig, installed string, req *UpdateRequest) error {
+	if !app.hasVersionPolicy() {
+		return nil
+	}
+	v, ok := parseSemver(req.Tag)
+	if !ok {
+		return fmt.Errorf("tag %q is not a semantic version", req.Tag)
+	}
+	p := app.VersionPolicy
+	if p == nil {
+		p = &VersionPolicy{}
+	}
+	if ch := v.channel(); ch != ChannelStable && !p.Prerelease && ch != app.Channel {
+		channel := app.Channel
+		if channel == "" {
+			channel = ChannelStable
+		}
+		return fmt.Errorf("prerelease %s is not on channel %s", req.Tag, channel)
+	}
+	if p.Constraint != "" {
+		c, err := parseConstraint(p.Constraint)
+		if err != nil {
+			return fmt.Errorf("version_policy.constraint: %w", err)
+		}
+		if !c.allows(v) {
+			return fmt.Errorf("%s does not satisfy %q", req.Tag, p.Constraint)
+		}
+	}
+	if cur, ok := parseSemver(installed); ok && v.compare(cur) < 0 && !p.AllowDowngrade && !req.Force {
+		return fmt.Errorf("%s would downgrade %s; set force to deploy it anyway", req.Tag, installed)
+	}
+	return nil
+}
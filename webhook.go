@@ -1,10 +1,14 @@
 package deploy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
+	"time"
 
-	"github.com/tinywasm/context"
+	twctx "github.com/tinywasm/context"
 	"github.com/tinywasm/wizard"
 )
 
@@ -17,38 +21,96 @@ type WebhookTrigger struct{}
 func (s *WebhookTrigger) Name() string { return "webhook" }
 
 func (s *WebhookTrigger) Run(cfg *Config, p *Puller) error {
-	hmacSecret, err := p.Store.Get("DEPLOY_HMAC_SECRET")
-	if err != nil || hmacSecret == "" {
+	// With OIDC or signing keys configured, the HMAC secret is optional.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var validator *HMACValidator
+	keys, err := LoadHMACKeys(p.store())
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		validator = NewHMACKeySet(keys)
+		for _, k := range keys {
+			p.Secrets().Add(k.Secret)
+		}
+		go p.refreshHMACKeys(ctx, validator)
+	} else if cfg.Updater.OIDC == nil && len(cfg.Updater.SigningKeys) == 0 {
 		return fmt.Errorf("deploy: HMAC secret not configured")
 	}
+	var verifier *OIDCVerifier
+	if cfg.Updater.OIDC != nil {
+		verifier = NewOIDCVerifier(*cfg.Updater.OIDC)
+	}
 
-	handler := &Handler{
-		Config:     cfg,
-		ConfigPath: p.ConfigPath,
-		Validator:  NewHMACValidator(hmacSecret),
-		Downloader: p.Downloader,
-		Process:    p.Process,
-		Checker:    p.Checker,
-		Keys:       p.Store,
+	handler, stop, err := p.newHandler(cfg, func(next *Config) {
+		if next.Updater.Port != cfg.Updater.Port {
+			p.Logger().Warn("updater.port change requires a restart", "active", cfg.Updater.Port, "configured", next.Updater.Port)
+		}
+		if !reflect.DeepEqual(next.Updater.OIDC, cfg.Updater.OIDC) {
+			p.Logger().Warn("updater.oidc change requires a restart")
+		}
+	})
+	if err != nil {
+		return err
 	}
+	defer stop()
+	handler.Validator = validator
+	handler.OIDC = verifier
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/update", handler.HandleUpdate)
+	mux.HandleFunc("/github", handler.HandleGitHub)
+	mux.HandleFunc("/gitlab", handler.HandleGitLab)
+	mux.HandleFunc("/gitea", handler.HandleGitea)
+	mux.Handle("/metrics", handler.Metrics)
+	mux.HandleFunc("/status", handler.HandleStatus)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
+	if cfg.Updater.Relay != nil {
+		return p.serveRelay(cfg.Updater.Relay, mux)
+	}
 	addr := fmt.Sprintf(":%d", cfg.Updater.Port)
 	p.logger("Starting puller agent on", addr)
 	return http.ListenAndServe(addr, mux)
 }
 
+// hmacKeysRefresh is how often a running puller rereads its HMAC key set,
+// so keys added by `puller rotate` are accepted without a restart.
+const hmacKeysRefresh = time.Minute
+
+func (p *Puller) refreshHMACKeys(ctx context.Context, v *HMACValidator) {
+	t := time.NewTicker(hmacKeysRefresh)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		keys, err := LoadHMACKeys(p.store())
+		if err == nil && len(keys) == 0 {
+			err = errors.New("deploy: HMAC key set is empty; keeping the loaded keys")
+		}
+		if err != nil {
+			p.Logger().Warn("reload HMAC keys", "error", err)
+			continue
+		}
+		for _, k := range keys {
+			p.Secrets().Add(k.Secret)
+		}
+		v.SetKeys(keys)
+	}
+}
+
 func (s *WebhookTrigger) WizardSteps(store Store, log func(...any)) []*wizard.Step {
 	return []*wizard.Step{
 		{
 			LabelText: "Server host:port for webhook daemon (e.g. myserver.com:9000)",
-			OnInputFn: func(input string, ctx *context.Context) (bool, error) {
+			OnInputFn: func(input string, ctx *twctx.Context) (bool, error) {
 				if input == "" {
 					return false, fmt.Errorf("host cannot be empty")
 				}
@@ -57,8 +119,11 @@ func (s *WebhookTrigger) WizardSteps(store Store, log func(...any)) []*wizard.St
 			},
 		},
 		{
-			LabelText: "HMAC Secret (min 32 chars, used to validate webhook from GitHub)",
-			OnInputFn: func(input string, ctx *context.Context) (bool, error) {
+			LabelText: "HMAC Secret (min 32 chars), or empty to sign deploys with an ed25519 key instead (recommended)",
+			OnInputFn: func(input string, ctx *twctx.Context) (bool, error) {
+				if input == "" {
+					return true, nil // keygen in the last step
+				}
 				if len(input) < 32 {
 					return false, fmt.Errorf("secret must be at least 32 characters")
 				}
@@ -68,7 +133,7 @@ func (s *WebhookTrigger) WizardSteps(store Store, log func(...any)) []*wizard.St
 		},
 		{
 			LabelText: "GitHub PAT (ghp_... or github_pat_... — needs repo read access)",
-			OnInputFn: func(input string, ctx *context.Context) (bool, error) {
+			OnInputFn: func(input string, ctx *twctx.Context) (bool, error) {
 				if input == "" {
 					return false, fmt.Errorf("PAT cannot be empty")
 				}
@@ -81,12 +146,24 @@ func (s *WebhookTrigger) WizardSteps(store Store, log func(...any)) []*wizard.St
 				// and it's package-private, we can either call it or move it.
 				// For now, let's call a shared helper or move the logic here.
 				host := ctx.Value(ctxServerHost)
-				if err := writeGHAWorkflow("webhook", host, ""); err != nil {
+				keyID := ""
+				if ctx.Value(ctxHMAC) == "" {
+					keyID = defaultSigningKeyID
+				}
+				if err := writeGHAWorkflow("webhook", host, keyID); err != nil {
 					return false, err
 				}
 				if err := CreateDefaultConfig("deploy.yaml"); err != nil {
 					return false, err
 				}
+				if keyID != "" {
+					if err := setupSigningKey("deploy.yaml", keyID); err != nil {
+						return false, err
+					}
+					log("Private signing key written to " + signingKeyFile + ": add it as the DEPLOY_SIGNING_KEY repository secret, then delete it.")
+				} else {
+					log("Instead of the workflow, a repository webhook can trigger deploys: payload URL http://" + host + "/github, content type application/json, the HMAC secret, events Releases and Workflow runs.")
+				}
 				return true, nil
 			},
 		},
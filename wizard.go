@@ -43,10 +43,8 @@ func (p *Puller) GetSteps() []*wizard.Step {
 		},
 		OnInputFn: func(input string, ctx *context.Context) (bool, error) {
 			method := strings.TrimSpace(input)
-			// DEPRECATED: Handle legacy inputs by mapping them to "cloudflarePages".
-			// Kept for backward compatibility with old configuration prompts.
-			if strings.EqualFold(method, "cloudflare") || strings.EqualFold(method, "edgeworker") {
-				method = "cloudflarePages"
+			if current, ok := legacyMethods[strings.ToLower(method)]; ok {
+				method = current
 			}
 
 			strat, err := GetPusher(method)
@@ -57,15 +55,15 @@ func (p *Puller) GetSteps() []*wizard.Step {
 			method = strat.Name() // Ensure correct casing matches pusher definition
 
 			ctx.Set(ctxMethod, method)
-			if err := p.Store.Set("DEPLOY_METHOD", method); err != nil {
+			if err := p.store().Set("DEPLOY_METHOD", method); err != nil {
 				return false, fmt.Errorf("store method: %w", err)
 			}
 
 			// If provider supports this method, use its wizard steps
 			if p.Provider != nil && p.Provider.Supports(method) {
-				dynamic.steps = p.Provider.WizardSteps(p.Store, p.log)
+				dynamic.steps = p.Provider.WizardSteps(p.store(), p.Secrets().Func(p.emit))
 			} else {
-				dynamic.steps = strat.WizardSteps(p.Store, p.log) // pass the file logger for actual execution logs
+				dynamic.steps = strat.WizardSteps(p.store(), p.Secrets().Func(p.emit)) // pass the file logger for actual execution logs
 			}
 			return true, nil
 		},
@@ -110,6 +108,9 @@ type dynamicSteps struct {
 
 // ── file generation ───────────────────────────────────────────────────────────
 
+// writeGHAWorkflow writes .github/workflows/deploy.yml unless it exists. For
+// the webhook method, extra is the ID of the ed25519 key that signs the
+// request; empty signs with the HMAC secret.
 func writeGHAWorkflow(method, host, extra string) error {
 	dir := ".github/workflows"
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -123,6 +124,32 @@ func writeGHAWorkflow(method, host, extra string) error {
 	var content string
 	switch method {
 	case "webhook":
+		if extra != "" {
+			content = fmt.Sprintf(`name: Deploy
+on:
+  push:
+    branches: [main]
+
+jobs:
+  deploy:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - name: Trigger deploy webhook
+        env:
+          DEPLOY_SIGNING_KEY: ${{ secrets.DEPLOY_SIGNING_KEY }}
+        run: |
+          printf '%%s\n' "$DEPLOY_SIGNING_KEY" > signing-key.pem
+          SIG=$(openssl pkeyutl -sign -inkey signing-key.pem -rawin -in release.json | base64 -w0)
+          rm signing-key.pem
+          curl -X POST http://%s/update \
+            -H "X-Signature: ed25519=$SIG" \
+            -H "X-Signature-Key-Id: %s" \
+            -H "Content-Type: application/json" \
+            --data-binary @release.json
+`, host, extra)
+			break
+		}
 		content = fmt.Sprintf(`name: Deploy
 on:
   push:
@@ -165,6 +192,27 @@ jobs:
 	return os.WriteFile(path, []byte(content), 0644)
 }
 
+const (
+	defaultSigningKeyID = "ci"
+	signingKeyFile      = "deploy-signing-key.pem"
+)
+
+// setupSigningKey generates an ed25519 key pair, writes the private key to
+// signingKeyFile for the user to move into the CI secrets, and registers the
+// public key as keyID in the configuration at configPath.
+func setupSigningKey(configPath, keyID string) error {
+	priv, pub, err := GenerateSigningKey()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(signingKeyFile, priv, 0600); err != nil {
+		return err
+	}
+	return EditConfig(configPath, func(d *ConfigDoc) error {
+		return d.Set("updater.signing_keys."+keyID, pub)
+	})
+}
+
 // CreateDefaultConfig creates a default deploy.yaml if it does not exist.
 func CreateDefaultConfig(path string) error {
 	if _, err := os.Stat(path); err == nil {
@@ -173,7 +221,7 @@ func CreateDefaultConfig(path string) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
-	return os.WriteFile(path, []byte(`updater:
+	return os.WriteFile(path, []byte(fmt.Sprintf("version: %d\n", ConfigVersion)+`updater:
   port: 8080
   log_level: info
   temp_dir: ./temp